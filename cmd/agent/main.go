@@ -57,11 +57,7 @@ func main() {
 				logger.Trace("send metrics is stopped")
 				return
 			case <-t.C:
-				a.SendMetricsHTTP(ctx)
-
-				if cfg.Settings.AddressGRPC != "" {
-					a.SendMetricsGRPC(ctx)
-				}
+				a.SendMetrics(ctx)
 			}
 		}
 	}()
@@ -0,0 +1,40 @@
+// Команда redis-migrate переносит метрики Redis из прежних форматов хранения в текущую схему.
+// Настройки подключения те же, что у сервера: REDIS_ADDR, REDIS_PASSWORD, REDIS_DB.
+package main
+
+import (
+	"context"
+	"flag"
+
+	"github.com/nickzhog/devops-tool/internal/server/config"
+	"github.com/nickzhog/devops-tool/internal/server/service/redis"
+	"github.com/nickzhog/devops-tool/pkg/logging"
+	redis_client "github.com/nickzhog/devops-tool/pkg/redis"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "only count keys to migrate")
+	cfg := config.GetConfig()
+	logger := logging.GetLogger()
+
+	if cfg.RedisStorage.Addr == "" {
+		logger.Fatal("redis address is required")
+	}
+
+	ctx := context.Background()
+	client := redis_client.NewClient(ctx,
+		cfg.RedisStorage.Addr,
+		cfg.RedisStorage.Password,
+		cfg.RedisStorage.DB)
+
+	stats, err := redis.NewRepository(client, logger, cfg).Migrate(ctx, *dryRun)
+	if err != nil {
+		logger.Fatalf("migration error: %s", err.Error())
+	}
+
+	action := "migrated"
+	if *dryRun {
+		action = "to migrate"
+	}
+	logger.Infof("%s: %d metrics, %d histories, %d keys failed", action, stats.Metrics, stats.History, stats.Failed)
+}
@@ -6,8 +6,12 @@ import (
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 
+	"github.com/nickzhog/devops-tool/internal/server/alert"
+	"github.com/nickzhog/devops-tool/internal/server/auth"
 	"github.com/nickzhog/devops-tool/internal/server/config"
+	"github.com/nickzhog/devops-tool/internal/server/notify"
 	"github.com/nickzhog/devops-tool/internal/server/server"
 	"github.com/nickzhog/devops-tool/internal/server/server/grpc"
 	web "github.com/nickzhog/devops-tool/internal/server/server/http"
@@ -20,8 +24,18 @@ import (
 	"github.com/nickzhog/devops-tool/pkg/logging"
 	"github.com/nickzhog/devops-tool/pkg/postgres"
 	redis_client "github.com/nickzhog/devops-tool/pkg/redis"
+	"github.com/nickzhog/devops-tool/pkg/retry"
 )
 
+// alertInterval - интервал вычисления правил оповещений, если он не задан в файле правил.
+const alertInterval = 15 * time.Second
+
+// partitionInterval - интервал обслуживания секций metric_samples в Postgres.
+const partitionInterval = 10 * time.Minute
+
+// rollupInterval - интервал агрегации истории в Postgres.
+const rollupInterval = time.Minute
+
 func main() {
 	cfg := config.GetConfig()
 	logger := logging.GetLogger()
@@ -39,6 +53,7 @@ func main() {
 	}()
 
 	var storage service.Storage
+	var postgresClient postgres.Client
 	switch {
 
 	case cfg.PostgresStorage.DatabaseDSN != "":
@@ -47,11 +62,20 @@ func main() {
 		if err != nil {
 			logger.Fatalf("migration error: %s", err.Error())
 		}
-		postgresClient, err := postgres.NewClient(ctx, 2, cfg.PostgresStorage.DatabaseDSN)
+		postgresClient, err = postgres.NewClient(ctx, 2, cfg.PostgresStorage.DatabaseDSN)
 		if err != nil {
 			logger.Fatalf("db error: %s", err.Error())
 		}
-		storage = db.NewRepository(postgresClient, logger, cfg)
+		repository := db.NewRepository(postgresClient, logger, cfg)
+		if cfg.Settings.HistoryRetention > 0 {
+			err = repository.MaintainPartitions(ctx, time.Now())
+			if err != nil {
+				logger.Errorf("maintain sample partitions: %s", err.Error())
+			}
+			go repository.RunPartitions(ctx, partitionInterval)
+			go repository.RunRollups(ctx, rollupInterval)
+		}
+		storage = repository
 
 	case cfg.RedisStorage.Addr != "":
 		logger.Trace("redis storage")
@@ -63,12 +87,64 @@ func main() {
 
 	default:
 		logger.Trace("inmemory storage")
-		storage = cache.NewMemStorage()
+		storage = cache.NewMemStorage(cfg.Settings.HistoryRetention)
 	}
 
 	srv := server.NewServer(logger, cfg, storage)
 
+	switch {
+	case cfg.Settings.AuthKeysDB:
+		if postgresClient == nil {
+			logger.Fatal("api keys in postgres require database dsn")
+		}
+		srv.KeyStore = auth.NewPostgresStore(postgresClient)
+
+	case cfg.Settings.AuthKeysFile != "":
+		keys, err := auth.NewFileStore(cfg.Settings.AuthKeysFile, logger)
+		if err != nil {
+			logger.Fatalf("api keys error: %s", err.Error())
+		}
+		go keys.Run(ctx, 10*time.Second)
+		srv.KeyStore = keys
+	}
+
 	wg := new(sync.WaitGroup)
+
+	if cfg.Settings.AlertRules != "" {
+		rules, err := alert.LoadConfig(cfg.Settings.AlertRules)
+		if err != nil {
+			logger.Fatalf("alert rules error: %s", err.Error())
+		}
+		interval := rules.Interval.Duration
+		if interval <= 0 {
+			interval = alertInterval
+		}
+
+		dispatcher, err := notify.NewDispatcher(rules.Receivers, notify.Options{
+			Retry: retry.Policy{
+				MaxAttempts: cfg.Settings.NotifyRetryMaxAttempts,
+				BaseDelay:   cfg.Settings.NotifyRetryBaseDelay,
+				MaxDelay:    cfg.Settings.NotifyRetryMaxDelay,
+				Jitter:      0.2,
+			},
+			DeadLetterLog: cfg.Settings.NotifyDeadLetterLog,
+		}, logger)
+		if err != nil {
+			logger.Fatalf("alert receivers error: %s", err.Error())
+		}
+
+		srv.AlertEngine = alert.NewEngine(rules, srv, dispatcher, logger)
+		wg.Add(2)
+		go func() {
+			dispatcher.Run(ctx)
+			wg.Done()
+		}()
+		go func() {
+			srv.AlertEngine.Run(ctx, interval)
+			wg.Done()
+		}()
+	}
+
 	wg.Add(2)
 	go func() {
 		web.Serve(ctx, *srv, cfg)
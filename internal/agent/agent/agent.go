@@ -3,24 +3,38 @@ package agent
 import (
 	"context"
 	"crypto/rsa"
-	"encoding/json"
+	"crypto/tls"
 	"fmt"
+	"net/http"
 	"sync"
+	"time"
 
 	pb "github.com/nickzhog/devops-tool/internal/proto"
 
+	"github.com/nickzhog/devops-tool/internal/agent/collector"
 	"github.com/nickzhog/devops-tool/internal/agent/config"
 	grpcclient "github.com/nickzhog/devops-tool/internal/agent/grpc_client"
+	"github.com/nickzhog/devops-tool/internal/agent/spool"
 	"github.com/nickzhog/devops-tool/pkg/encryption"
 	"github.com/nickzhog/devops-tool/pkg/logging"
 	"github.com/nickzhog/devops-tool/pkg/metric"
+	"github.com/nickzhog/devops-tool/pkg/retry"
+	"github.com/nickzhog/devops-tool/pkg/tlsconfig"
+	"google.golang.org/grpc"
 )
 
 var _ Agent = (*agent)(nil)
 
+const (
+	minBackoff = time.Second
+	maxBackoff = time.Minute * 5
+
+	defaultRequestTimeout = time.Second * 2
+)
+
 type Agent interface {
 	UpdateMetrics()
-	SendMetricsHTTP(ctx context.Context)
+	SendMetrics(ctx context.Context)
 	ImportMetrics([]metric.Metric) error
 	ExportMetrics() []metric.Metric
 }
@@ -31,137 +45,176 @@ type agent struct {
 
 	publicKey *rsa.PublicKey
 
+	labels metric.Labels
+
+	httpClient *http.Client
 	grpcClient pb.MetricsClient
+	grpcStream *grpcclient.Stream
+
+	collectors []collector.Collector
+
+	httpRetry retry.Policy
+	grpcRetry retry.Policy
 
-	gaugeMetrics   map[string]float64
-	counterMetrics map[string]int64
-	mutex          *sync.RWMutex
+	spool   *spool.Spool  // очередь неотправленных пакетов, nil - очередь отключена
+	retryAt time.Time     // время следующей попытки отправить очередь
+	backoff time.Duration // текущая пауза между попытками
+
+	spoolDropped uint64 // количество пакетов, удаленных очередью, на момент последней проверки
+
+	metrics map[string]metric.Metric // метрики по ключу тип:имя{labels}
+	acked   map[string]int64         // значения counter, доставленные на сервер
+	resync  map[string]bool          // counter, которые нужно отправить полным значением
+	mutex   *sync.RWMutex
 }
 
 func NewAgent(cfg *config.Config, logger *logging.Logger) *agent {
 	agent := &agent{
-		cfg:            cfg,
-		logger:         logger,
-		mutex:          new(sync.RWMutex),
-		gaugeMetrics:   make(map[string]float64),
-		counterMetrics: make(map[string]int64),
+		cfg:     cfg,
+		logger:  logger,
+		mutex:   new(sync.RWMutex),
+		metrics: make(map[string]metric.Metric),
+		acked:   make(map[string]int64),
+		resync:  make(map[string]bool),
 	}
 
-	if cfg.Settings.CryptoKey != "" {
-		pubKey, err := encryption.NewPublicKey(cfg.Settings.CryptoKey)
-		if err != nil {
-			logger.Fatal(err)
+	switch cfg.Settings.Transport {
+	case "":
+		cfg.Settings.Transport = TransportBatch
+		if cfg.Settings.AddressGRPC != "" {
+			cfg.Settings.Transport = TransportGRPC
 		}
-
-		agent.publicKey = pubKey
+	case TransportURL, TransportJSON, TransportBatch:
+	case TransportGRPC, TransportStream:
+		if cfg.Settings.AddressGRPC == "" {
+			logger.Fatalf("transport %s requires grpc address", cfg.Settings.Transport)
+		}
+	default:
+		logger.Fatalf("unknown transport: %s", cfg.Settings.Transport)
 	}
 
-	if cfg.Settings.AddressGRPC != "" {
-		agent.grpcClient = grpcclient.NewClient(cfg.Settings.AddressGRPC)
+	labels, err := cfg.MetricLabels()
+	if err != nil {
+		logger.Fatal(err)
 	}
+	agent.labels = labels
 
-	return agent
-}
-
-func (a *agent) UpdateMetrics() {
-	a.mutex.Lock()
-	defer a.mutex.Unlock()
-
-	setGaugeMetrics(a.gaugeMetrics)
-
-	a.counterMetrics["PollCount"]++
-}
-
-func (a *agent) SendMetricsHTTP(ctx context.Context) {
-	var url string
-	var answer []byte
-	var err error
-
-	metrics := a.ExportMetrics()
-
-	jsonMetrics, err := json.Marshal(metrics)
+	collectors, err := collector.New(cfg.Settings.Collectors)
 	if err != nil {
-		a.logger.Error(err)
+		logger.Fatal(err)
 	}
+	agent.collectors = collectors
 
-	a.mutex.RLock()
-	defer a.mutex.RUnlock()
-
-	for k, v := range a.gaugeMetrics {
-		url = fmt.Sprintf("%s/update/gauge/%s/%v", a.cfg.Settings.Address, k, v)
+	retryable, err := httpRetryable(cfg)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	agent.httpRetry = agent.newRetryPolicy("http", retryable)
 
-		a.sendRequest(ctx, url, nil)
+	retryable, err = grpcRetryable(cfg)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	agent.grpcRetry = agent.newRetryPolicy("grpc", retryable)
 
-		////
+	if cfg.Settings.SpoolDir != "" {
+		// очередь хранит готовые пакеты /updates/, остальные способы отправки ее не используют
+		if cfg.Settings.Transport != TransportBatch {
+			logger.Fatalf("spool is supported only by transport %s, got %s", TransportBatch, cfg.Settings.Transport)
+		}
+		agent.spool, err = spool.Open(cfg.Settings.SpoolDir, cfg.Settings.SpoolMaxSize, cfg.Settings.SpoolMaxAge)
+		if err != nil {
+			logger.Fatal(err)
+		}
+	}
 
-		url = fmt.Sprintf("%s/update", a.cfg.Settings.Address)
+	agent.httpClient = http.DefaultClient
+	if cfg.Settings.TLSCA != "" || cfg.Settings.TLSCert != "" {
+		tlsConfig, err := tlsconfig.Client(cfg.Settings.TLSCA, cfg.Settings.TLSCert, cfg.Settings.TLSKey, "")
+		if err != nil {
+			logger.Fatal(err)
+		}
+		agent.httpClient = &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			},
+		}
+	}
 
-		metric := metric.NewGaugeMetric(k, v)
-		metric.Hash = metric.GetHash(a.cfg.Settings.Key)
+	if cfg.Settings.CryptoKey != "" {
+		pubKey, err := encryption.NewPublicKey(cfg.Settings.CryptoKey)
+		if err != nil {
+			logger.Fatal(err)
+		}
 
-		body, _ := json.Marshal(metric)
-		answer, err = a.sendRequest(ctx, url, body)
+		agent.publicKey = pubKey
 	}
 
-	for k, v := range a.counterMetrics {
-		url = fmt.Sprintf("%s/update/counter/%s/%v", a.cfg.Settings.Address, k, v)
-
-		a.sendRequest(ctx, url, nil)
+	if cfg.Settings.AddressGRPC != "" {
+		var opts []grpc.DialOption
+		if agent.publicKey != nil {
+			opts = append(opts, grpc.WithDefaultCallOptions(grpc.ForceCodec(encryption.NewClientCodec(agent.publicKey))))
+		}
 
-		////
+		var tlsConfig *tls.Config
+		if cfg.GRPCTLSEnabled() {
+			tlsConfig, err = tlsconfig.Client(cfg.Settings.GRPCTLSCA, cfg.Settings.GRPCTLSCert,
+				cfg.Settings.GRPCTLSKey, cfg.Settings.GRPCTLSServerName)
+			if err != nil {
+				logger.Fatal(err)
+			}
+		}
+		agent.grpcClient = grpcclient.NewClient(cfg.Settings.AddressGRPC, tlsConfig, opts...)
 
-		url = fmt.Sprintf("%s/update", a.cfg.Settings.Address)
+		if cfg.Settings.Transport == TransportStream {
+			agent.grpcStream = grpcclient.NewStream(agent.grpcClient, cfg.Settings.StreamWindow, agent.grpcMetadata())
+		}
+	}
 
-		metric := metric.NewCounterMetric(k, v)
-		metric.Hash = metric.GetHash(a.cfg.Settings.Key)
+	return agent
+}
 
-		body, _ := json.Marshal(metric)
-		answer, err = a.sendRequest(ctx, url, body)
+// UpdateMetrics опрашивает сборщики метрик и обновляет значения gauge.
+// Ошибка одного сборщика не мешает остальным.
+func (a *agent) UpdateMetrics() {
+	collected := make([]metric.Metric, 0)
+	for _, c := range a.collectors {
+		metrics, err := c.Collect()
+		if err != nil {
+			a.logger.Errorf("collector %s: %s", c.Name(), err.Error())
+			continue
+		}
+		collected = append(collected, metrics...)
 	}
 
-	a.logger.Tracef("metrics sended to: %s, last err: %v, last answer: %s", a.cfg.Settings.Address, err, answer)
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
 
-	url = fmt.Sprintf("%s/updates/", a.cfg.Settings.Address)
-	_, err = a.sendRequest(ctx, url, jsonMetrics)
-	if err != nil {
-		a.logger.Error(err)
+	for _, m := range collected {
+		key := storageKey(m)
+		if _, ok := a.metrics[key]; !ok && m.MType == metric.CounterType {
+			a.resync[key] = true
+		}
+		a.metrics[key] = m
 	}
-}
 
-func (a *agent) SendMetricsGRPC(ctx context.Context) error {
-	a.mutex.RLock()
-	defer a.mutex.RUnlock()
+	a.addCounter("PollCount", nil, 1)
+}
 
-	var request pb.SetMetricsRequest
-	for k, v := range a.gaugeMetrics {
-		metric := metric.NewGaugeMetric(k, v)
-		metric.Hash = metric.GetHash(a.cfg.Settings.Key)
-
-		request.Metrics = append(request.Metrics, &pb.Metric{
-			Id:    metric.ID,
-			Mtype: pb.MType_gauge,
-			Value: *metric.Value,
-			Hash:  metric.Hash,
-		})
-	}
-	for k, v := range a.counterMetrics {
-		metric := metric.NewCounterMetric(k, v)
-		metric.Hash = metric.GetHash(a.cfg.Settings.Key)
-
-		request.Metrics = append(request.Metrics, &pb.Metric{
-			Id:    metric.ID,
-			Mtype: pb.MType_counter,
-			Delta: *metric.Delta,
-			Hash:  metric.Hash,
-		})
-	}
-	_, err := a.grpcClient.SetMetrics(ctx, &request)
-	if err != nil {
-		a.logger.Error(err)
-		return err
+// addCounter увеличивает счетчик агента. Вызывается под блокировкой на запись.
+// Новый счетчик (в том числе после запуска агента) отправляется полным значением,
+// чтобы сервер заменил значение, оставшееся от прежнего запуска агента.
+func (a *agent) addCounter(name string, labels metric.Labels, delta int64) {
+	m := metric.NewCounterMetric(name, delta)
+	m.Labels = labels
+	key := storageKey(m)
+	if old, ok := a.metrics[key]; ok {
+		*m.Delta += *old.Delta
+	} else {
+		a.resync[key] = true
 	}
-
-	return nil
+	a.metrics[key] = m
 }
 
 func (a *agent) ImportMetrics(metrics []metric.Metric) error {
@@ -171,9 +224,11 @@ func (a *agent) ImportMetrics(metrics []metric.Metric) error {
 	for _, m := range metrics {
 		switch m.MType {
 		case metric.CounterType:
-			a.counterMetrics[m.ID] = *m.Delta
+			a.metrics[storageKey(m)] = metric.Metric{ID: m.ID, MType: m.MType, Delta: m.Delta, Labels: m.Labels}
+			// значение, известное серверу, неизвестно - передаем полное значение
+			a.resync[storageKey(m)] = true
 		case metric.GaugeType:
-			a.gaugeMetrics[m.ID] = *m.Value
+			a.metrics[storageKey(m)] = metric.Metric{ID: m.ID, MType: m.MType, Value: m.Value, Labels: m.Labels}
 		default:
 			return fmt.Errorf("wrong metric type: %s", m.MType)
 		}
@@ -182,21 +237,23 @@ func (a *agent) ImportMetrics(metrics []metric.Metric) error {
 	return nil
 }
 
+// ExportMetrics возвращает копии метрик агента с общими labels из конфига и хэшем.
 func (a *agent) ExportMetrics() []metric.Metric {
 	a.mutex.RLock()
 	defer a.mutex.RUnlock()
 
-	metrics := make([]metric.Metric, 0, len(a.counterMetrics)+len(a.gaugeMetrics))
-	for k, v := range a.gaugeMetrics {
-		m := metric.NewGaugeMetric(k, v)
-		if a.cfg.Settings.Key != "" {
-			m.Hash = m.GetHash(a.cfg.Settings.Key)
+	metrics := make([]metric.Metric, 0, len(a.metrics))
+	for _, v := range a.metrics {
+		var m metric.Metric
+		switch v.MType {
+		case metric.GaugeType:
+			m = metric.NewGaugeMetric(v.ID, *v.Value)
+		case metric.CounterType:
+			m = metric.NewCounterMetric(v.ID, *v.Delta)
+		default:
+			continue
 		}
-
-		metrics = append(metrics, m)
-	}
-	for k, v := range a.counterMetrics {
-		m := metric.NewCounterMetric(k, v)
+		m.Labels = mergeLabels(a.labels, v.Labels)
 		if a.cfg.Settings.Key != "" {
 			m.Hash = m.GetHash(a.cfg.Settings.Key)
 		}
@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"io"
 	"net/http"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/jarcoal/httpmock"
 	"github.com/nickzhog/devops-tool/internal/agent/config"
@@ -14,7 +16,7 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-func Test_agent_SendMetricsHTTP(t *testing.T) {
+func Test_agent_SendMetrics(t *testing.T) {
 	cfg := &config.Config{}
 	logger := logging.GetLogger()
 	cfg.Settings.Address = "http://localhost"
@@ -40,7 +42,7 @@ func Test_agent_SendMetricsHTTP(t *testing.T) {
 
 			assert := assert.New(t)
 
-			httpmock.RegisterResponder(http.MethodPost, "http://localhost/update",
+			httpmock.RegisterResponder(http.MethodPost, "http://localhost/updates/",
 				func(req *http.Request) (*http.Response, error) {
 					body, err := io.ReadAll(req.Body)
 					if err != nil {
@@ -48,7 +50,7 @@ func Test_agent_SendMetricsHTTP(t *testing.T) {
 						return httpmock.NewStringResponse(http.StatusBadRequest, ""), err
 					}
 
-					var m metric.Metric
+					var m []metric.Metric
 					err = json.Unmarshal(body, &m)
 					if err != nil {
 						assert.Fail(err.Error())
@@ -67,8 +69,8 @@ func Test_agent_SendMetricsHTTP(t *testing.T) {
 			err = agentStorage.ImportMetrics(metrics)
 			assert.NoError(err)
 
-			agentStorage.SendMetricsHTTP(context.Background())
-
+			agentStorage.SendMetrics(context.Background())
+			assert.Equal(1, httpmock.GetTotalCallCount())
 		})
 	}
 }
@@ -111,3 +113,351 @@ func Test_agent_ImportMetrics(t *testing.T) {
 		})
 	}
 }
+
+func Test_agent_SendMetrics_Spool(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Settings.Address = "http://localhost"
+	cfg.Settings.SpoolDir = t.TempDir()
+	logger := logging.GetLogger()
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	assert := assert.New(t)
+
+	available := false
+	var received []int64
+	httpmock.RegisterResponder(http.MethodPost, "http://localhost/updates/",
+		func(req *http.Request) (*http.Response, error) {
+			if !available {
+				return httpmock.NewStringResponse(http.StatusServiceUnavailable, ""), nil
+			}
+
+			var metrics []metric.Metric
+			err := json.NewDecoder(req.Body).Decode(&metrics)
+			assert.NoError(err)
+			if assert.Len(metrics, 1) {
+				received = append(received, *metrics[0].Delta)
+			}
+
+			return httpmock.NewStringResponse(http.StatusOK, ""), nil
+		})
+
+	a := NewAgent(cfg, logger)
+	for _, delta := range []int64{1, 2} {
+		err := a.ImportMetrics([]metric.Metric{metric.NewCounterMetric("test", delta)})
+		assert.NoError(err)
+
+		a.SendMetrics(context.Background())
+	}
+	assert.Equal(2, a.spool.Len())
+	assert.Empty(received)
+
+	available = true
+	a.retryAt = time.Time{}
+	err := a.ImportMetrics([]metric.Metric{metric.NewCounterMetric("test", 3)})
+	assert.NoError(err)
+	a.SendMetrics(context.Background())
+
+	assert.Equal(0, a.spool.Len())
+	assert.Equal([]int64{1, 2, 3}, received)
+}
+
+func Test_agent_SendMetrics_SpoolRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		code int
+		kept bool
+	}{
+		{name: "bad request", code: http.StatusBadRequest, kept: false},
+		{name: "unauthorized", code: http.StatusUnauthorized, kept: true},
+		{name: "forbidden", code: http.StatusForbidden, kept: true},
+		{name: "request timeout", code: http.StatusRequestTimeout, kept: true},
+		{name: "too many requests", code: http.StatusTooManyRequests, kept: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.Settings.Address = "http://localhost"
+			cfg.Settings.SpoolDir = t.TempDir()
+
+			httpmock.Activate()
+			defer httpmock.DeactivateAndReset()
+
+			httpmock.RegisterResponder(http.MethodPost, "http://localhost/updates/",
+				httpmock.NewStringResponder(tt.code, ""))
+
+			a := NewAgent(cfg, logging.GetLogger())
+			err := a.ImportMetrics([]metric.Metric{metric.NewCounterMetric("test", 1)})
+			assert.NoError(t, err)
+			a.SendMetrics(context.Background())
+
+			if tt.kept {
+				assert.Equal(t, 1, a.spool.Len())
+			} else {
+				assert.Equal(t, 0, a.spool.Len())
+			}
+
+			// недоставленное значение counter будет отправлено полным значением
+			a.mutex.RLock()
+			assert.True(t, a.resync[storageKey(metric.NewCounterMetric("test", 1))])
+			a.mutex.RUnlock()
+		})
+	}
+}
+
+func Test_agent_sendRequest_Retry(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Settings.RetryMaxAttempts = 3
+	cfg.Settings.RetryBaseDelay = time.Millisecond
+	cfg.Settings.RetryHTTPCodes = "503"
+	logger := logging.GetLogger()
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	assert := assert.New(t)
+
+	httpmock.RegisterResponder(http.MethodPost, "http://localhost/unavailable",
+		httpmock.ResponderFromMultipleResponses([]*http.Response{
+			httpmock.NewStringResponse(http.StatusServiceUnavailable, ""),
+			httpmock.NewStringResponse(http.StatusOK, "ok"),
+		}))
+	httpmock.RegisterResponder(http.MethodPost, "http://localhost/bad",
+		httpmock.NewStringResponder(http.StatusBadRequest, "bad"))
+
+	a := NewAgent(cfg, logger)
+
+	answer, err := a.sendRequest(context.Background(), "http://localhost/unavailable", nil)
+	assert.NoError(err)
+	assert.Equal("ok", string(answer))
+
+	_, err = a.sendRequest(context.Background(), "http://localhost/bad", nil)
+	assert.Error(err)
+	assert.Equal(3, httpmock.GetTotalCallCount())
+
+	metrics := a.ExportMetrics()
+	if assert.Len(metrics, 1) {
+		assert.Equal(retriesMetric, metrics[0].ID)
+		assert.Equal(int64(1), *metrics[0].Delta)
+		assert.Equal(metric.Labels{"transport": "http"}, metrics[0].Labels)
+	}
+}
+
+func Test_agent_SendMetrics_Deltas(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Settings.Address = "http://localhost"
+	logger := logging.GetLogger()
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	assert := assert.New(t)
+
+	available := true
+	var received []metric.Metric
+	httpmock.RegisterResponder(http.MethodPost, "http://localhost/updates/",
+		func(req *http.Request) (*http.Response, error) {
+			if !available {
+				return httpmock.NewStringResponse(http.StatusServiceUnavailable, ""), nil
+			}
+
+			var metrics []metric.Metric
+			err := json.NewDecoder(req.Body).Decode(&metrics)
+			assert.NoError(err)
+			received = append(received, metrics...)
+
+			return httpmock.NewStringResponse(http.StatusOK, ""), nil
+		})
+
+	a := NewAgent(cfg, logger)
+	poll := func(n int64) {
+		a.mutex.Lock()
+		defer a.mutex.Unlock()
+		a.addCounter("PollCount", nil, n)
+	}
+	send := func() *metric.Metric {
+		received = nil
+		a.SendMetrics(context.Background())
+		if len(received) < 1 {
+			return nil
+		}
+		return &received[0]
+	}
+
+	// после запуска агента counter отправляется полным значением
+	poll(3)
+	m := send()
+	if assert.NotNil(m) {
+		assert.Equal(int64(3), *m.Delta)
+		assert.True(m.Absolute)
+	}
+
+	// дальше отправляется только приращение с момента последней отправки
+	poll(2)
+	m = send()
+	if assert.NotNil(m) {
+		assert.Equal(int64(2), *m.Delta)
+		assert.False(m.Absolute)
+	}
+
+	// без изменений нечего отправлять
+	assert.Nil(send())
+
+	// после ошибки отправки counter отправляется полным значением
+	poll(1)
+	available = false
+	send()
+	available = true
+	poll(1)
+	m = send()
+	if assert.NotNil(m) {
+		assert.Equal(int64(7), *m.Delta)
+		assert.True(m.Absolute)
+	}
+
+	// после импорта counter отправляется полным значением
+	err := a.ImportMetrics([]metric.Metric{metric.NewCounterMetric("PollCount", 100)})
+	assert.NoError(err)
+	m = send()
+	if assert.NotNil(m) {
+		assert.Equal(int64(100), *m.Delta)
+		assert.True(m.Absolute)
+	}
+	poll(1)
+	m = send()
+	if assert.NotNil(m) {
+		assert.Equal(int64(1), *m.Delta)
+		assert.False(m.Absolute)
+	}
+}
+
+func Test_agent_SendMetrics_ServerRestart(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Settings.Address = "http://localhost"
+	logger := logging.GetLogger()
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	assert := assert.New(t)
+
+	// сервер с хранилищем в памяти: приращения прибавляются, полные значения заменяют сохраненное
+	available := true
+	stored := make(map[string]int64)
+	httpmock.RegisterResponder(http.MethodPost, "http://localhost/updates/",
+		func(req *http.Request) (*http.Response, error) {
+			if !available {
+				return httpmock.NewStringResponse(http.StatusServiceUnavailable, ""), nil
+			}
+
+			var metrics []metric.Metric
+			err := json.NewDecoder(req.Body).Decode(&metrics)
+			assert.NoError(err)
+			for _, m := range metrics {
+				if m.Absolute {
+					stored[m.ID] = *m.Delta
+					continue
+				}
+				stored[m.ID] += *m.Delta
+			}
+
+			return httpmock.NewStringResponse(http.StatusOK, ""), nil
+		})
+
+	poll := func(a *agent, n int64) {
+		a.mutex.Lock()
+		defer a.mutex.Unlock()
+		a.addCounter("PollCount", nil, n)
+	}
+
+	// значение, оставшееся от прежнего запуска агента, заменяется
+	stored["PollCount"] = 100
+	a := NewAgent(cfg, logger)
+	poll(a, 3)
+	a.SendMetrics(context.Background())
+	assert.Equal(int64(3), stored["PollCount"])
+
+	poll(a, 2)
+	a.SendMetrics(context.Background())
+	assert.Equal(int64(5), stored["PollCount"])
+
+	// сервер перезапускается и теряет значения
+	available = false
+	stored = make(map[string]int64)
+	poll(a, 1)
+	a.SendMetrics(context.Background())
+
+	available = true
+	poll(a, 1)
+	a.SendMetrics(context.Background())
+	assert.Equal(int64(7), stored["PollCount"])
+
+	poll(a, 1)
+	a.SendMetrics(context.Background())
+	assert.Equal(int64(8), stored["PollCount"])
+}
+
+func Test_agent_SendMetrics_Transport(t *testing.T) {
+	tests := []struct {
+		name      string
+		transport string
+		batchSize int
+		wantCalls map[string]int
+	}{
+		{
+			name:      "url",
+			transport: TransportURL,
+			wantCalls: map[string]int{
+				"POST http://localhost/update/gauge/g1/1.5": 1,
+				"POST http://localhost/update/gauge/g2/2":   1,
+				"POST http://localhost/update/counter/c1/3": 1,
+			},
+		},
+		{
+			name:      "json",
+			transport: TransportJSON,
+			wantCalls: map[string]int{"POST http://localhost/update": 3},
+		},
+		{
+			name:      "batch",
+			transport: TransportBatch,
+			batchSize: 2,
+			wantCalls: map[string]int{"POST http://localhost/updates/": 2},
+		},
+		{
+			name:      "batch unlimited",
+			transport: TransportBatch,
+			wantCalls: map[string]int{"POST http://localhost/updates/": 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpmock.Activate()
+			defer httpmock.DeactivateAndReset()
+
+			assert := assert.New(t)
+
+			cfg := &config.Config{}
+			cfg.Settings.Address = "http://localhost"
+			cfg.Settings.Transport = tt.transport
+			cfg.Settings.BatchSize = tt.batchSize
+
+			for call := range tt.wantCalls {
+				method, url, _ := strings.Cut(call, " ")
+				httpmock.RegisterResponder(method, url, httpmock.NewStringResponder(http.StatusOK, ""))
+			}
+
+			a := NewAgent(cfg, logging.GetLogger())
+			err := a.ImportMetrics([]metric.Metric{
+				metric.NewGaugeMetric("g1", 1.5),
+				metric.NewGaugeMetric("g2", 2),
+				metric.NewCounterMetric("c1", 3),
+			})
+			assert.NoError(err)
+
+			a.SendMetrics(context.Background())
+			assert.Equal(tt.wantCalls, httpmock.GetCallCountInfo())
+		})
+	}
+}
@@ -3,16 +3,15 @@ package agent
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
-	"math/rand"
-	"net"
 	"net/http"
-	"runtime"
 	"strings"
 	"time"
 
 	"github.com/nickzhog/devops-tool/pkg/encryption"
-	"github.com/shirou/gopsutil/mem"
+	"github.com/nickzhog/devops-tool/pkg/metric"
+	"github.com/nickzhog/devops-tool/pkg/retry"
 )
 
 func (a *agent) sendRequest(ctx context.Context, url string, postData []byte) ([]byte, error) {
@@ -30,71 +29,81 @@ func (a *agent) sendRequest(ctx context.Context, url string, postData []byte) ([
 		postData = newPostData
 	}
 
-	ctx, cancel := context.WithTimeout(ctx, time.Second*2)
-	defer cancel()
+	var answer []byte
+	err := a.httpRetry.Do(ctx, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, a.requestTimeout())
+		defer cancel()
 
-	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(postData))
-	if err != nil {
-		return nil, err
-	}
+		request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(postData))
+		if err != nil {
+			return retry.Permanent(err)
+		}
 
-	addrs, err := net.InterfaceAddrs()
-	if err != nil {
-		return nil, err
-	}
-	ip := strings.Split(addrs[0].String(), "/")
-	request.Header.Add("X-Real-IP", ip[0])
+		if a.cfg.Settings.AgentID != "" {
+			request.Header.Set("X-Agent-ID", a.cfg.Settings.AgentID)
+		}
+		if a.cfg.Settings.Token != "" {
+			request.Header.Set("Authorization", "Bearer "+a.cfg.Settings.Token)
+		}
 
-	res, err := http.DefaultClient.Do(request)
-	if err != nil {
-		return nil, err
-	}
-	defer res.Body.Close()
+		res, err := a.httpClient.Do(request)
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
 
-	answer, err := io.ReadAll(res.Body)
+		answer, err = io.ReadAll(res.Body)
+		if err != nil {
+			return err
+		}
+		if res.StatusCode >= http.StatusBadRequest {
+			return &statusError{code: res.StatusCode, body: answer}
+		}
+
+		return nil
+	})
 
 	return answer, err
 }
 
-func setGaugeMetrics(m map[string]float64) {
-	var memstat runtime.MemStats
-	runtime.ReadMemStats(&memstat)
-
-	m["Alloc"] = float64(memstat.Alloc)
-	m["BuckHashSys"] = float64(memstat.BuckHashSys)
-	m["Frees"] = float64(memstat.Frees)
-	m["GCCPUFraction"] = float64(memstat.GCCPUFraction)
-	m["GCSys"] = float64(memstat.GCSys)
-	m["HeapAlloc"] = float64(memstat.HeapAlloc)
-	m["HeapIdle"] = float64(memstat.HeapIdle)
-	m["HeapInuse"] = float64(memstat.HeapInuse)
-	m["HeapObjects"] = float64(memstat.HeapObjects)
-	m["HeapReleased"] = float64(memstat.HeapReleased)
-	m["HeapSys"] = float64(memstat.HeapSys)
-	m["LastGC"] = float64(memstat.LastGC)
-	m["Lookups"] = float64(memstat.Lookups)
-	m["MCacheInuse"] = float64(memstat.MCacheInuse)
-	m["MCacheSys"] = float64(memstat.MCacheSys)
-	m["MSpanInuse"] = float64(memstat.MSpanInuse)
-	m["MSpanSys"] = float64(memstat.MSpanSys)
-	m["Mallocs"] = float64(memstat.Mallocs)
-	m["NextGC"] = float64(memstat.NextGC)
-	m["NumForcedGC"] = float64(memstat.NumForcedGC)
-	m["NumGC"] = float64(memstat.NumGC)
-	m["OtherSys"] = float64(memstat.OtherSys)
-	m["PauseTotalNs"] = float64(memstat.PauseTotalNs)
-	m["StackInuse"] = float64(memstat.StackInuse)
-	m["StackSys"] = float64(memstat.StackSys)
-	m["Sys"] = float64(memstat.Sys)
-	m["TotalAlloc"] = float64(memstat.TotalAlloc)
-
-	m["RandomValue"] = float64(rand.Int63n(1000))
-
-	//gopsutil
-	mem, _ := mem.VirtualMemory()
-
-	m["CPUutilization1"] = float64(mem.UsedPercent)
-	m["TotalMemory"] = float64(mem.Total)
-	m["FreeMemory"] = float64(mem.Free)
+// requestTimeout возвращает таймаут одной попытки отправки.
+func (a *agent) requestTimeout() time.Duration {
+	if a.cfg.Settings.RequestTimeout <= 0 {
+		return defaultRequestTimeout
+	}
+	return a.cfg.Settings.RequestTimeout
+}
+
+// statusError - ответ сервера с кодом ошибки.
+type statusError struct {
+	code int
+	body []byte
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("server responded %d: %s", e.code, bytes.TrimSpace(e.body))
+}
+
+func storageKey(m metric.Metric) string {
+	return m.MType + ":" + m.SeriesKey()
+}
+
+// mergeLabels объединяет общие labels агента с labels метрики, labels метрики приоритетнее.
+func mergeLabels(base, labels metric.Labels) metric.Labels {
+	if len(labels) < 1 {
+		return base
+	}
+	if len(base) < 1 {
+		return labels
+	}
+
+	merged := make(metric.Labels, len(base)+len(labels))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range labels {
+		merged[k] = v
+	}
 
+	return merged
 }
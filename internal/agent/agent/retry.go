@@ -0,0 +1,91 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nickzhog/devops-tool/internal/agent/config"
+	"github.com/nickzhog/devops-tool/pkg/metric"
+	"github.com/nickzhog/devops-tool/pkg/retry"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// retriesMetric - счетчик повторов отправки, label transport - http или grpc.
+const retriesMetric = "AgentRetries"
+
+// newRetryPolicy создает политику повтора отправки из конфига.
+// Каждый повтор увеличивает счетчик AgentRetries с label transport.
+func (a *agent) newRetryPolicy(transport string, retryable func(error) bool) retry.Policy {
+	labels := metric.Labels{"transport": transport}
+
+	return retry.Policy{
+		MaxAttempts: a.cfg.Settings.RetryMaxAttempts,
+		BaseDelay:   a.cfg.Settings.RetryBaseDelay,
+		MaxDelay:    a.cfg.Settings.RetryMaxDelay,
+		Jitter:      a.cfg.Settings.RetryJitter,
+		Retryable:   retryable,
+		OnRetry: func(attempt int, err error, delay time.Duration) {
+			a.logger.Tracef("%s send attempt %d failed: %s, retry in %s", transport, attempt, err.Error(), delay)
+
+			a.mutex.Lock()
+			defer a.mutex.Unlock()
+			a.addCounter(retriesMetric, labels, 1)
+		},
+	}
+}
+
+// httpRetryable повторяет сетевые ошибки и ответы с кодами из списка.
+func httpRetryable(cfg *config.Config) (func(error) bool, error) {
+	retryCodes := make(map[int]bool)
+	for _, v := range splitList(cfg.Settings.RetryHTTPCodes) {
+		code, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("wrong http code %q: %w", v, err)
+		}
+		retryCodes[code] = true
+	}
+
+	return func(err error) bool {
+		var statusErr *statusError
+		if errors.As(err, &statusErr) {
+			return retryCodes[statusErr.code]
+		}
+		return true
+	}, nil
+}
+
+// grpcRetryable повторяет ошибки с gRPC-кодами из списка, коды задаются именами, например Unavailable.
+func grpcRetryable(cfg *config.Config) (func(error) bool, error) {
+	names := make(map[string]codes.Code)
+	for c := codes.OK; c <= codes.Unauthenticated; c++ {
+		names[strings.ToLower(c.String())] = c
+	}
+
+	retryCodes := make(map[codes.Code]bool)
+	for _, v := range splitList(cfg.Settings.RetryGRPCCodes) {
+		code, ok := names[strings.ToLower(v)]
+		if !ok {
+			return nil, fmt.Errorf("wrong grpc code %q", v)
+		}
+		retryCodes[code] = true
+	}
+
+	return func(err error) bool {
+		return retryCodes[status.Code(err)]
+	}, nil
+}
+
+func splitList(s string) []string {
+	list := make([]string, 0)
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -0,0 +1,402 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	pb "github.com/nickzhog/devops-tool/internal/proto"
+	"github.com/nickzhog/devops-tool/pkg/metric"
+	"google.golang.org/grpc/metadata"
+)
+
+// Способы отправки метрик на сервер.
+const (
+	TransportURL    = "url"    // по одной метрике на /update/{type}/{name}/{value}
+	TransportJSON   = "json"   // по одной метрике в формате JSON на /update
+	TransportBatch  = "batch"  // пакетами в формате JSON на /updates/
+	TransportGRPC   = "grpc"   // пакетами через gRPC SetMetrics
+	TransportStream = "stream" // пакетами через долгоживущий поток gRPC StreamMetrics
+)
+
+// outgoing - метрика к отправке.
+// Для counter key и total используются для подтверждения доставки.
+type outgoing struct {
+	metric metric.Metric
+	key    string
+	total  int64
+}
+
+// SendMetrics отправляет метрики агента способом, заданным в конфиге.
+// Counter отправляются приращением с момента последней успешной отправки.
+func (a *agent) SendMetrics(ctx context.Context) {
+	items := a.prepareBatch()
+	if len(items) < 1 {
+		return
+	}
+
+	var err error
+	switch a.cfg.Settings.Transport {
+	case TransportURL:
+		err = a.sendEach(ctx, items, a.sendURL)
+	case TransportJSON:
+		err = a.sendEach(ctx, items, a.sendJSON)
+	case TransportGRPC:
+		err = a.sendChunks(ctx, items, a.sendGRPC)
+	case TransportStream:
+		err = a.sendStream(ctx, items)
+	default:
+		err = a.sendChunks(ctx, items, a.sendBatch)
+	}
+	if err != nil {
+		a.logger.Errorf("send metrics (%s): %s, counters will be resynced", a.cfg.Settings.Transport, err.Error())
+		// сервер мог перезапуститься и потерять значения, после восстановления связи
+		// counter отправляются полным значением
+		a.resyncCounters()
+		return
+	}
+
+	a.logger.Tracef("%d metrics sended via %s", len(items), a.cfg.Settings.Transport)
+}
+
+// prepareBatch возвращает метрики для отправки.
+// Counter передаются приращением с момента последней подтвержденной отправки,
+// нулевые приращения пропускаются. При первой отправке после запуска, после ошибки отправки,
+// ImportMetrics или потери пакетов из очереди counter передаются полным значением
+// с признаком Absolute, чтобы сервер заменил сохраненное.
+func (a *agent) prepareBatch() []outgoing {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	items := make([]outgoing, 0, len(a.metrics))
+	for key, v := range a.metrics {
+		item := outgoing{key: key}
+		switch v.MType {
+		case metric.GaugeType:
+			item.metric = metric.NewGaugeMetric(v.ID, *v.Value)
+		case metric.CounterType:
+			item.total = *v.Delta
+			absolute := a.resync[key]
+
+			delta := item.total - a.acked[key]
+			if absolute {
+				delta = item.total
+			} else if delta == 0 {
+				continue
+			}
+
+			item.metric = metric.NewCounterMetric(v.ID, delta)
+			item.metric.Absolute = absolute
+		default:
+			continue
+		}
+		item.metric.Labels = mergeLabels(a.labels, v.Labels)
+		if a.cfg.Settings.Key != "" {
+			item.metric.Hash = item.metric.GetHash(a.cfg.Settings.Key)
+		}
+
+		items = append(items, item)
+	}
+
+	return items
+}
+
+// ack подтверждает доставку метрик на сервер.
+func (a *agent) ack(items []outgoing) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for _, item := range items {
+		if item.metric.MType != metric.CounterType {
+			continue
+		}
+		a.acked[item.key] = item.total
+		delete(a.resync, item.key)
+	}
+}
+
+// sendEach отправляет метрики по одной и подтверждает каждую доставленную.
+// Возвращает последнюю ошибку отправки.
+func (a *agent) sendEach(ctx context.Context, items []outgoing, send func(context.Context, metric.Metric) error) error {
+	var lastErr error
+	for _, item := range items {
+		err := send(ctx, item.metric)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		a.ack([]outgoing{item})
+	}
+
+	return lastErr
+}
+
+// sendChunks отправляет метрики пакетами не больше BatchSize.
+// Возвращает последнюю ошибку отправки.
+func (a *agent) sendChunks(ctx context.Context, items []outgoing, send func(context.Context, []outgoing) error) error {
+	size := a.cfg.Settings.BatchSize
+	if size <= 0 {
+		size = len(items)
+	}
+
+	var lastErr error
+	for start := 0; start < len(items); start += size {
+		end := start + size
+		if end > len(items) {
+			end = len(items)
+		}
+
+		err := send(ctx, items[start:end])
+		if err != nil {
+			lastErr = err
+		}
+	}
+
+	return lastErr
+}
+
+func (a *agent) sendURL(ctx context.Context, m metric.Metric) error {
+	query := make(url.Values)
+	if len(m.Labels) > 0 {
+		query.Set("labels", m.Labels.String())
+	}
+	if m.Absolute {
+		query.Set("absolute", "true")
+	}
+
+	var value interface{}
+	switch m.MType {
+	case metric.GaugeType:
+		value = *m.Value
+	case metric.CounterType:
+		value = *m.Delta
+	}
+
+	addr := fmt.Sprintf("%s/update/%s/%s/%v", a.cfg.Settings.Address, m.MType, url.PathEscape(m.ID), value)
+	if len(query) > 0 {
+		addr += "?" + query.Encode()
+	}
+
+	_, err := a.sendRequest(ctx, addr, nil)
+	return err
+}
+
+func (a *agent) sendJSON(ctx context.Context, m metric.Metric) error {
+	addr := fmt.Sprintf("%s/update", a.cfg.Settings.Address)
+
+	_, err := a.sendRequest(ctx, addr, m.Marshal())
+	return err
+}
+
+// sendBatch отправляет пакет на /updates/, при включенной очереди - через очередь на диске.
+func (a *agent) sendBatch(ctx context.Context, items []outgoing) error {
+	metrics := make([]metric.Metric, 0, len(items))
+	for _, item := range items {
+		metrics = append(metrics, item.metric)
+	}
+
+	data, err := json.Marshal(metrics)
+	if err != nil {
+		return err
+	}
+
+	if a.spool != nil {
+		return a.sendSpooled(ctx, data, func() { a.ack(items) })
+	}
+
+	addr := fmt.Sprintf("%s/updates/", a.cfg.Settings.Address)
+	_, err = a.sendRequest(ctx, addr, data)
+	if err != nil {
+		return err
+	}
+	a.ack(items)
+
+	return nil
+}
+
+// sendSpooled добавляет пакет в конец очереди на диске и отправляет очередь по порядку.
+// Пока сервер недоступен, пакеты копятся в очереди, а попытки отправки
+// повторяются с экспоненциально растущей паузой.
+// Пакеты, окончательно отклоненные сервером (см. spoolRetryable), удаляются из очереди.
+// Пакет считается доставленным (вызывается ack), как только он сохранен в очереди,
+// поэтому ошибка отправки очереди только логируется.
+func (a *agent) sendSpooled(ctx context.Context, batch []byte, ack func()) error {
+	err := a.spool.Push(batch)
+	if err != nil {
+		return fmt.Errorf("spool push: %w", err)
+	}
+	ack()
+	defer a.checkSpoolDropped()
+
+	if time.Now().Before(a.retryAt) {
+		a.logger.Tracef("server is unavailable, %d batches spooled", a.spool.Len())
+		return nil
+	}
+
+	addr := fmt.Sprintf("%s/updates/", a.cfg.Settings.Address)
+	err = a.spool.Replay(ctx, func(data []byte) error {
+		_, err := a.sendRequest(ctx, addr, data)
+
+		var statusErr *statusError
+		if errors.As(err, &statusErr) && !spoolRetryable(statusErr.code) {
+			// приращения counter из пакета уже подтверждены, поэтому counter отправляются полным значением
+			a.logger.Errorf("batch rejected and dropped: %s, counters will be resynced", err.Error())
+			a.resyncCounters()
+			return nil
+		}
+		return err
+	})
+	if err == nil {
+		a.backoff = 0
+		a.retryAt = time.Time{}
+		return nil
+	}
+
+	a.backoff *= 2
+	if a.backoff < minBackoff {
+		a.backoff = minBackoff
+	}
+	if a.backoff > maxBackoff {
+		a.backoff = maxBackoff
+	}
+	a.retryAt = time.Now().Add(a.backoff)
+
+	a.logger.Errorf("send spooled batches: %s, %d batches spooled, next attempt in %s",
+		err.Error(), a.spool.Len(), a.backoff)
+	// полные значения попадут в очередь после недоставленных приращений
+	a.resyncCounters()
+	return nil
+}
+
+// spoolRetryable сообщает, оставить ли пакет в очереди после ответа сервера с кодом code.
+// Кроме ошибок сервера повторяются 408 и 429, а также 401 и 403,
+// чтобы пакеты не терялись, пока исправляются учетные данные агента.
+func spoolRetryable(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests,
+		http.StatusUnauthorized, http.StatusForbidden:
+		return true
+	}
+
+	return code >= http.StatusInternalServerError
+}
+
+// checkSpoolDropped включает пересинхронизацию counter, если очередь
+// удалила недоставленные пакеты по ограничениям размера или возраста.
+func (a *agent) checkSpoolDropped() {
+	dropped := a.spool.Dropped()
+	if dropped == a.spoolDropped {
+		return
+	}
+
+	a.logger.Errorf("%d spooled batches dropped, counters will be resynced", dropped-a.spoolDropped)
+	a.spoolDropped = dropped
+	a.resyncCounters()
+}
+
+// resyncCounters включает отправку всех counter полным значением до следующей подтвержденной отправки.
+func (a *agent) resyncCounters() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for key, m := range a.metrics {
+		if m.MType == metric.CounterType {
+			a.resync[key] = true
+		}
+	}
+}
+
+// grpcMetadata возвращает метаданные вызовов gRPC: идентификатор агента и токен.
+func (a *agent) grpcMetadata() metadata.MD {
+	md := metadata.MD{}
+	if a.cfg.Settings.AgentID != "" {
+		md.Set("x-agent-id", a.cfg.Settings.AgentID)
+	}
+	if a.cfg.Settings.Token != "" {
+		md.Set("authorization", "Bearer "+a.cfg.Settings.Token)
+	}
+	return md
+}
+
+func metricsToProto(items []outgoing) []*pb.Metric {
+	metrics := make([]*pb.Metric, 0, len(items))
+	for _, item := range items {
+		m := item.metric
+		pbmetric := &pb.Metric{
+			Id:       m.ID,
+			Mtype:    pb.MType(pb.MType_value[m.MType]),
+			Hash:     m.Hash,
+			Labels:   m.Labels,
+			Absolute: m.Absolute,
+		}
+		if m.Value != nil {
+			pbmetric.Value = *m.Value
+		}
+		if m.Delta != nil {
+			pbmetric.Delta = *m.Delta
+		}
+
+		metrics = append(metrics, pbmetric)
+	}
+	return metrics
+}
+
+func (a *agent) sendGRPC(ctx context.Context, items []outgoing) error {
+	request := pb.SetMetricsRequest{Metrics: metricsToProto(items)}
+	ctx = metadata.NewOutgoingContext(ctx, a.grpcMetadata())
+
+	err := a.grpcRetry.Do(ctx, func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, a.requestTimeout())
+		defer cancel()
+
+		_, err := a.grpcClient.SetMetrics(ctx, &request)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	a.ack(items)
+
+	return nil
+}
+
+// sendStream отправляет пакеты в поток StreamMetrics, не дожидаясь подтверждения предыдущих,
+// и ждет подтверждения всех отправленных пакетов не дольше таймаута запроса.
+// Если подтверждения не пришли вовремя, поток закрывается, чтобы поздние подтверждения
+// не смешались со следующей отправкой.
+func (a *agent) sendStream(ctx context.Context, items []outgoing) error {
+	ctx, cancel := context.WithTimeout(ctx, a.requestTimeout())
+	defer cancel()
+
+	results := make(chan error, len(items))
+	sent := 0
+	lastErr := a.sendChunks(ctx, items, func(ctx context.Context, chunk []outgoing) error {
+		err := a.grpcStream.Send(ctx, metricsToProto(chunk), func(err error) {
+			if err == nil {
+				a.ack(chunk)
+			}
+			results <- err
+		})
+		if err == nil {
+			sent++
+		}
+		return err
+	})
+
+	for ; sent > 0; sent-- {
+		select {
+		case err := <-results:
+			if err != nil {
+				lastErr = err
+			}
+		case <-ctx.Done():
+			a.grpcStream.Close()
+			return ctx.Err()
+		}
+	}
+
+	return lastErr
+}
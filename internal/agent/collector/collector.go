@@ -0,0 +1,108 @@
+// Package collector содержит сборщики метрик агента.
+//
+// Каждый сборщик регистрируется под своим именем функцией Register,
+// агент создает включенные в конфиге сборщики функцией New и
+// опрашивает их с интервалом POLL_INTERVAL.
+package collector
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/nickzhog/devops-tool/internal/agent/config"
+	"github.com/nickzhog/devops-tool/pkg/metric"
+)
+
+// Collector - источник метрик агента.
+type Collector interface {
+	// Name возвращает имя, под которым сборщик зарегистрирован.
+	Name() string
+	// Collect возвращает текущие значения метрик.
+	Collect() ([]metric.Metric, error)
+}
+
+// Options - параметры сборщика из файла конфигурации.
+type Options map[string]string
+
+// List возвращает значение параметра key, разделенное запятыми,
+// или def, если параметр не задан.
+func (o Options) List(key string, def ...string) []string {
+	v, ok := o[key]
+	if !ok || v == "" {
+		return def
+	}
+
+	list := strings.Split(v, ",")
+	for i := range list {
+		list[i] = strings.TrimSpace(list[i])
+	}
+	return list
+}
+
+// Factory создает сборщик с заданными параметрами.
+type Factory func(opts Options) (Collector, error)
+
+type registration struct {
+	factory Factory
+	enabled bool
+}
+
+var registry = make(map[string]registration)
+
+// Register регистрирует сборщик под именем name.
+// enabled определяет, включен ли сборщик, если он не упомянут в конфиге.
+func Register(name string, enabled bool, factory Factory) {
+	if _, ok := registry[name]; ok {
+		panic("collector: Register called twice for " + name)
+	}
+	registry[name] = registration{factory: factory, enabled: enabled}
+}
+
+// Names возвращает отсортированный список зарегистрированных сборщиков.
+func Names() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New создает включенные сборщики согласно настройкам cfg.
+// Сборщики, отсутствующие в cfg, включаются по умолчанию при регистрации.
+func New(cfg map[string]config.Collector) ([]Collector, error) {
+	for name := range cfg {
+		if _, ok := registry[name]; !ok {
+			return nil, fmt.Errorf("unknown collector: %s", name)
+		}
+	}
+
+	collectors := make([]Collector, 0, len(registry))
+	for _, name := range Names() {
+		reg := registry[name]
+
+		enabled := reg.enabled
+		settings, ok := cfg[name]
+		if ok && settings.Enabled != nil {
+			enabled = *settings.Enabled
+		}
+		if !enabled {
+			continue
+		}
+
+		c, err := reg.factory(settings.Options)
+		if err != nil {
+			return nil, fmt.Errorf("collector %s: %w", name, err)
+		}
+		collectors = append(collectors, c)
+	}
+
+	return collectors, nil
+}
+
+func gauge(name string, value float64, labels metric.Labels) metric.Metric {
+	m := metric.NewGaugeMetric(name, value)
+	m.Labels = labels
+	return m
+}
@@ -0,0 +1,116 @@
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nickzhog/devops-tool/internal/agent/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+	enabled, disabled := true, false
+
+	tests := []struct {
+		name    string
+		cfg     map[string]config.Collector
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			cfg:  nil,
+			want: []string{"memory", "runtime"},
+		},
+		{
+			name: "enable and disable",
+			cfg: map[string]config.Collector{
+				"load":   {Enabled: &enabled},
+				"memory": {Enabled: &disabled},
+				"disk":   {Options: map[string]string{"paths": "/"}},
+			},
+			want: []string{"load", "runtime"},
+		},
+		{
+			name:    "unknown collector",
+			cfg:     map[string]config.Collector{"gpu": {Enabled: &enabled}},
+			wantErr: true,
+		},
+		{
+			name: "wrong options",
+			cfg: map[string]config.Collector{
+				"process": {Enabled: &enabled, Options: map[string]string{"pids": "agent"}},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			collectors, err := New(tt.cfg)
+			if tt.wantErr {
+				assert.Error(err)
+				return
+			}
+			assert.NoError(err)
+
+			names := make([]string, 0, len(collectors))
+			for _, c := range collectors {
+				names = append(names, c.Name())
+			}
+			assert.Equal(tt.want, names)
+		})
+	}
+}
+
+func TestCPUCollector(t *testing.T) {
+	assert := assert.New(t)
+
+	root := t.TempDir()
+	writeStat := func(data string) {
+		err := os.WriteFile(filepath.Join(root, "stat"), []byte(data), 0644)
+		assert.NoError(err)
+	}
+
+	c, err := registry["cpu"].factory(Options{"proc_root": root})
+	assert.NoError(err)
+
+	writeStat("cpu  100 0 100 800 0 0 0 0 0 0\ncpu0 100 0 100 800 0 0 0 0 0 0\nintr 1 2 3\n")
+	_, err = c.Collect()
+	assert.NoError(err)
+
+	// за интервал ядро 200 тиков работало и 200 простаивало
+	writeStat("cpu  200 0 200 1000 0 0 0 0 0 0\ncpu0 200 0 200 1000 0 0 0 0 0 0\nintr 1 2 3\n")
+	metrics, err := c.Collect()
+	assert.NoError(err)
+
+	if assert.Len(metrics, 2) {
+		for _, m := range metrics {
+			assert.Equal("CPUUtilization", m.ID)
+			assert.Equal(50.0, *m.Value)
+		}
+	}
+}
+
+func TestParseProcStat(t *testing.T) {
+	assert := assert.New(t)
+
+	data := "42 (my (agent)) S 1 42 42 0 -1 4194304 84 0 0 0 250 50 0 0 20 0 7 0 334510 2568192 317 " +
+		strings.Repeat("0 ", 20)
+
+	stat, err := parseProcStat(data)
+	assert.NoError(err)
+	assert.Equal(procStat{
+		comm:       "my (agent)",
+		cpuSeconds: 3,
+		threads:    7,
+		vsize:      2568192,
+		rssPages:   317,
+	}, stat)
+
+	_, err = parseProcStat("42 agent S 1")
+	assert.Error(err)
+}
@@ -0,0 +1,114 @@
+package collector
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/nickzhog/devops-tool/pkg/metric"
+)
+
+func init() {
+	Register("cpu", false, func(opts Options) (Collector, error) {
+		return &cpuCollector{
+			procRoot: opts.List("proc_root", "/proc")[0],
+			prev:     make(map[string]cpuTimes),
+		}, nil
+	})
+}
+
+// cpuCollector собирает загрузку каждого ядра и процессора в целом из /proc/stat.
+// Загрузка считается между соседними опросами, при первом опросе - с момента загрузки системы.
+//
+// Метрики: CPUUtilization{cpu="0"}, ..., CPUUtilization{cpu="total"} - загрузка в процентах.
+type cpuCollector struct {
+	procRoot string
+
+	mutex sync.Mutex
+	prev  map[string]cpuTimes
+}
+
+type cpuTimes struct {
+	total uint64
+	idle  uint64
+}
+
+func (c *cpuCollector) Name() string { return "cpu" }
+
+func (c *cpuCollector) Collect() ([]metric.Metric, error) {
+	file, err := os.Open(filepath.Join(c.procRoot, "stat"))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	times, err := parseCPUStat(file)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	metrics := make([]metric.Metric, 0, len(times))
+	for cpu, t := range times {
+		prev := c.prev[cpu]
+		c.prev[cpu] = t
+
+		total := t.total - prev.total
+		if t.total < prev.total || total == 0 {
+			continue
+		}
+		idle := t.idle - prev.idle
+		if t.idle < prev.idle {
+			idle = 0
+		}
+
+		metrics = append(metrics, gauge("CPUUtilization",
+			100*float64(total-idle)/float64(total), metric.Labels{"cpu": cpu}))
+	}
+
+	return metrics, nil
+}
+
+// parseCPUStat разбирает строки cpu* из /proc/stat.
+// Суммарная строка "cpu" возвращается под ключом "total", строки "cpuN" - под ключом "N".
+func parseCPUStat(r io.Reader) (map[string]cpuTimes, error) {
+	result := make(map[string]cpuTimes)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || !strings.HasPrefix(fields[0], "cpu") {
+			continue
+		}
+
+		cpu := strings.TrimPrefix(fields[0], "cpu")
+		if cpu == "" {
+			cpu = "total"
+		}
+
+		// user nice system idle iowait irq softirq steal, guest уже учтен в user
+		var t cpuTimes
+		for i, f := range fields[1:] {
+			if i >= 8 {
+				break
+			}
+			v, err := strconv.ParseUint(f, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			t.total += v
+			if i == 3 || i == 4 {
+				t.idle += v
+			}
+		}
+		result[cpu] = t
+	}
+
+	return result, scanner.Err()
+}
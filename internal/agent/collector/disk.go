@@ -0,0 +1,62 @@
+package collector
+
+import (
+	"github.com/nickzhog/devops-tool/pkg/metric"
+	"github.com/shirou/gopsutil/disk"
+)
+
+func init() {
+	Register("disk", false, func(opts Options) (Collector, error) {
+		return diskCollector{
+			paths:   opts.List("paths", "/"),
+			devices: opts.List("devices"),
+		}, nil
+	})
+}
+
+// diskCollector собирает заполненность файловых систем и счетчики ввода-вывода дисков.
+//
+// Параметры: paths - точки монтирования через запятую (по умолчанию "/"),
+// devices - имена устройств через запятую (по умолчанию все устройства из /proc/diskstats).
+//
+// Метрики: DiskTotal, DiskFree, DiskUsedPercent с label path;
+// DiskReadBytes, DiskWriteBytes, DiskReadCount, DiskWriteCount с label device.
+type diskCollector struct {
+	paths   []string
+	devices []string
+}
+
+func (diskCollector) Name() string { return "disk" }
+
+func (c diskCollector) Collect() ([]metric.Metric, error) {
+	metrics := make([]metric.Metric, 0)
+	for _, path := range c.paths {
+		usage, err := disk.Usage(path)
+		if err != nil {
+			return nil, err
+		}
+
+		labels := metric.Labels{"path": path}
+		metrics = append(metrics,
+			gauge("DiskTotal", float64(usage.Total), labels),
+			gauge("DiskFree", float64(usage.Free), labels),
+			gauge("DiskUsedPercent", usage.UsedPercent, labels),
+		)
+	}
+
+	counters, err := disk.IOCounters(c.devices...)
+	if err != nil {
+		return nil, err
+	}
+	for name, io := range counters {
+		labels := metric.Labels{"device": name}
+		metrics = append(metrics,
+			gauge("DiskReadBytes", float64(io.ReadBytes), labels),
+			gauge("DiskWriteBytes", float64(io.WriteBytes), labels),
+			gauge("DiskReadCount", float64(io.ReadCount), labels),
+			gauge("DiskWriteCount", float64(io.WriteCount), labels),
+		)
+	}
+
+	return metrics, nil
+}
@@ -0,0 +1,32 @@
+package collector
+
+import (
+	"github.com/nickzhog/devops-tool/pkg/metric"
+	"github.com/shirou/gopsutil/load"
+)
+
+func init() {
+	Register("load", false, func(Options) (Collector, error) {
+		return loadCollector{}, nil
+	})
+}
+
+// loadCollector собирает среднюю загрузку системы из /proc/loadavg.
+//
+// Метрики: LoadAverage1, LoadAverage5, LoadAverage15.
+type loadCollector struct{}
+
+func (loadCollector) Name() string { return "load" }
+
+func (loadCollector) Collect() ([]metric.Metric, error) {
+	avg, err := load.Avg()
+	if err != nil {
+		return nil, err
+	}
+
+	return []metric.Metric{
+		gauge("LoadAverage1", avg.Load1, nil),
+		gauge("LoadAverage5", avg.Load5, nil),
+		gauge("LoadAverage15", avg.Load15, nil),
+	}, nil
+}
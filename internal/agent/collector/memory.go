@@ -0,0 +1,30 @@
+package collector
+
+import (
+	"github.com/nickzhog/devops-tool/pkg/metric"
+	"github.com/shirou/gopsutil/mem"
+)
+
+func init() {
+	Register("memory", true, func(Options) (Collector, error) {
+		return memoryCollector{}, nil
+	})
+}
+
+// memoryCollector собирает статистику виртуальной памяти системы.
+type memoryCollector struct{}
+
+func (memoryCollector) Name() string { return "memory" }
+
+func (memoryCollector) Collect() ([]metric.Metric, error) {
+	mem, err := mem.VirtualMemory()
+	if err != nil {
+		return nil, err
+	}
+
+	return []metric.Metric{
+		gauge("CPUutilization1", mem.UsedPercent, nil),
+		gauge("TotalMemory", float64(mem.Total), nil),
+		gauge("FreeMemory", float64(mem.Free), nil),
+	}, nil
+}
@@ -0,0 +1,67 @@
+package collector
+
+import (
+	"github.com/nickzhog/devops-tool/pkg/metric"
+	"github.com/shirou/gopsutil/net"
+)
+
+func init() {
+	Register("net", false, func(opts Options) (Collector, error) {
+		return netCollector{
+			interfaces: opts.List("interfaces"),
+		}, nil
+	})
+}
+
+// netCollector собирает счетчики сетевых интерфейсов из /proc/net/dev.
+//
+// Параметры: interfaces - имена интерфейсов через запятую (по умолчанию все, кроме lo).
+//
+// Метрики с label interface: NetBytesRecv, NetBytesSent, NetPacketsRecv,
+// NetPacketsSent, NetErrIn, NetErrOut, NetDropIn, NetDropOut.
+type netCollector struct {
+	interfaces []string
+}
+
+func (netCollector) Name() string { return "net" }
+
+func (c netCollector) Collect() ([]metric.Metric, error) {
+	counters, err := net.IOCounters(true)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := make([]metric.Metric, 0)
+	for _, io := range counters {
+		if !c.match(io.Name) {
+			continue
+		}
+
+		labels := metric.Labels{"interface": io.Name}
+		metrics = append(metrics,
+			gauge("NetBytesRecv", float64(io.BytesRecv), labels),
+			gauge("NetBytesSent", float64(io.BytesSent), labels),
+			gauge("NetPacketsRecv", float64(io.PacketsRecv), labels),
+			gauge("NetPacketsSent", float64(io.PacketsSent), labels),
+			gauge("NetErrIn", float64(io.Errin), labels),
+			gauge("NetErrOut", float64(io.Errout), labels),
+			gauge("NetDropIn", float64(io.Dropin), labels),
+			gauge("NetDropOut", float64(io.Dropout), labels),
+		)
+	}
+
+	return metrics, nil
+}
+
+func (c netCollector) match(name string) bool {
+	if len(c.interfaces) < 1 {
+		return name != "lo"
+	}
+
+	for _, v := range c.interfaces {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
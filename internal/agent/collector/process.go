@@ -0,0 +1,115 @@
+package collector
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/nickzhog/devops-tool/pkg/metric"
+)
+
+// userHZ - частота тиков, в которых ядро отдает процессорное время в /proc/<pid>/stat.
+const userHZ = 100
+
+func init() {
+	Register("process", false, func(opts Options) (Collector, error) {
+		pids := opts.List("pids", "self")
+		for _, pid := range pids {
+			if _, err := strconv.Atoi(pid); err != nil && pid != "self" {
+				return nil, fmt.Errorf("wrong pid: %s", pid)
+			}
+		}
+
+		return processCollector{
+			procRoot: opts.List("proc_root", "/proc")[0],
+			pids:     pids,
+		}, nil
+	})
+}
+
+// processCollector собирает статистику процессов из /proc/<pid>.
+//
+// Параметры: pids - идентификаторы процессов через запятую (по умолчанию "self" - сам агент).
+//
+// Метрики с label process (имя процесса): ProcessCPUSeconds, ProcessThreads,
+// ProcessResidentMemory, ProcessVirtualMemory, ProcessOpenFDs.
+type processCollector struct {
+	procRoot string
+	pids     []string
+}
+
+type procStat struct {
+	comm       string
+	cpuSeconds float64
+	threads    float64
+	vsize      float64
+	rssPages   float64
+}
+
+func (processCollector) Name() string { return "process" }
+
+func (c processCollector) Collect() ([]metric.Metric, error) {
+	metrics := make([]metric.Metric, 0, len(c.pids)*5)
+	for _, pid := range c.pids {
+		dir := filepath.Join(c.procRoot, pid)
+
+		data, err := os.ReadFile(filepath.Join(dir, "stat"))
+		if err != nil {
+			return nil, err
+		}
+		stat, err := parseProcStat(string(data))
+		if err != nil {
+			return nil, err
+		}
+
+		labels := metric.Labels{"process": stat.comm}
+		metrics = append(metrics,
+			gauge("ProcessCPUSeconds", stat.cpuSeconds, labels),
+			gauge("ProcessThreads", stat.threads, labels),
+			gauge("ProcessResidentMemory", stat.rssPages*float64(os.Getpagesize()), labels),
+			gauge("ProcessVirtualMemory", stat.vsize, labels),
+		)
+
+		fds, err := os.ReadDir(filepath.Join(dir, "fd"))
+		if err == nil {
+			metrics = append(metrics, gauge("ProcessOpenFDs", float64(len(fds)), labels))
+		}
+	}
+
+	return metrics, nil
+}
+
+// parseProcStat разбирает содержимое /proc/<pid>/stat.
+// Имя процесса в скобках может содержать пробелы, поэтому поля отсчитываются от последней ')'.
+func parseProcStat(data string) (procStat, error) {
+	start := strings.IndexByte(data, '(')
+	end := strings.LastIndexByte(data, ')')
+	if start < 0 || end < start {
+		return procStat{}, fmt.Errorf("wrong stat format")
+	}
+
+	// поля после имени процесса начинаются с state (3-е поле stat)
+	fields := strings.Fields(data[end+1:])
+	if len(fields) < 22 {
+		return procStat{}, fmt.Errorf("wrong stat format: %d fields", len(fields)+2)
+	}
+
+	values := make(map[int]float64)
+	for _, i := range []int{14, 15, 20, 23, 24} {
+		v, err := strconv.ParseFloat(fields[i-3], 64)
+		if err != nil {
+			return procStat{}, err
+		}
+		values[i] = v
+	}
+
+	return procStat{
+		comm:       data[start+1 : end],
+		cpuSeconds: (values[14] + values[15]) / userHZ,
+		threads:    values[20],
+		vsize:      values[23],
+		rssPages:   values[24],
+	}, nil
+}
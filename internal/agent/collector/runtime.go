@@ -0,0 +1,63 @@
+package collector
+
+import (
+	"math/rand"
+	"runtime"
+
+	"github.com/nickzhog/devops-tool/pkg/metric"
+)
+
+func init() {
+	Register("runtime", true, func(Options) (Collector, error) {
+		return runtimeCollector{}, nil
+	})
+}
+
+// runtimeCollector собирает статистику аллокатора и сборщика мусора Go (runtime.MemStats).
+type runtimeCollector struct{}
+
+func (runtimeCollector) Name() string { return "runtime" }
+
+func (runtimeCollector) Collect() ([]metric.Metric, error) {
+	var memstat runtime.MemStats
+	runtime.ReadMemStats(&memstat)
+
+	m := map[string]float64{
+		"Alloc":         float64(memstat.Alloc),
+		"BuckHashSys":   float64(memstat.BuckHashSys),
+		"Frees":         float64(memstat.Frees),
+		"GCCPUFraction": float64(memstat.GCCPUFraction),
+		"GCSys":         float64(memstat.GCSys),
+		"HeapAlloc":     float64(memstat.HeapAlloc),
+		"HeapIdle":      float64(memstat.HeapIdle),
+		"HeapInuse":     float64(memstat.HeapInuse),
+		"HeapObjects":   float64(memstat.HeapObjects),
+		"HeapReleased":  float64(memstat.HeapReleased),
+		"HeapSys":       float64(memstat.HeapSys),
+		"LastGC":        float64(memstat.LastGC),
+		"Lookups":       float64(memstat.Lookups),
+		"MCacheInuse":   float64(memstat.MCacheInuse),
+		"MCacheSys":     float64(memstat.MCacheSys),
+		"MSpanInuse":    float64(memstat.MSpanInuse),
+		"MSpanSys":      float64(memstat.MSpanSys),
+		"Mallocs":       float64(memstat.Mallocs),
+		"NextGC":        float64(memstat.NextGC),
+		"NumForcedGC":   float64(memstat.NumForcedGC),
+		"NumGC":         float64(memstat.NumGC),
+		"OtherSys":      float64(memstat.OtherSys),
+		"PauseTotalNs":  float64(memstat.PauseTotalNs),
+		"StackInuse":    float64(memstat.StackInuse),
+		"StackSys":      float64(memstat.StackSys),
+		"Sys":           float64(memstat.Sys),
+		"TotalAlloc":    float64(memstat.TotalAlloc),
+
+		"RandomValue": float64(rand.Int63n(1000)),
+	}
+
+	metrics := make([]metric.Metric, 0, len(m))
+	for k, v := range m {
+		metrics = append(metrics, gauge(k, v, nil))
+	}
+
+	return metrics, nil
+}
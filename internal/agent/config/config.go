@@ -7,21 +7,71 @@ import (
 	"time"
 
 	"github.com/caarlos0/env"
+	"github.com/nickzhog/devops-tool/pkg/metric"
 )
 
 type Config struct {
 	Settings struct {
 		ConfigFileJSON string `env:"CONFIG" json:"config_file_json,omitempty"`
 
+		AgentID string `env:"AGENT_ID" json:"agent_id,omitempty"` // идентификатор агента, по умолчанию имя хоста
+
 		PollInterval   time.Duration `yaml:"poll_interval" env:"POLL_INTERVAL" json:"poll_interval,omitempty"`
 		ReportInterval time.Duration `yaml:"report_interval" env:"REPORT_INTERVAL" json:"report_interval,omitempty"`
 		Address        string        `yaml:"address" env:"ADDRESS" json:"address,omitempty"`
 		AddressGRPC    string        `yaml:"address_grpc" env:"ADDRESS_GRPC" json:"address_grpc,omitempty"`
 		Key            string        `yaml:"key" env:"KEY" json:"key,omitempty"`    // ключ для вычисления хэша метрики
 		CryptoKey      string        `env:"CRYPTO_KEY" json:"crypto_key,omitempty"` // путь до файла с публичным ключем (ассиметричное шифрование)
+		Token          string        `env:"TOKEN" json:"token,omitempty"`           // API-ключ агента, передается в заголовке Authorization: Bearer
+
+		TLSCA   string `env:"TLS_CA" json:"tls_ca,omitempty"`     // путь до сертификатов CA HTTP-сервера, по умолчанию системные
+		TLSCert string `env:"TLS_CERT" json:"tls_cert,omitempty"` // путь до сертификата клиента HTTP (mutual TLS)
+		TLSKey  string `env:"TLS_KEY" json:"tls_key,omitempty"`   // путь до ключа сертификата клиента HTTP
+
+		GRPCTLS           bool   `env:"GRPC_TLS" json:"grpc_tls,omitempty"`                         // использовать TLS для gRPC
+		GRPCTLSCA         string `env:"GRPC_TLS_CA" json:"grpc_tls_ca,omitempty"`                   // путь до сертификатов CA сервера, по умолчанию системные
+		GRPCTLSCert       string `env:"GRPC_TLS_CERT" json:"grpc_tls_cert,omitempty"`               // путь до сертификата клиента (mutual TLS)
+		GRPCTLSKey        string `env:"GRPC_TLS_KEY" json:"grpc_tls_key,omitempty"`                 // путь до ключа сертификата клиента
+		GRPCTLSServerName string `env:"GRPC_TLS_SERVER_NAME" json:"grpc_tls_server_name,omitempty"` // имя сервера для проверки сертификата
+
+		LabelHost    string `env:"LABEL_HOST" json:"label_host,omitempty"`       // label host для всех метрик, по умолчанию имя хоста
+		LabelService string `env:"LABEL_SERVICE" json:"label_service,omitempty"` // label service для всех метрик
+		LabelEnv     string `env:"LABEL_ENV" json:"label_env,omitempty"`         // label env для всех метрик
+		Labels       string `env:"LABELS" json:"labels,omitempty"`               // произвольные labels в формате "k1=v1,k2=v2"
+
+		Transport    string `env:"TRANSPORT" json:"transport,omitempty"`         // способ отправки метрик: url, json, batch, grpc или stream
+		BatchSize    int    `env:"BATCH_SIZE" json:"batch_size,omitempty"`       // максимальное количество метрик в одном пакете batch, grpc и stream, 0 - без ограничения
+		StreamWindow int    `env:"STREAM_WINDOW" json:"stream_window,omitempty"` // максимальное количество неподтвержденных пакетов в потоке stream
+
+		RequestTimeout   time.Duration `env:"REQUEST_TIMEOUT" json:"request_timeout,omitempty"`       // таймаут одной попытки отправки
+		RetryMaxAttempts int           `env:"RETRY_MAX_ATTEMPTS" json:"retry_max_attempts,omitempty"` // количество попыток отправки, включая первую
+		RetryBaseDelay   time.Duration `env:"RETRY_BASE_DELAY" json:"retry_base_delay,omitempty"`     // задержка перед первым повтором, далее удваивается
+		RetryMaxDelay    time.Duration `env:"RETRY_MAX_DELAY" json:"retry_max_delay,omitempty"`       // максимальная задержка между повторами
+		RetryJitter      float64       `env:"RETRY_JITTER" json:"retry_jitter,omitempty"`             // доля случайного уменьшения задержки, от 0 до 1
+		RetryHTTPCodes   string        `env:"RETRY_HTTP_CODES" json:"retry_http_codes,omitempty"`     // HTTP-коды ответа для повтора через запятую
+		RetryGRPCCodes   string        `env:"RETRY_GRPC_CODES" json:"retry_grpc_codes,omitempty"`     // gRPC-коды для повтора через запятую
+
+		SpoolDir     string        `env:"SPOOL_DIR" json:"spool_dir,omitempty"`           // каталог очереди неотправленных пакетов (только transport batch), пусто - очередь отключена
+		SpoolMaxSize int64         `env:"SPOOL_MAX_SIZE" json:"spool_max_size,omitempty"` // максимальный размер очереди в байтах
+		SpoolMaxAge  time.Duration `env:"SPOOL_MAX_AGE" json:"spool_max_age,omitempty"`   // максимальный возраст пакета в очереди
+
+		Collectors map[string]Collector `json:"collectors,omitempty"` // настройки сборщиков метрик, задаются только в файле конфигурации
 	} `yaml:"settings"`
 }
 
+// GRPCTLSEnabled сообщает, нужно ли использовать TLS для gRPC.
+// TLS включается флагом или заданием любого файла сертификата.
+func (c *Config) GRPCTLSEnabled() bool {
+	return c.Settings.GRPCTLS || c.Settings.GRPCTLSCA != "" || c.Settings.GRPCTLSCert != ""
+}
+
+// Collector - настройки сборщика метрик агента.
+// Если Enabled не задан, сборщик включен или выключен по умолчанию.
+type Collector struct {
+	Enabled *bool             `json:"enabled,omitempty"`
+	Options map[string]string `json:"options,omitempty"`
+}
+
 func GetConfig() *Config {
 	cfg := &Config{}
 	flag.StringVar(&cfg.Settings.ConfigFileJSON, "c", "", "path to json config file")
@@ -38,6 +88,40 @@ func GetConfig() *Config {
 
 	flag.StringVar(&cfg.Settings.Key, "k", "", "key for calculate hash of metric")
 	flag.StringVar(&cfg.Settings.CryptoKey, "crypto-key", "", "public.key path for RSA encryption")
+	flag.StringVar(&cfg.Settings.Token, "token", "", "api key for server authentication")
+
+	flag.StringVar(&cfg.Settings.TLSCA, "tls-ca", "", "ca certificates path for https server verification")
+	flag.StringVar(&cfg.Settings.TLSCert, "tls-cert", "", "https client tls certificate path (mutual tls)")
+	flag.StringVar(&cfg.Settings.TLSKey, "tls-key", "", "https client tls key path")
+
+	flag.BoolVar(&cfg.Settings.GRPCTLS, "grpc-tls", false, "use tls for grpc")
+	flag.StringVar(&cfg.Settings.GRPCTLSCA, "grpc-tls-ca", "", "ca certificates path for grpc server verification")
+	flag.StringVar(&cfg.Settings.GRPCTLSCert, "grpc-tls-cert", "", "grpc client tls certificate path (mutual tls)")
+	flag.StringVar(&cfg.Settings.GRPCTLSKey, "grpc-tls-key", "", "grpc client tls key path")
+	flag.StringVar(&cfg.Settings.GRPCTLSServerName, "grpc-tls-server-name", "", "grpc server name for certificate verification")
+
+	hostname, _ := os.Hostname()
+	flag.StringVar(&cfg.Settings.AgentID, "id", hostname, "agent id")
+	flag.StringVar(&cfg.Settings.LabelHost, "label-host", hostname, "host label for all metrics")
+	flag.StringVar(&cfg.Settings.LabelService, "label-service", "", "service label for all metrics")
+	flag.StringVar(&cfg.Settings.LabelEnv, "label-env", "", "env label for all metrics")
+	flag.StringVar(&cfg.Settings.Labels, "labels", "", "extra labels for all metrics, k1=v1,k2=v2")
+
+	flag.StringVar(&cfg.Settings.Transport, "transport", "", "send mode: url, json, batch, grpc or stream (default grpc if -g is set, batch otherwise)")
+	flag.IntVar(&cfg.Settings.BatchSize, "batch-size", 500, "max metrics in one batch, grpc or stream request, 0 - unlimited")
+	flag.IntVar(&cfg.Settings.StreamWindow, "stream-window", 8, "max unacknowledged batches in grpc stream")
+
+	flag.DurationVar(&cfg.Settings.RequestTimeout, "request-timeout", time.Second*2, "timeout of single send attempt")
+	flag.IntVar(&cfg.Settings.RetryMaxAttempts, "retry-max-attempts", 3, "send attempts including the first one")
+	flag.DurationVar(&cfg.Settings.RetryBaseDelay, "retry-base-delay", time.Millisecond*500, "delay before the first retry")
+	flag.DurationVar(&cfg.Settings.RetryMaxDelay, "retry-max-delay", time.Second*5, "max delay between retries")
+	flag.Float64Var(&cfg.Settings.RetryJitter, "retry-jitter", 0.2, "random fraction subtracted from retry delay, 0..1")
+	flag.StringVar(&cfg.Settings.RetryHTTPCodes, "retry-http-codes", "429,502,503,504", "retryable http status codes")
+	flag.StringVar(&cfg.Settings.RetryGRPCCodes, "retry-grpc-codes", "Unavailable,ResourceExhausted,Aborted,DeadlineExceeded", "retryable grpc codes")
+
+	flag.StringVar(&cfg.Settings.SpoolDir, "spool-dir", "", "directory for unsent metric batches")
+	flag.Int64Var(&cfg.Settings.SpoolMaxSize, "spool-max-size", 10<<20, "max size of unsent batches in bytes")
+	flag.DurationVar(&cfg.Settings.SpoolMaxAge, "spool-max-age", time.Hour*24, "max age of unsent batch")
 
 	flag.Parse()
 
@@ -46,6 +130,30 @@ func GetConfig() *Config {
 	return cfg
 }
 
+// MetricLabels возвращает набор labels, добавляемый агентом ко всем метрикам.
+func (c *Config) MetricLabels() (metric.Labels, error) {
+	labels, err := metric.ParseLabels(c.Settings.Labels)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range map[string]string{
+		"host":    c.Settings.LabelHost,
+		"service": c.Settings.LabelService,
+		"env":     c.Settings.LabelEnv,
+	} {
+		if v == "" {
+			continue
+		}
+		if labels == nil {
+			labels = make(metric.Labels)
+		}
+		labels[k] = v
+	}
+
+	return labels, nil
+}
+
 func parseFileJSON(path string) (cfg *Config) {
 	cfg = &Config{}
 	file, err := os.ReadFile(path)
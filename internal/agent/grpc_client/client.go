@@ -1,13 +1,23 @@
 package grpcclient
 
 import (
+	"crypto/tls"
+
 	pb "github.com/nickzhog/devops-tool/internal/proto"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
-func NewClient(port string) pb.MetricsClient {
-	conn, err := grpc.Dial(port, grpc.WithTransportCredentials(insecure.NewCredentials()))
+// NewClient создает клиент gRPC. Если tlsConfig не задан, соединение не шифруется.
+func NewClient(port string, tlsConfig *tls.Config, opts ...grpc.DialOption) pb.MetricsClient {
+	creds := insecure.NewCredentials()
+	if tlsConfig != nil {
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	opts = append(opts, grpc.WithTransportCredentials(creds))
+
+	conn, err := grpc.Dial(port, opts...)
 	if err != nil {
 		panic(err)
 	}
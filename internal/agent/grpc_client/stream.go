@@ -0,0 +1,273 @@
+package grpcclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	pb "github.com/nickzhog/devops-tool/internal/proto"
+	"github.com/nickzhog/devops-tool/pkg/retry"
+	"google.golang.org/grpc/metadata"
+)
+
+var (
+	ErrStreamUnavailable = errors.New("metrics stream is unavailable")
+	ErrStreamClosed      = errors.New("metrics stream is closed")
+	ErrRejected          = errors.New("batch rejected by server")
+)
+
+// Stream - долгоживущий поток StreamMetrics.
+//
+// Пакеты отправляются, не дожидаясь подтверждения предыдущих, но неподтвержденных
+// пакетов не может быть больше размера окна. При обрыве потока все неподтвержденные пакеты
+// завершаются ошибкой, а следующая отправка открывает поток заново; повторные попытки
+// подключения после ошибок выполняются с экспоненциально растущей паузой.
+type Stream struct {
+	client    pb.MetricsClient
+	md        metadata.MD
+	window    chan struct{} // занятые места в окне неподтвержденных пакетов
+	sending   chan struct{} // занят, пока пакет записывается в поток
+	reconnect retry.Policy
+
+	// parent - родительский контекст потоков. Close отменяет его, не дожидаясь mutex,
+	// чтобы прервать зависшие подключение и запись в поток.
+	parentMutex sync.Mutex
+	parent      context.Context
+	stop        context.CancelFunc
+
+	mutex    sync.Mutex
+	stream   pb.Metrics_StreamMetricsClient
+	cancel   context.CancelFunc
+	seq      uint64
+	pending  map[uint64]func(error)
+	writing  uint64 // номер пакета, который записывается в поток; reset его не завершает
+	failures int
+	retryAt  time.Time
+}
+
+// NewStream создает поток, который открывается при первой отправке.
+// window - максимальное количество неподтвержденных пакетов, md - метаданные потока.
+func NewStream(client pb.MetricsClient, window int, md metadata.MD) *Stream {
+	if window < 1 {
+		window = 1
+	}
+
+	parent, stop := context.WithCancel(context.Background())
+	return &Stream{
+		client:  client,
+		md:      md,
+		window:  make(chan struct{}, window),
+		sending: make(chan struct{}, 1),
+		parent:  parent,
+		stop:    stop,
+		reconnect: retry.Policy{
+			BaseDelay: time.Second,
+			MaxDelay:  time.Minute,
+			Jitter:    0.2,
+		},
+		pending: make(map[uint64]func(error)),
+	}
+}
+
+// Send отправляет пакет метрик. Если окно заполнено, ждет подтверждения предыдущих пакетов.
+// Если Send вернул nil, done вызывается ровно один раз: с nil после подтверждения пакета,
+// с ErrRejected, если сервер не принял пакет, или с ошибкой обрыва потока.
+// Если ctx отменен, пока пакет записывается в поток, поток разрывается и Send возвращает ошибку ctx.
+func (s *Stream) Send(ctx context.Context, metrics []*pb.Metric, done func(error)) error {
+	select {
+	case s.window <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	// пакеты записываются в поток по одному, mutex на время записи не удерживается
+	select {
+	case s.sending <- struct{}{}:
+	case <-ctx.Done():
+		<-s.window
+		return ctx.Err()
+	}
+	defer func() { <-s.sending }()
+
+	s.mutex.Lock()
+	stream, err := s.connect()
+	if err != nil {
+		s.mutex.Unlock()
+		<-s.window
+		return err
+	}
+
+	s.seq++
+	seq := s.seq
+	s.pending[seq] = done
+	s.writing = seq
+	s.mutex.Unlock()
+
+	err = s.write(ctx, stream, &pb.MetricsBatch{Seq: seq, Metrics: metrics})
+
+	s.mutex.Lock()
+	s.writing = 0
+	_, waiting := s.pending[seq]
+	if err == nil && (s.stream == stream || !waiting) {
+		s.mutex.Unlock()
+		return nil
+	}
+
+	if waiting {
+		delete(s.pending, seq)
+		<-s.window
+	}
+	if err == nil {
+		// поток сброшен во время записи, пакет не будет подтвержден
+		s.mutex.Unlock()
+		done(ErrStreamClosed)
+		return nil
+	}
+	failed := s.reset(stream, err)
+	s.mutex.Unlock()
+
+	finish(failed, err)
+	return err
+}
+
+// write записывает пакет в поток. Если ctx отменен до окончания записи, поток разрывается.
+func (s *Stream) write(ctx context.Context, stream pb.Metrics_StreamMetricsClient, batch *pb.MetricsBatch) error {
+	written := make(chan struct{})
+	defer close(written)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.mutex.Lock()
+			if s.stream == stream {
+				s.cancel()
+			}
+			s.mutex.Unlock()
+		case <-written:
+		}
+	}()
+
+	err := stream.Send(batch)
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// Close закрывает поток, неподтвержденные пакеты завершаются ошибкой ErrStreamClosed.
+// Следующая отправка откроет поток заново.
+func (s *Stream) Close() {
+	s.parentMutex.Lock()
+	s.stop()
+	s.parent, s.stop = context.WithCancel(context.Background())
+	s.parentMutex.Unlock()
+
+	s.mutex.Lock()
+	var failed []func(error)
+	if s.stream != nil {
+		failed = s.reset(s.stream, nil)
+	}
+	s.mutex.Unlock()
+
+	finish(failed, ErrStreamClosed)
+}
+
+// connect открывает поток, если он не открыт. Вызывается под блокировкой.
+func (s *Stream) connect() (pb.Metrics_StreamMetricsClient, error) {
+	if s.stream != nil {
+		return s.stream, nil
+	}
+	if time.Now().Before(s.retryAt) {
+		return nil, ErrStreamUnavailable
+	}
+
+	s.parentMutex.Lock()
+	parent := s.parent
+	s.parentMutex.Unlock()
+
+	ctx, cancel := context.WithCancel(metadata.NewOutgoingContext(parent, s.md))
+	stream, err := s.client.StreamMetrics(ctx)
+	if err != nil {
+		cancel()
+		s.fail()
+		return nil, err
+	}
+
+	s.stream = stream
+	s.cancel = cancel
+	go s.receive(stream)
+
+	return stream, nil
+}
+
+// receive читает подтверждения пакетов до закрытия потока.
+func (s *Stream) receive(stream pb.Metrics_StreamMetricsClient) {
+	for {
+		ack, err := stream.Recv()
+		if err != nil {
+			s.mutex.Lock()
+			failed := s.reset(stream, err)
+			s.mutex.Unlock()
+
+			finish(failed, fmt.Errorf("%w: %s", ErrStreamClosed, err.Error()))
+			return
+		}
+
+		s.mutex.Lock()
+		done, ok := s.pending[ack.Seq]
+		delete(s.pending, ack.Seq)
+		s.failures = 0
+		s.mutex.Unlock()
+		if !ok {
+			continue
+		}
+
+		<-s.window
+		if !ack.Ok {
+			done(fmt.Errorf("%w: %s", ErrRejected, ack.Error))
+			continue
+		}
+		done(nil)
+	}
+}
+
+// reset закрывает поток stream, если он еще текущий, и возвращает обработчики
+// неподтвержденных пакетов. Ошибка err откладывает следующее подключение.
+// Вызывается под блокировкой.
+func (s *Stream) reset(stream pb.Metrics_StreamMetricsClient, err error) []func(error) {
+	if s.stream != stream {
+		return nil
+	}
+
+	s.cancel()
+	s.stream = nil
+	if err != nil {
+		s.fail()
+	}
+
+	failed := make([]func(error), 0, len(s.pending))
+	for seq, done := range s.pending {
+		if seq == s.writing {
+			// пакет завершит Send после окончания записи
+			continue
+		}
+		delete(s.pending, seq)
+		<-s.window
+		failed = append(failed, done)
+	}
+	return failed
+}
+
+// fail откладывает следующее подключение после ошибки. Вызывается под блокировкой.
+func (s *Stream) fail() {
+	s.failures++
+	s.retryAt = time.Now().Add(s.reconnect.Delay(s.failures))
+}
+
+func finish(callbacks []func(error), err error) {
+	for _, done := range callbacks {
+		done(err)
+	}
+}
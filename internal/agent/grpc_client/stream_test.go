@@ -0,0 +1,277 @@
+package grpcclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/nickzhog/devops-tool/internal/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// streamServer подтверждает пакеты, отклоняет пакеты с метрикой "bad"
+// и разрывает поток на пакете с метрикой "drop".
+type streamServer struct {
+	pb.UnimplementedMetricsServer
+
+	mutex   sync.Mutex
+	streams int
+	agents  []string
+	release chan struct{} // если задан, подтверждение ждет сигнала
+}
+
+func (s *streamServer) StreamMetrics(stream pb.Metrics_StreamMetricsServer) error {
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	s.mutex.Lock()
+	s.streams++
+	s.agents = append(s.agents, md.Get("x-agent-id")...)
+	s.mutex.Unlock()
+
+	for {
+		batch, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		ack := &pb.MetricsAck{Seq: batch.Seq, Ok: true}
+		switch batch.Metrics[0].Id {
+		case "bad":
+			ack.Ok = false
+			ack.Error = "bad metric"
+		case "drop":
+			return errors.New("connection lost")
+		}
+
+		if s.release != nil {
+			<-s.release
+		}
+		if err = stream.Send(ack); err != nil {
+			return err
+		}
+	}
+}
+
+// connections возвращает количество открытых потоков и идентификаторы агентов из их метаданных.
+func (s *streamServer) connections() (int, []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.streams, append([]string(nil), s.agents...)
+}
+
+func newTestStream(t *testing.T, srv *streamServer, window int) *Stream {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+	grpcSrv := grpc.NewServer()
+	pb.RegisterMetricsServer(grpcSrv, srv)
+	go grpcSrv.Serve(listener)
+	t.Cleanup(grpcSrv.Stop)
+
+	conn, err := grpc.Dial("bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	stream := NewStream(pb.NewMetricsClient(conn), window, metadata.Pairs("x-agent-id", "node1"))
+	stream.reconnect.BaseDelay = time.Millisecond
+	stream.reconnect.MaxDelay = time.Millisecond
+	t.Cleanup(stream.Close)
+
+	return stream
+}
+
+func batch(id string) []*pb.Metric {
+	return []*pb.Metric{{Id: id, Mtype: pb.MType_counter, Delta: 1}}
+}
+
+func wait(t *testing.T, results <-chan error) error {
+	t.Helper()
+
+	select {
+	case err := <-results:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("no ack")
+		return nil
+	}
+}
+
+func TestStream_Send(t *testing.T) {
+	srv := &streamServer{}
+	stream := newTestStream(t, srv, 4)
+	ctx := context.Background()
+
+	results := make(chan error, 10)
+	done := func(err error) { results <- err }
+
+	require.NoError(t, stream.Send(ctx, batch("ok1"), done))
+	require.NoError(t, stream.Send(ctx, batch("bad"), done))
+	require.NoError(t, stream.Send(ctx, batch("ok2"), done))
+
+	assert.NoError(t, wait(t, results))
+	assert.ErrorIs(t, wait(t, results), ErrRejected)
+	assert.NoError(t, wait(t, results))
+
+	// все пакеты отправлены в один поток с метаданными агента
+	streams, agents := srv.connections()
+	assert.Equal(t, 1, streams)
+	assert.Equal(t, []string{"node1"}, agents)
+}
+
+func TestStream_Window(t *testing.T) {
+	srv := &streamServer{release: make(chan struct{})}
+	stream := newTestStream(t, srv, 2)
+
+	results := make(chan error, 10)
+	done := func(err error) { results <- err }
+
+	require.NoError(t, stream.Send(context.Background(), batch("ok1"), done))
+	require.NoError(t, stream.Send(context.Background(), batch("ok2"), done))
+
+	// окно заполнено, третий пакет ждет подтверждения
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, stream.Send(ctx, batch("ok3"), done), context.DeadlineExceeded)
+
+	srv.release <- struct{}{}
+	assert.NoError(t, wait(t, results))
+	require.NoError(t, stream.Send(context.Background(), batch("ok3"), done))
+
+	srv.release <- struct{}{}
+	srv.release <- struct{}{}
+	assert.NoError(t, wait(t, results))
+	assert.NoError(t, wait(t, results))
+}
+
+func TestStream_Reconnect(t *testing.T) {
+	srv := &streamServer{}
+	stream := newTestStream(t, srv, 4)
+	ctx := context.Background()
+
+	results := make(chan error, 10)
+	done := func(err error) { results <- err }
+
+	require.NoError(t, stream.Send(ctx, batch("drop"), done))
+	assert.ErrorIs(t, wait(t, results), ErrStreamClosed)
+
+	// следующая отправка открывает новый поток
+	assert.Eventually(t, func() bool {
+		return stream.Send(ctx, batch("ok"), done) == nil
+	}, time.Second, 5*time.Millisecond)
+	assert.NoError(t, wait(t, results))
+	streams, _ := srv.connections()
+	assert.Equal(t, 2, streams)
+
+	// закрытие потока завершает неподтвержденные пакеты
+	srv.release = make(chan struct{})
+	require.NoError(t, stream.Send(ctx, batch("ok"), done))
+	stream.Close()
+	assert.ErrorIs(t, wait(t, results), ErrStreamClosed)
+	close(srv.release)
+}
+
+// stallServer принимает поток, но не читает из него.
+type stallServer struct {
+	pb.UnimplementedMetricsServer
+}
+
+func (s *stallServer) StreamMetrics(stream pb.Metrics_StreamMetricsServer) error {
+	<-stream.Context().Done()
+	return nil
+}
+
+func TestStream_Stalled(t *testing.T) {
+	listener := bufconn.Listen(1 << 20)
+	// фиксированные окна управления потоком, чтобы сервер не принимал больше 64 КБ
+	grpcSrv := grpc.NewServer(grpc.InitialWindowSize(1<<16), grpc.InitialConnWindowSize(1<<16))
+	pb.RegisterMetricsServer(grpcSrv, &stallServer{})
+	go grpcSrv.Serve(listener)
+	t.Cleanup(grpcSrv.Stop)
+
+	conn, err := grpc.Dial("bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	stream := NewStream(pb.NewMetricsClient(conn), 4, nil)
+	stream.reconnect.BaseDelay = time.Millisecond
+	stream.reconnect.MaxDelay = time.Millisecond
+
+	// пакет больше окна управления потоком HTTP/2
+	metrics := make([]*pb.Metric, 0, 5000)
+	for i := 0; i < cap(metrics); i++ {
+		metrics = append(metrics, &pb.Metric{Id: fmt.Sprintf("metric_with_a_rather_long_name_%d", i), Mtype: pb.MType_counter, Delta: 1})
+	}
+
+	results := make(chan error, 10)
+	done := func(err error) { results <- err }
+
+	// первый пакет занимает квоту записи потока, следующие ждут, пока сервер его прочитает
+	require.NoError(t, stream.Send(context.Background(), metrics, done))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = stream.Send(ctx, metrics, done)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	// поток разорван, первый пакет завершается ошибкой
+	assert.Error(t, wait(t, results))
+
+	// зависшая запись в другом вызове прерывается закрытием потока
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, stream.Send(context.Background(), metrics, done))
+	sent := make(chan error, 1)
+	go func() { sent <- stream.Send(context.Background(), metrics, done) }()
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case err := <-sent:
+		t.Fatalf("Send did not block: %v", err)
+	default:
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		stream.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked")
+	}
+
+	select {
+	case err = <-sent:
+		// неподтвержденные пакеты завершаются ошибкой обрыва, прерванный - ошибкой Send или через done
+		unacked := 2
+		if err != nil {
+			unacked--
+		}
+		for ; unacked > 0; unacked-- {
+			assert.ErrorIs(t, wait(t, results), ErrStreamClosed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Send blocked after Close")
+	}
+}
@@ -0,0 +1,241 @@
+// Package spool реализует очередь пакетов метрик на диске,
+// в которую агент складывает неотправленные данные, пока сервер недоступен.
+//
+// Каждый пакет хранится в отдельном файле с возрастающим номером,
+// поэтому после перезапуска агента пакеты отправляются в исходном порядке.
+package spool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	fileExt   = ".batch"
+	tmpPrefix = "tmp-"
+)
+
+var ErrEmpty = errors.New("spool is empty")
+
+type entry struct {
+	seq     uint64
+	size    int64
+	created time.Time
+}
+
+// Spool - очередь пакетов на диске, ограниченная общим размером и возрастом пакетов.
+// При превышении ограничений удаляются самые старые пакеты.
+type Spool struct {
+	dir     string
+	maxSize int64
+	maxAge  time.Duration
+
+	mutex   sync.Mutex
+	entries []entry
+	size    int64
+	nextSeq uint64
+	dropped uint64
+}
+
+// Open открывает очередь в каталоге dir, создавая его при необходимости,
+// и подхватывает пакеты, оставшиеся от предыдущего запуска.
+// maxSize и maxAge ограничивают очередь, 0 - без ограничения.
+func Open(dir string, maxSize int64, maxAge time.Duration) (*Spool, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Spool{
+		dir:     dir,
+		maxSize: maxSize,
+		maxAge:  maxAge,
+		nextSeq: 1,
+	}
+	for _, f := range files {
+		name := f.Name()
+		if strings.HasPrefix(name, tmpPrefix) {
+			// недописанный пакет
+			os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		if f.IsDir() || !strings.HasSuffix(name, fileExt) {
+			continue
+		}
+
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, fileExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		s.entries = append(s.entries, entry{seq: seq, size: info.Size(), created: info.ModTime()})
+		s.size += info.Size()
+		if seq >= s.nextSeq {
+			s.nextSeq = seq + 1
+		}
+	}
+	sort.Slice(s.entries, func(i, j int) bool {
+		return s.entries[i].seq < s.entries[j].seq
+	})
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.trim(time.Now())
+
+	return s, nil
+}
+
+// Push добавляет пакет в конец очереди.
+func (s *Spool) Push(data []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	seq := s.nextSeq
+	tmp := filepath.Join(s.dir, tmpPrefix+s.fileName(seq))
+	err := os.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, s.path(seq))
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	s.nextSeq++
+	s.entries = append(s.entries, entry{seq: seq, size: int64(len(data)), created: time.Now()})
+	s.size += int64(len(data))
+	s.trim(time.Now())
+
+	return nil
+}
+
+// Replay отправляет пакеты функцией send, начиная с самого старого,
+// и удаляет из очереди успешно отправленные.
+// При первой ошибке отправка прекращается, пакет остается в очереди.
+func (s *Spool) Replay(ctx context.Context, send func(data []byte) error) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		seq, data, err := s.peek()
+		if err == ErrEmpty {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		err = send(data)
+		if err != nil {
+			return err
+		}
+
+		err = s.remove(seq)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Len возвращает количество пакетов в очереди.
+func (s *Spool) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.entries)
+}
+
+// Dropped возвращает количество пакетов, удаленных из-за ограничений очереди.
+func (s *Spool) Dropped() uint64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.dropped
+}
+
+func (s *Spool) peek() (uint64, []byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.trim(time.Now())
+	if len(s.entries) < 1 {
+		return 0, nil, ErrEmpty
+	}
+
+	seq := s.entries[0].seq
+	data, err := os.ReadFile(s.path(seq))
+	if err != nil {
+		return 0, nil, fmt.Errorf("read batch %d: %w", seq, err)
+	}
+
+	return seq, data, nil
+}
+
+func (s *Spool) remove(seq uint64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.entries) < 1 || s.entries[0].seq != seq {
+		// пакет уже удален по ограничениям
+		return nil
+	}
+
+	return s.removeFirst()
+}
+
+// trim удаляет самые старые пакеты, пока очередь не уложится в ограничения.
+// Вызывается под блокировкой.
+func (s *Spool) trim(now time.Time) {
+	for len(s.entries) > 0 {
+		first := s.entries[0]
+		expired := s.maxAge > 0 && now.Sub(first.created) > s.maxAge
+		oversized := s.maxSize > 0 && s.size > s.maxSize
+		if !expired && !oversized {
+			return
+		}
+
+		if s.removeFirst() != nil {
+			return
+		}
+		s.dropped++
+	}
+}
+
+func (s *Spool) removeFirst() error {
+	first := s.entries[0]
+	err := os.Remove(s.path(first.seq))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	s.entries = s.entries[1:]
+	s.size -= first.size
+	return nil
+}
+
+func (s *Spool) fileName(seq uint64) string {
+	return fmt.Sprintf("%020d%s", seq, fileExt)
+}
+
+func (s *Spool) path(seq uint64) string {
+	return filepath.Join(s.dir, s.fileName(seq))
+}
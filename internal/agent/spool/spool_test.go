@@ -0,0 +1,81 @@
+package spool
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpool_Replay(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+
+	s, err := Open(dir, 0, 0)
+	assert.NoError(err)
+
+	for _, v := range []string{"first", "second", "third"} {
+		assert.NoError(s.Push([]byte(v)))
+	}
+
+	// ошибка отправки оставляет пакет в очереди
+	var sent []string
+	errDown := errors.New("server is down")
+	err = s.Replay(context.Background(), func(data []byte) error {
+		if len(sent) == 1 {
+			return errDown
+		}
+		sent = append(sent, string(data))
+		return nil
+	})
+	assert.ErrorIs(err, errDown)
+	assert.Equal([]string{"first"}, sent)
+	assert.Equal(2, s.Len())
+
+	// после перезапуска порядок сохраняется, новые пакеты идут в конец
+	s, err = Open(dir, 0, 0)
+	assert.NoError(err)
+	assert.NoError(s.Push([]byte("fourth")))
+
+	sent = nil
+	err = s.Replay(context.Background(), func(data []byte) error {
+		sent = append(sent, string(data))
+		return nil
+	})
+	assert.NoError(err)
+	assert.Equal([]string{"second", "third", "fourth"}, sent)
+	assert.Equal(0, s.Len())
+}
+
+func TestSpool_Limits(t *testing.T) {
+	assert := assert.New(t)
+
+	s, err := Open(t.TempDir(), 10, 0)
+	assert.NoError(err)
+
+	assert.NoError(s.Push([]byte("aaaa")))
+	assert.NoError(s.Push([]byte("bbbb")))
+	assert.NoError(s.Push([]byte("cccc")))
+	assert.Equal(2, s.Len())
+	assert.Equal(uint64(1), s.Dropped())
+
+	dir := t.TempDir()
+	s, err = Open(dir, 0, time.Hour)
+	assert.NoError(err)
+	assert.NoError(s.Push([]byte("old")))
+
+	old := time.Now().Add(-2 * time.Hour)
+	files, err := filepath.Glob(filepath.Join(dir, "*"+fileExt))
+	assert.NoError(err)
+	for _, f := range files {
+		assert.NoError(os.Chtimes(f, old, old))
+	}
+
+	s, err = Open(dir, 0, time.Hour)
+	assert.NoError(err)
+	assert.Equal(0, s.Len())
+}
@@ -0,0 +1,245 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nickzhog/devops-tool/internal/server/notify"
+	"github.com/nickzhog/devops-tool/pkg/logging"
+	"github.com/nickzhog/devops-tool/pkg/metric"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSource struct {
+	metrics []metric.Metric
+}
+
+func (s *fakeSource) FindAll(ctx context.Context, selector metric.Labels) ([]metric.Metric, error) {
+	return s.metrics, nil
+}
+
+type fakeNotifier struct {
+	mutex  sync.Mutex
+	alerts []Alert
+}
+
+func (n *fakeNotifier) Notify(ctx context.Context, alerts []Alert) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.alerts = append(n.alerts, alerts...)
+	return nil
+}
+
+func (n *fakeNotifier) states() []State {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	states := make([]State, 0, len(n.alerts))
+	for _, a := range n.alerts {
+		states = append(states, a.State)
+	}
+	return states
+}
+
+func newTestEngine(t *testing.T, rules string, source Source) (*Engine, *fakeNotifier) {
+	var cfg Config
+	require.NoError(t, json.Unmarshal([]byte(rules), &cfg))
+	require.NoError(t, cfg.Validate())
+
+	e := NewEngine(&cfg, source, nil, logging.GetLogger())
+	notifier := &fakeNotifier{}
+	e.notifiers = map[string]Notifier{"test": notifier}
+	return e, notifier
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+		"interval": "15s",
+		"receivers": [{"name": "ops", "url": "http://localhost/alerts"}],
+		"rules": [
+			{"name": "HighHeap", "metric": "HeapAlloc", "type": "gauge", "op": ">", "threshold": "500MB", "for": "2m", "receivers": ["ops"]},
+			{"name": "FastPolling", "metric": "PollCount", "type": "counter", "rate": true, "op": ">", "threshold": 10}
+		]
+	}`), 0o600))
+
+	cfg, err := LoadConfig(path)
+	require.NoError(t, err)
+	assert.Equal(t, 15*time.Second, cfg.Interval.Duration)
+	assert.Equal(t, Threshold(500<<20), cfg.Rules[0].Threshold)
+	assert.Equal(t, 2*time.Minute, cfg.Rules[0].For.Duration)
+	assert.Equal(t, Threshold(10), cfg.Rules[1].Threshold)
+
+	tests := []struct {
+		name  string
+		rules string
+	}{
+		{name: "wrong op", rules: `{"rules": [{"name": "a", "metric": "m", "type": "gauge", "op": "=>"}]}`},
+		{name: "rate gauge", rules: `{"rules": [{"name": "a", "metric": "m", "type": "gauge", "rate": true, "op": ">"}]}`},
+		{name: "duplicate", rules: `{"rules": [{"name": "a", "metric": "m", "type": "gauge", "op": ">"}, {"name": "a", "metric": "m", "type": "gauge", "op": "<"}]}`},
+		{name: "unknown receiver", rules: `{"rules": [{"name": "a", "metric": "m", "type": "gauge", "op": ">", "receivers": ["ops"]}]}`},
+		{name: "wrong threshold", rules: `{"rules": [{"name": "a", "metric": "m", "type": "gauge", "op": ">", "threshold": "10XB"}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, os.WriteFile(path, []byte(tt.rules), 0o600))
+			_, err := LoadConfig(path)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestEngine_Threshold(t *testing.T) {
+	source := &fakeSource{}
+	e, notifier := newTestEngine(t, `{"rules": [
+		{"name": "HighHeap", "metric": "HeapAlloc", "type": "gauge", "labels": {"env": "prod"}, "op": ">", "threshold": "1KB", "for": "2m"}
+	]}`, source)
+	ctx := context.Background()
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	set := func(v float64) {
+		m := metric.NewGaugeMetric("HeapAlloc", v)
+		m.Labels = metric.Labels{"env": "prod", "host": "node1"}
+		other := metric.NewGaugeMetric("HeapAlloc", v)
+		other.Labels = metric.Labels{"env": "dev"}
+		source.metrics = []metric.Metric{m, other}
+	}
+
+	set(2048)
+	require.NoError(t, e.Evaluate(ctx, now))
+	alerts := e.Alerts("")
+	require.Len(t, alerts, 1)
+	assert.Equal(t, StatePending, alerts[0].State)
+	assert.Equal(t, "node1", alerts[0].Labels["host"])
+
+	// условие перестало выполняться до For - оповещение удаляется без уведомления
+	set(10)
+	require.NoError(t, e.Evaluate(ctx, now.Add(time.Minute)))
+	assert.Empty(t, e.Alerts(""))
+
+	set(4096)
+	require.NoError(t, e.Evaluate(ctx, now.Add(2*time.Minute)))
+	require.NoError(t, e.Evaluate(ctx, now.Add(3*time.Minute)))
+	assert.Equal(t, StatePending, e.Alerts("")[0].State)
+	require.NoError(t, e.Evaluate(ctx, now.Add(4*time.Minute)))
+	alerts = e.Alerts(StateFiring)
+	require.Len(t, alerts, 1)
+	assert.Equal(t, 4096.0, alerts[0].Value)
+	assert.Equal(t, now.Add(2*time.Minute), alerts[0].ActiveSince)
+	require.NoError(t, e.Evaluate(ctx, now.Add(5*time.Minute)))
+
+	set(10)
+	require.NoError(t, e.Evaluate(ctx, now.Add(6*time.Minute)))
+	alerts = e.Alerts(StateResolved)
+	require.Len(t, alerts, 1)
+	assert.Equal(t, now.Add(6*time.Minute), *alerts[0].ResolvedAt)
+	assert.Equal(t, []State{StateFiring, StateResolved}, notifier.states())
+
+	require.NoError(t, e.Evaluate(ctx, now.Add(30*time.Minute)))
+	assert.Empty(t, e.Alerts(""))
+}
+
+func TestEngine_Rate(t *testing.T) {
+	source := &fakeSource{}
+	e, notifier := newTestEngine(t, `{"rules": [
+		{"name": "FastPolling", "metric": "PollCount", "type": "counter", "rate": true, "op": ">", "threshold": 10}
+	]}`, source)
+	ctx := context.Background()
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	steps := []struct {
+		total int64
+		want  State
+	}{
+		{total: 100, want: ""},           // нет предыдущего значения
+		{total: 200, want: ""},           // 100 за 10s
+		{total: 500, want: StateFiring},  // 300 за 10s
+		{total: 50, want: StateResolved}, // сброс counter: 50 за 10s
+	}
+	for i, step := range steps {
+		source.metrics = []metric.Metric{metric.NewCounterMetric("PollCount", step.total)}
+		require.NoError(t, e.Evaluate(ctx, now.Add(time.Duration(i)*10*time.Second)))
+
+		alerts := e.Alerts("")
+		if step.want == "" {
+			assert.Empty(t, alerts, i)
+			continue
+		}
+		require.Len(t, alerts, 1, i)
+		assert.Equal(t, step.want, alerts[0].State, i)
+	}
+	assert.Equal(t, []State{StateFiring, StateResolved}, notifier.states())
+}
+
+func TestEngine_Absent(t *testing.T) {
+	source := &fakeSource{}
+	e, notifier := newTestEngine(t, `{"rules": [
+		{"name": "AgentDown", "metric": "PollCount", "type": "counter", "absent": "5m"}
+	]}`, source)
+	ctx := context.Background()
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// метрики нет - оповещение сработает через absent
+	require.NoError(t, e.Evaluate(ctx, now))
+	assert.Equal(t, StatePending, e.Alerts("")[0].State)
+	require.NoError(t, e.Evaluate(ctx, now.Add(5*time.Minute)))
+	assert.Equal(t, StateFiring, e.Alerts("")[0].State)
+
+	lastSeen := now.Add(5 * time.Minute)
+	m := metric.NewCounterMetric("PollCount", 10)
+	m.LastSeen = &lastSeen
+	source.metrics = []metric.Metric{m}
+	require.NoError(t, e.Evaluate(ctx, now.Add(6*time.Minute)))
+	assert.Len(t, e.Alerts(StateFiring), 0)
+
+	require.NoError(t, e.Evaluate(ctx, now.Add(11*time.Minute)))
+	alerts := e.Alerts(StateFiring)
+	require.Len(t, alerts, 1)
+	assert.Equal(t, 360.0, alerts[0].Value)
+	assert.Equal(t, []State{StateFiring, StateResolved, StateFiring}, notifier.states())
+}
+
+func TestEngine_Dispatch(t *testing.T) {
+	got := make(chan Payload, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, EventAlert, r.Header.Get(notify.HeaderEvent))
+
+		var payload Payload
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		got <- payload
+	}))
+	defer ts.Close()
+
+	cfg := &Config{
+		Receivers: []notify.Receiver{{Name: "ops", URL: ts.URL}},
+		Rules: []Rule{
+			{Name: "HighHeap", Metric: "HeapAlloc", Type: metric.GaugeType, Op: ">", Threshold: 1},
+		},
+	}
+	dispatcher, err := notify.NewDispatcher(cfg.Receivers, notify.Options{}, logging.GetLogger())
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go dispatcher.Run(ctx)
+
+	source := &fakeSource{metrics: []metric.Metric{metric.NewGaugeMetric("HeapAlloc", 2)}}
+	e := NewEngine(cfg, source, dispatcher, logging.GetLogger())
+	require.NoError(t, e.Evaluate(ctx, time.Now()))
+
+	select {
+	case payload := <-got:
+		assert.Equal(t, "ops", payload.Receiver)
+		require.Len(t, payload.Alerts, 1)
+		assert.Equal(t, "HighHeap", payload.Alerts[0].Rule)
+		assert.Equal(t, StateFiring, payload.Alerts[0].State)
+	case <-time.After(5 * time.Second):
+		t.Fatal("alert is not delivered")
+	}
+}
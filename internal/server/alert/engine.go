@@ -0,0 +1,322 @@
+// Package alert вычисляет правила оповещений по метрикам хранилища
+// и доставляет оповещения получателям.
+package alert
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nickzhog/devops-tool/internal/server/notify"
+	"github.com/nickzhog/devops-tool/pkg/logging"
+	"github.com/nickzhog/devops-tool/pkg/metric"
+)
+
+// resolvedRetention - время, в течение которого разрешенные оповещения отдаются в Alerts.
+const resolvedRetention = 15 * time.Minute
+
+// State - состояние оповещения.
+type State string
+
+const (
+	StatePending  State = "pending"  // условие выполняется меньше For
+	StateFiring   State = "firing"   // условие выполняется не меньше For, получатели оповещены
+	StateResolved State = "resolved" // условие перестало выполняться после firing
+)
+
+// Alert - оповещение по одной метрике (серии) правила.
+type Alert struct {
+	Rule        string        `json:"rule"`
+	Metric      string        `json:"metric"`
+	Type        string        `json:"type"`
+	Labels      metric.Labels `json:"labels,omitempty"`
+	State       State         `json:"state"`
+	Value       float64       `json:"value"`
+	ActiveSince time.Time     `json:"active_since"`
+	FiredAt     *time.Time    `json:"fired_at,omitempty"`
+	ResolvedAt  *time.Time    `json:"resolved_at,omitempty"`
+}
+
+// Source - источник метрик для вычисления правил.
+type Source interface {
+	FindAll(ctx context.Context, selector metric.Labels) ([]metric.Metric, error)
+}
+
+// Notifier доставляет оповещения получателю.
+type Notifier interface {
+	Notify(ctx context.Context, alerts []Alert) error
+}
+
+// series - наблюдение за метрикой между вычислениями.
+type series struct {
+	value     float64
+	at        time.Time
+	changedAt time.Time
+}
+
+// instance - оповещение с правилом, по которому оно вычислено.
+type instance struct {
+	Alert
+	rule    *Rule
+	missing bool // метрики правила absent нет в хранилище
+}
+
+// Engine периодически вычисляет правила и хранит состояние оповещений.
+type Engine struct {
+	rules     []Rule
+	source    Source
+	notifiers map[string]Notifier
+	logger    *logging.Logger
+
+	mutex     sync.RWMutex
+	series    map[string]series
+	instances map[string]*instance
+}
+
+// NewEngine создает движок правил из конфига.
+// Оповещения отправляются получателям из конфига через dispatcher.
+func NewEngine(cfg *Config, source Source, dispatcher *notify.Dispatcher, logger *logging.Logger) *Engine {
+	notifiers := make(map[string]Notifier, len(cfg.Receivers))
+	for _, r := range cfg.Receivers {
+		notifiers[r.Name] = &dispatchNotifier{dispatcher: dispatcher, receiver: r.Name}
+	}
+
+	return &Engine{
+		rules:     cfg.Rules,
+		source:    source,
+		notifiers: notifiers,
+		logger:    logger,
+		series:    make(map[string]series),
+		instances: make(map[string]*instance),
+	}
+}
+
+// Run вычисляет правила с интервалом interval до отмены контекста.
+func (e *Engine) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := e.Evaluate(ctx, now); err != nil {
+				e.logger.Errorf("evaluate alert rules: %s", err.Error())
+			}
+		}
+	}
+}
+
+// Evaluate вычисляет правила на момент now и оповещает получателей
+// о переходах в firing и resolved.
+func (e *Engine) Evaluate(ctx context.Context, now time.Time) error {
+	metrics, err := e.source.FindAll(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	e.mutex.Lock()
+	notify := make(map[string][]Alert)
+	active := make(map[string]bool)
+	observed := make(map[string]bool)
+	for i := range e.rules {
+		rule := &e.rules[i]
+		for key, cur := range e.evaluateRule(rule, metrics, now, observed) {
+			active[key] = true
+			if inst, fired := e.activate(key, cur, now); fired {
+				e.enqueue(notify, inst)
+			}
+		}
+	}
+
+	for key := range e.series {
+		if !observed[key] {
+			delete(e.series, key)
+		}
+	}
+
+	for key, inst := range e.instances {
+		if active[key] {
+			continue
+		}
+		switch inst.State {
+		case StatePending:
+			delete(e.instances, key)
+		case StateFiring:
+			resolvedAt := now
+			inst.State = StateResolved
+			inst.ResolvedAt = &resolvedAt
+			e.enqueue(notify, inst)
+		case StateResolved:
+			if now.Sub(*inst.ResolvedAt) > resolvedRetention {
+				delete(e.instances, key)
+			}
+		}
+	}
+	e.mutex.Unlock()
+
+	for name, alerts := range notify {
+		if err := e.notifiers[name].Notify(ctx, alerts); err != nil {
+			e.logger.Errorf("notify receiver %s: %s", name, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// activate обновляет оповещение, условие которого выполняется.
+// Возвращает сохраненное оповещение и true, если оно перешло в firing.
+func (e *Engine) activate(key string, cur *instance, now time.Time) (*instance, bool) {
+	inst, ok := e.instances[key]
+	if !ok || inst.State == StateResolved {
+		inst = cur
+		inst.State = StatePending
+		inst.ActiveSince = now
+		e.instances[key] = inst
+	}
+	inst.Value = cur.Value
+
+	wait := inst.rule.For.Duration
+	if cur.missing {
+		// отсутствие метрики отсчитывается с первого вычисления, в котором ее нет
+		wait += inst.rule.Absent.Duration
+	}
+	if inst.State != StatePending || now.Sub(inst.ActiveSince) < wait {
+		return inst, false
+	}
+
+	firedAt := now
+	inst.State = StateFiring
+	inst.FiredAt = &firedAt
+	return inst, true
+}
+
+func (e *Engine) enqueue(notify map[string][]Alert, inst *instance) {
+	receivers := inst.rule.Receivers
+	if len(receivers) < 1 {
+		for name := range e.notifiers {
+			receivers = append(receivers, name)
+		}
+	}
+	for _, name := range receivers {
+		notify[name] = append(notify[name], inst.Alert)
+	}
+}
+
+// evaluateRule возвращает оповещения правила, условие которых выполняется, по ключам серий.
+// Ключи серий, найденных в хранилище, отмечаются в observed.
+func (e *Engine) evaluateRule(rule *Rule, metrics []metric.Metric, now time.Time, observed map[string]bool) map[string]*instance {
+	result := make(map[string]*instance)
+	found := false
+	for _, m := range metrics {
+		if m.ID != rule.Metric || m.MType != rule.Type || !m.Labels.Match(rule.Labels) {
+			continue
+		}
+		found = true
+
+		key := rule.Name + "\x00" + metric.SeriesKey(m.ID, m.Labels)
+		cur, ok := metricValue(m)
+		if !ok {
+			continue
+		}
+
+		prev, seen := e.series[key]
+		obs := series{value: cur, at: now, changedAt: prev.changedAt}
+		if !seen || prev.value != cur {
+			obs.changedAt = now
+		}
+		e.series[key] = obs
+		observed[key] = true
+
+		value, firing := cur, false
+		switch {
+		case rule.Absent.Duration > 0:
+			updated := obs.changedAt
+			if m.LastSeen != nil {
+				updated = *m.LastSeen
+			}
+			value = now.Sub(updated).Seconds()
+			firing = now.Sub(updated) >= rule.Absent.Duration
+		case rule.Rate:
+			if !seen || !now.After(prev.at) {
+				continue
+			}
+			delta := cur - prev.value
+			if delta < 0 {
+				// сброс counter
+				delta = cur
+			}
+			value = delta / now.Sub(prev.at).Seconds()
+			firing = operators[rule.Op](value, float64(rule.Threshold))
+		default:
+			firing = operators[rule.Op](value, float64(rule.Threshold))
+		}
+		if !firing {
+			continue
+		}
+
+		result[key] = &instance{
+			Alert: Alert{
+				Rule:   rule.Name,
+				Metric: m.ID,
+				Type:   m.MType,
+				Labels: m.Labels,
+				Value:  value,
+			},
+			rule: rule,
+		}
+	}
+
+	if !found && rule.Absent.Duration > 0 {
+		key := rule.Name + "\x00" + metric.SeriesKey(rule.Metric, rule.Labels)
+		result[key] = &instance{
+			Alert: Alert{
+				Rule:   rule.Name,
+				Metric: rule.Metric,
+				Type:   rule.Type,
+				Labels: rule.Labels,
+			},
+			rule:    rule,
+			missing: true,
+		}
+	}
+
+	return result
+}
+
+// metricValue возвращает значение gauge или накопленное значение counter.
+func metricValue(m metric.Metric) (float64, bool) {
+	switch {
+	case m.MType == metric.GaugeType && m.Value != nil:
+		return *m.Value, true
+	case m.MType == metric.CounterType && m.Delta != nil:
+		return float64(*m.Delta), true
+	}
+	return 0, false
+}
+
+// Alerts возвращает текущие оповещения, отсортированные по правилу и labels.
+// Пустой state возвращает оповещения во всех состояниях.
+func (e *Engine) Alerts(state State) []Alert {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	alerts := make([]Alert, 0, len(e.instances))
+	for _, inst := range e.instances {
+		if state != "" && inst.State != state {
+			continue
+		}
+		alerts = append(alerts, inst.Alert)
+	}
+
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].Rule != alerts[j].Rule {
+			return alerts[i].Rule < alerts[j].Rule
+		}
+		return alerts[i].Labels.String() < alerts[j].Labels.String()
+	})
+
+	return alerts
+}
@@ -0,0 +1,199 @@
+package alert
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nickzhog/devops-tool/internal/server/notify"
+	"github.com/nickzhog/devops-tool/pkg/metric"
+)
+
+// Config - файл правил оповещений.
+//
+// Пример:
+//
+//	{
+//		"interval": "15s",
+//		"receivers": [{"name": "ops", "url": "http://hooks.local/alerts", "secret": "s3cret"}],
+//		"rules": [
+//			{"name": "HighHeap", "metric": "HeapAlloc", "type": "gauge", "op": ">", "threshold": "500MB", "for": "2m"},
+//			{"name": "FastPolling", "metric": "PollCount", "type": "counter", "rate": true, "op": ">", "threshold": 10},
+//			{"name": "AgentDown", "metric": "PollCount", "type": "counter", "absent": "5m", "receivers": ["ops"]}
+//		]
+//	}
+type Config struct {
+	Interval  Duration          `json:"interval,omitempty"`
+	Receivers []notify.Receiver `json:"receivers,omitempty"`
+	Rules     []Rule            `json:"rules"`
+}
+
+// Rule - правило оповещения для метрики.
+//
+// Правило срабатывает для каждой метрики с именем Metric, типом Type и labels, содержащими Labels:
+//   - по умолчанию, если значение (для counter - накопленное) удовлетворяет условию Op Threshold;
+//   - если Rate, сравнивается скорость роста counter в секунду между вычислениями;
+//   - если задан Absent, метрика не обновлялась агентами дольше Absent или отсутствует в хранилище.
+//
+// Оповещение переходит в состояние firing, если условие выполняется не меньше For.
+// Receivers - имена получателей, по умолчанию оповещения отправляются всем получателям.
+type Rule struct {
+	Name      string        `json:"name"`
+	Metric    string        `json:"metric"`
+	Type      string        `json:"type"`
+	Labels    metric.Labels `json:"labels,omitempty"`
+	Op        string        `json:"op,omitempty"`
+	Threshold Threshold     `json:"threshold,omitempty"`
+	Rate      bool          `json:"rate,omitempty"`
+	Absent    Duration      `json:"absent,omitempty"`
+	For       Duration      `json:"for,omitempty"`
+	Receivers []string      `json:"receivers,omitempty"`
+}
+
+// LoadConfig читает и проверяет файл правил.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg Config
+	if err = json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parse alert rules %s: %w", path, err)
+	}
+	if err = cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("alert rules %s: %w", path, err)
+	}
+
+	return &cfg, nil
+}
+
+// Validate проверяет правила и ссылки на получателей.
+func (c *Config) Validate() error {
+	receivers := make(map[string]bool)
+	for _, r := range c.Receivers {
+		if r.Name == "" || r.URL == "" {
+			return errors.New("receiver name and url are required")
+		}
+		receivers[r.Name] = true
+	}
+
+	names := make(map[string]bool)
+	for _, rule := range c.Rules {
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("rule %q: %w", rule.Name, err)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("rule %q: duplicate name", rule.Name)
+		}
+		names[rule.Name] = true
+
+		for _, name := range rule.Receivers {
+			if !receivers[name] {
+				return fmt.Errorf("rule %q: unknown receiver %q", rule.Name, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r Rule) validate() error {
+	switch {
+	case r.Name == "":
+		return errors.New("name is required")
+	case r.Metric == "":
+		return errors.New("metric is required")
+	case r.Type != metric.GaugeType && r.Type != metric.CounterType:
+		return fmt.Errorf("unsupported metric type %q", r.Type)
+	case r.Rate && r.Type != metric.CounterType:
+		return errors.New("rate is supported for counters only")
+	case r.Absent.Duration > 0:
+		return nil
+	}
+
+	if _, ok := operators[r.Op]; !ok {
+		return fmt.Errorf("wrong op %q", r.Op)
+	}
+	return nil
+}
+
+var operators = map[string]func(v, threshold float64) bool{
+	">":  func(v, t float64) bool { return v > t },
+	">=": func(v, t float64) bool { return v >= t },
+	"<":  func(v, t float64) bool { return v < t },
+	"<=": func(v, t float64) bool { return v <= t },
+	"==": func(v, t float64) bool { return v == t },
+	"!=": func(v, t float64) bool { return v != t },
+}
+
+// Duration - time.Duration, задаваемая в JSON строкой вида "2m30s".
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"2m\": %w", err)
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+// Threshold - порог срабатывания правила. В JSON задается числом
+// или строкой с суффиксом размера: KB, MB, GB, TB (степени 1024).
+type Threshold float64
+
+var sizeSuffixes = []struct {
+	suffix string
+	factor float64
+}{
+	{"TB", 1 << 40},
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+func (t *Threshold) UnmarshalJSON(data []byte) error {
+	var f float64
+	if err := json.Unmarshal(data, &f); err == nil {
+		*t = Threshold(f)
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.New("threshold must be a number or a string like \"500MB\"")
+	}
+
+	s = strings.ToUpper(strings.TrimSpace(s))
+	factor := 1.0
+	for _, v := range sizeSuffixes {
+		if strings.HasSuffix(s, v.suffix) {
+			s, factor = strings.TrimSpace(strings.TrimSuffix(s, v.suffix)), v.factor
+			break
+		}
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return fmt.Errorf("wrong threshold %q", string(data))
+	}
+	*t = Threshold(f * factor)
+	return nil
+}
@@ -0,0 +1,31 @@
+package alert
+
+import (
+	"context"
+
+	"github.com/nickzhog/devops-tool/internal/server/notify"
+)
+
+// EventAlert - тип события notify для оповещений.
+const EventAlert = "alert"
+
+// Payload - данные события alert. Без шаблона получателя тело запроса - JSON Payload:
+//
+//	{"receiver": "ops", "alerts": [{"rule": "HighHeap", "state": "firing", ...}]}
+type Payload struct {
+	Receiver string  `json:"receiver"`
+	Alerts   []Alert `json:"alerts"`
+}
+
+// dispatchNotifier ставит оповещения в очередь notify.Dispatcher для одного получателя.
+type dispatchNotifier struct {
+	dispatcher *notify.Dispatcher
+	receiver   string
+}
+
+func (n *dispatchNotifier) Notify(ctx context.Context, alerts []Alert) error {
+	return n.dispatcher.Send(n.receiver, notify.Message{
+		Event: EventAlert,
+		Data:  Payload{Receiver: n.receiver, Alerts: alerts},
+	})
+}
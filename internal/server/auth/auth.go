@@ -0,0 +1,112 @@
+// Package auth реализует аутентификацию агентов и клиентов по токенам (API-ключам).
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Permission - право доступа ключа.
+type Permission string
+
+const (
+	PermWrite Permission = "write" // отправка метрик
+	PermRead  Permission = "read"  // чтение метрик, истории и списка агентов
+	PermAdmin Permission = "admin" // все права, включая отладочные обработчики
+)
+
+var (
+	ErrNoToken      = errors.New("missing token")
+	ErrUnknownToken = errors.New("unknown or expired token")
+	ErrForbidden    = errors.New("permission denied")
+)
+
+// ParsePermission проверяет имя права доступа и возвращает ошибку для неизвестного.
+func ParsePermission(name string) (Permission, error) {
+	switch p := Permission(name); p {
+	case PermWrite, PermRead, PermAdmin:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown permission %q", name)
+}
+
+// Key - API-ключ: токен, агент, которому он выдан, и права доступа.
+type Key struct {
+	AgentID     string       `json:"agent_id,omitempty"`
+	Permissions []Permission `json:"permissions"`
+	ExpiresAt   *time.Time   `json:"expires_at,omitempty"`
+}
+
+// Allows сообщает, есть ли у ключа право perm. Право admin включает все остальные.
+func (k Key) Allows(perm Permission) bool {
+	for _, p := range k.Permissions {
+		if p == perm || p == PermAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired сообщает, истек ли срок действия ключа на момент now.
+func (k Key) Expired(now time.Time) bool {
+	return k.ExpiresAt != nil && !now.Before(*k.ExpiresAt)
+}
+
+// KeyStore - хранилище API-ключей.
+// Для ротации ключа в хранилище добавляется новый ключ агента, а старый удаляется
+// после перехода агента на новый, перезапуск сервера не нужен.
+type KeyStore interface {
+	// Lookup возвращает действующий ключ по токену или ErrUnknownToken.
+	Lookup(ctx context.Context, token string) (Key, error)
+}
+
+// HashToken возвращает SHA-256 токена в hex, в хранилищах хранятся только хэши токенов.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// ParseBearer извлекает токен из значения заголовка Authorization вида "Bearer <token>".
+func ParseBearer(header string) (string, error) {
+	scheme, token, ok := strings.Cut(strings.TrimSpace(header), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+		return "", ErrNoToken
+	}
+	return strings.TrimSpace(token), nil
+}
+
+// Authenticate проверяет заголовок Authorization и наличие у ключа права perm.
+func Authenticate(ctx context.Context, keys KeyStore, header string, perm Permission) (Key, error) {
+	token, err := ParseBearer(header)
+	if err != nil {
+		return Key{}, err
+	}
+
+	key, err := keys.Lookup(ctx, token)
+	if err != nil {
+		return Key{}, err
+	}
+	if !key.Allows(perm) {
+		return key, ErrForbidden
+	}
+
+	return key, nil
+}
+
+type keyCtx struct{}
+
+// WithKey сохраняет в контексте ключ, которым аутентифицирован запрос.
+func WithKey(ctx context.Context, key Key) context.Context {
+	return context.WithValue(ctx, keyCtx{}, key)
+}
+
+// KeyFromContext возвращает ключ запроса из контекста.
+func KeyFromContext(ctx context.Context) (Key, bool) {
+	key, ok := ctx.Value(keyCtx{}).(Key)
+	return key, ok
+}
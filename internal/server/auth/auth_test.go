@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nickzhog/devops-tool/pkg/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBearer(t *testing.T) {
+	tests := []struct {
+		header  string
+		want    string
+		wantErr bool
+	}{
+		{header: "Bearer secret", want: "secret"},
+		{header: "bearer  secret ", want: "secret"},
+		{header: "", wantErr: true},
+		{header: "Bearer", wantErr: true},
+		{header: "Basic dXNlcjpwYXNz", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			got, err := ParseBearer(tt.header)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrNoToken)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[
+		{"token": "agent-token", "agent_id": "node1", "permissions": ["write"]},
+		{"token_sha256": "`+HashToken("reader-token")+`", "permissions": ["read"]},
+		{"token": "admin-token", "permissions": ["admin"]},
+		{"token": "expired-token", "permissions": ["read"], "expires_at": "2000-01-01T00:00:00Z"}
+	]`), 0o600))
+
+	keys, err := NewFileStore(path, logging.GetLogger())
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		header  string
+		perm    Permission
+		agentID string
+		wantErr error
+	}{
+		{name: "agent write", header: "Bearer agent-token", perm: PermWrite, agentID: "node1"},
+		{name: "agent read", header: "Bearer agent-token", perm: PermRead, wantErr: ErrForbidden},
+		{name: "hashed token", header: "Bearer reader-token", perm: PermRead},
+		{name: "admin", header: "Bearer admin-token", perm: PermWrite},
+		{name: "expired", header: "Bearer expired-token", perm: PermRead, wantErr: ErrUnknownToken},
+		{name: "unknown", header: "Bearer other", perm: PermRead, wantErr: ErrUnknownToken},
+		{name: "missing", header: "", perm: PermRead, wantErr: ErrNoToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := Authenticate(ctx, keys, tt.header, tt.perm)
+			assert.ErrorIs(t, err, tt.wantErr)
+			if tt.wantErr == nil {
+				assert.Equal(t, tt.agentID, key.AgentID)
+			}
+		})
+	}
+}
+
+func TestFileStore_Run(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[{"token": "old", "permissions": ["write"]}]`), 0o600))
+
+	keys, err := NewFileStore(path, logging.GetLogger())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go keys.Run(ctx, 10*time.Millisecond)
+
+	// ротация: новый ключ заменяет старый без перезапуска
+	require.NoError(t, os.WriteFile(path, []byte(`[{"token": "new", "permissions": ["write"]}]`), 0o600))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Second)))
+
+	assert.Eventually(t, func() bool {
+		_, err := keys.Lookup(ctx, "new")
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	_, err = keys.Lookup(ctx, "old")
+	assert.ErrorIs(t, err, ErrUnknownToken)
+
+	// ошибка в файле не сбрасывает действующие ключи
+	require.NoError(t, os.WriteFile(path, []byte(`[{"permissions": ["write"]}]`), 0o600))
+	assert.Error(t, keys.Reload())
+	_, err = keys.Lookup(ctx, "new")
+	assert.NoError(t, err)
+
+	// неизвестное право отклоняется, прежние ключи продолжают действовать
+	require.NoError(t, os.WriteFile(path, []byte(`[{"token": "typo", "permissions": ["wirte"]}]`), 0o600))
+	assert.Error(t, keys.Reload())
+	_, err = keys.Lookup(ctx, "new")
+	assert.NoError(t, err)
+	_, err = keys.Lookup(ctx, "typo")
+	assert.ErrorIs(t, err, ErrUnknownToken)
+}
+
+func TestNewFileStore_UnknownPermission(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[{"token": "t", "permissions": ["read", "superuser"]}]`), 0o600))
+
+	_, err := NewFileStore(path, logging.GetLogger())
+	assert.ErrorContains(t, err, `unknown permission "superuser"`)
+}
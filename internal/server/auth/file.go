@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nickzhog/devops-tool/pkg/logging"
+)
+
+// fileKey - ключ в файле. Токен задается открытым текстом (token) или хэшем SHA-256 (token_sha256).
+type fileKey struct {
+	Key
+	Token       string `json:"token,omitempty"`
+	TokenSHA256 string `json:"token_sha256,omitempty"`
+}
+
+// FileStore - хранилище ключей в JSON-файле, перечитываемом при изменении.
+//
+// Формат файла:
+//
+//	[
+//		{"token": "secret", "agent_id": "node1", "permissions": ["write"]},
+//		{"token_sha256": "2bb80d53...", "permissions": ["read"], "expires_at": "2024-01-01T00:00:00Z"}
+//	]
+type FileStore struct {
+	path   string
+	logger *logging.Logger
+
+	mutex   sync.RWMutex
+	keys    map[string]Key // хэш токена -> ключ
+	modTime time.Time
+}
+
+// NewFileStore загружает ключи из файла path.
+func NewFileStore(path string, logger *logging.Logger) (*FileStore, error) {
+	s := &FileStore{path: path, logger: logger}
+
+	err := s.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Reload перечитывает файл. При ошибке продолжают действовать прежние ключи.
+func (s *FileStore) Reload() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+
+	var list []fileKey
+	if err = json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("parse keys file %s: %w", s.path, err)
+	}
+
+	keys := make(map[string]Key, len(list))
+	for i, k := range list {
+		hash := k.TokenSHA256
+		if k.Token != "" {
+			hash = HashToken(k.Token)
+		}
+		if hash == "" {
+			return fmt.Errorf("parse keys file %s: key #%d has no token", s.path, i+1)
+		}
+		for _, p := range k.Permissions {
+			if _, err = ParsePermission(string(p)); err != nil {
+				return fmt.Errorf("parse keys file %s: key #%d: %w", s.path, i+1, err)
+			}
+		}
+		keys[hash] = k.Key
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.keys = keys
+	s.modTime = info.ModTime()
+
+	return nil
+}
+
+// Run перечитывает файл с интервалом interval, если он изменился, до отмены ctx.
+func (s *FileStore) Run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			info, err := os.Stat(s.path)
+			if err != nil {
+				s.logger.Errorf("keys file: %s", err.Error())
+				continue
+			}
+
+			s.mutex.RLock()
+			changed := !info.ModTime().Equal(s.modTime)
+			s.mutex.RUnlock()
+			if !changed {
+				continue
+			}
+
+			if err = s.Reload(); err != nil {
+				s.logger.Errorf("keys file reload: %s", err.Error())
+				continue
+			}
+			s.logger.Trace("keys file reloaded")
+		}
+	}
+}
+
+func (s *FileStore) Lookup(ctx context.Context, token string) (Key, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	key, ok := s.keys[HashToken(token)]
+	if !ok || key.Expired(time.Now()) {
+		return Key{}, ErrUnknownToken
+	}
+	return key, nil
+}
@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/nickzhog/devops-tool/pkg/postgres"
+)
+
+// PostgresStore - хранилище ключей в таблице api_keys.
+// Ключи читаются при каждом запросе, поэтому добавление и удаление ключей действуют сразу.
+//
+// Добавление ключа:
+//
+//	INSERT INTO api_keys (token_hash, agent_id, permissions)
+//	VALUES (encode(sha256('secret'), 'hex'), 'node1', '{write}');
+type PostgresStore struct {
+	client postgres.Client
+}
+
+func NewPostgresStore(client postgres.Client) *PostgresStore {
+	return &PostgresStore{client: client}
+}
+
+func (s *PostgresStore) Lookup(ctx context.Context, token string) (Key, error) {
+	q := `
+		SELECT 
+			agent_id, permissions, expires_at
+		FROM 
+			public.api_keys
+		WHERE 
+			token_hash = $1;
+	`
+
+	var key Key
+	var perms []string
+	err := s.client.QueryRow(ctx, q, HashToken(token)).Scan(&key.AgentID, &perms, &key.ExpiresAt)
+	if err == pgx.ErrNoRows {
+		return Key{}, ErrUnknownToken
+	}
+	if err != nil {
+		return Key{}, err
+	}
+	if key.Expired(time.Now()) {
+		return Key{}, ErrUnknownToken
+	}
+
+	for _, name := range perms {
+		p, err := ParsePermission(name)
+		if err != nil {
+			return Key{}, err
+		}
+		key.Permissions = append(key.Permissions, p)
+	}
+	return key, nil
+}
@@ -10,12 +10,19 @@ import (
 type Config struct {
 	PostgresStorage struct {
 		DatabaseDSN string `env:"DATABASE_DSN"`
+
+		SamplePartition time.Duration `env:"SAMPLE_PARTITION"` // ширина секции metric_samples по времени
+
+		RollupMinuteRetention time.Duration `env:"ROLLUP_1M_RETENTION"` // сколько хранить минутные агрегаты истории, 0 - не агрегировать
+		RollupHourRetention   time.Duration `env:"ROLLUP_1H_RETENTION"` // сколько хранить часовые агрегаты истории, 0 - не агрегировать
 	}
 
 	RedisStorage struct {
 		Addr     string `env:"REDIS_ADDR"`
 		Password string `env:"REDIS_PASSWORD"`
 		DB       int    `env:"REDIS_DB"`
+
+		MetricTTL time.Duration `env:"REDIS_METRIC_TTL"` // метрика удаляется, если не обновлялась дольше TTL, 0 - не удалять
 	}
 
 	Settings struct {
@@ -26,12 +33,35 @@ type Config struct {
 		Restore       bool          `env:"RESTORE"`
 		StoreInterval time.Duration `env:"STORE_INTERVAL"`
 
-		TrustedSubnet string `env:"TRUSTED_SUBNET"`
+		HistoryRetention time.Duration `env:"HISTORY_RETENTION"` // сколько хранить историю значений метрик, 0 - не хранить
+
+		TrustedSubnet  string `env:"TRUSTED_SUBNET"`  // разрешенные подсети CIDR или адреса IPv4/IPv6 через запятую
+		TrustedProxies string `env:"TRUSTED_PROXIES"` // прокси, чьим заголовкам X-Forwarded-For и X-Real-IP можно доверять
 
 		Key string `env:"ENCRYPTION_KEY"` // ключ для вычисления хэша метрики
 
 		CryptoKey string `env:"CRYPTO_KEY"` // путь до файла с приватным ключем (ассиметричное шифрование)
 
+		AuthKeysFile string `env:"AUTH_KEYS_FILE"` // путь до JSON-файла с API-ключами, включает аутентификацию по токенам
+		AuthKeysDB   bool   `env:"AUTH_KEYS_DB"`   // хранить API-ключи в таблице api_keys Postgres, включает аутентификацию по токенам
+
+		TLSCert     string `env:"TLS_CERT"`      // путь до сертификата HTTP-сервера, включает HTTPS
+		TLSKey      string `env:"TLS_KEY"`       // путь до ключа сертификата HTTP-сервера
+		TLSClientCA string `env:"TLS_CLIENT_CA"` // путь до сертификатов CA клиентов, включает mutual TLS
+
+		GRPCTLSCert     string `env:"GRPC_TLS_CERT"`      // путь до сертификата gRPC-сервера, включает TLS
+		GRPCTLSKey      string `env:"GRPC_TLS_KEY"`       // путь до ключа сертификата gRPC-сервера
+		GRPCTLSClientCA string `env:"GRPC_TLS_CLIENT_CA"` // путь до сертификатов CA клиентов, включает mutual TLS
+
+		PrometheusLabels string `env:"PROMETHEUS_LABELS"` // labels, добавляемые ко всем метрикам в /metrics, в формате "k1=v1,k2=v2"
+
+		AlertRules string `env:"ALERT_RULES"` // путь до JSON-файла с правилами оповещений, включает вычисление правил
+
+		NotifyRetryMaxAttempts int           `env:"NOTIFY_RETRY_MAX_ATTEMPTS"` // количество попыток отправки webhook, включая первую
+		NotifyRetryBaseDelay   time.Duration `env:"NOTIFY_RETRY_BASE_DELAY"`   // задержка перед первым повтором, далее удваивается
+		NotifyRetryMaxDelay    time.Duration `env:"NOTIFY_RETRY_MAX_DELAY"`    // максимальная задержка между повторами
+		NotifyDeadLetterLog    string        `env:"NOTIFY_DEAD_LETTER_LOG"`    // путь до журнала недоставленных событий
+
 	}
 }
 
@@ -41,21 +71,48 @@ func GetConfig() *Config {
 	flag.StringVar(&cfg.Settings.Address, "a", ":8080", "address for server listen")
 
 	flag.StringVar(&cfg.PostgresStorage.DatabaseDSN, "d", "", "database dsn")
+	flag.DurationVar(&cfg.PostgresStorage.SamplePartition, "sample-partition", 24*time.Hour, "time range of a metric_samples partition")
+	flag.DurationVar(&cfg.PostgresStorage.RollupMinuteRetention, "rollup-1m-retention", 7*24*time.Hour, "how long to keep 1-minute history rollups, 0 disables them")
+	flag.DurationVar(&cfg.PostgresStorage.RollupHourRetention, "rollup-1h-retention", 90*24*time.Hour, "how long to keep 1-hour history rollups, 0 disables them")
 
 	flag.StringVar(&cfg.RedisStorage.Addr, "redis_addr", "", "redis address")
 	flag.StringVar(&cfg.RedisStorage.Password, "redis_psw", "", "redis password")
 	flag.IntVar(&cfg.RedisStorage.DB, "redis_db", 0, "redis database")
+	flag.DurationVar(&cfg.RedisStorage.MetricTTL, "redis_metric_ttl", 0, "remove redis metrics not updated for this long, 0 keeps them forever")
 
 	flag.StringVar(&cfg.Settings.StoreFile, "f", "/tmp/devops-metrics-db.json", "file path for save and load metrics")
 	flag.BoolVar(&cfg.Settings.Restore, "r", true, "restore latest values")
 	flag.DurationVar(&cfg.Settings.StoreInterval, "i", time.Second, "interval for file update")
 
-	flag.StringVar(&cfg.Settings.TrustedSubnet, "t", "", "trusted subnet")
+	flag.DurationVar(&cfg.Settings.HistoryRetention, "history-retention", time.Hour, "how long to keep metric history, 0 disables history")
+
+	flag.StringVar(&cfg.Settings.TrustedSubnet, "t", "", "trusted subnets, comma separated CIDRs or addresses")
+	flag.StringVar(&cfg.Settings.TrustedProxies, "trusted-proxies", "", "proxies whose forwarded headers are trusted, comma separated CIDRs or addresses")
 
 	flag.StringVar(&cfg.Settings.Key, "k", "", "key for calculate hash of metric")
 
 	flag.StringVar(&cfg.Settings.CryptoKey, "crypto-key", "", "private.key path for RSA encryption")
 
+	flag.StringVar(&cfg.Settings.AuthKeysFile, "auth-keys-file", "", "api keys json file path, enables token authentication")
+	flag.BoolVar(&cfg.Settings.AuthKeysDB, "auth-keys-db", false, "use api_keys postgres table, enables token authentication")
+
+	flag.StringVar(&cfg.Settings.TLSCert, "tls-cert", "", "http server tls certificate path")
+	flag.StringVar(&cfg.Settings.TLSKey, "tls-key", "", "http server tls key path")
+	flag.StringVar(&cfg.Settings.TLSClientCA, "tls-client-ca", "", "ca certificates path for http client verification (mutual tls)")
+
+	flag.StringVar(&cfg.Settings.GRPCTLSCert, "grpc-tls-cert", "", "grpc server tls certificate path")
+	flag.StringVar(&cfg.Settings.GRPCTLSKey, "grpc-tls-key", "", "grpc server tls key path")
+	flag.StringVar(&cfg.Settings.GRPCTLSClientCA, "grpc-tls-client-ca", "", "ca certificates path for grpc client verification (mutual tls)")
+
+	flag.StringVar(&cfg.Settings.PrometheusLabels, "prometheus-labels", "", "labels for /metrics endpoint, k1=v1,k2=v2")
+
+	flag.StringVar(&cfg.Settings.AlertRules, "alert-rules", "", "alert rules json file path")
+
+	flag.IntVar(&cfg.Settings.NotifyRetryMaxAttempts, "notify-retry-max-attempts", 5, "webhook send attempts including the first one")
+	flag.DurationVar(&cfg.Settings.NotifyRetryBaseDelay, "notify-retry-base-delay", time.Second, "delay before the first webhook retry")
+	flag.DurationVar(&cfg.Settings.NotifyRetryMaxDelay, "notify-retry-max-delay", time.Minute, "max delay between webhook retries")
+	flag.StringVar(&cfg.Settings.NotifyDeadLetterLog, "notify-dead-letter-log", "", "undelivered webhook events log path")
+
 	flag.Parse()
 
 	env.Parse(&cfg.Settings)
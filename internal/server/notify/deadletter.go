@@ -0,0 +1,50 @@
+package notify
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// DeadLetter - событие, которое не удалось доставить получателю.
+type DeadLetter struct {
+	Time     time.Time       `json:"time"`
+	Receiver string          `json:"receiver"`
+	URL      string          `json:"url,omitempty"`
+	Event    string          `json:"event"`
+	Body     json.RawMessage `json:"body,omitempty"`
+	Attempts int             `json:"attempts"`
+	Error    string          `json:"error"`
+}
+
+// deadLetterLog дописывает недоставленные события в файл, по одному JSON на строку.
+type deadLetterLog struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+func openDeadLetterLog(path string) (*deadLetterLog, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &deadLetterLog{file: file}, nil
+}
+
+func (l *deadLetterLog) write(letter DeadLetter) error {
+	data, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	_, err = l.file.Write(append(data, '\n'))
+	return err
+}
+
+func (l *deadLetterLog) close() error {
+	return l.file.Close()
+}
@@ -0,0 +1,246 @@
+// Package notify доставляет события сервера получателям webhook:
+// шаблонизирует и подписывает тело запроса, повторяет отправку с экспоненциальной задержкой
+// и записывает недоставленные события в журнал (dead letter).
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nickzhog/devops-tool/pkg/logging"
+	"github.com/nickzhog/devops-tool/pkg/retry"
+)
+
+var (
+	ErrUnknownReceiver = errors.New("unknown receiver")
+	ErrQueueFull       = errors.New("notification queue is full")
+	ErrShutdown        = errors.New("dispatcher is stopped")
+)
+
+// Message - событие для отправки получателю.
+type Message struct {
+	Event string      // тип события, например alert
+	Time  time.Time   // время события, по умолчанию время постановки в очередь
+	Data  interface{} // данные события, кодируются в JSON или передаются в шаблон
+}
+
+// Options - параметры Dispatcher.
+type Options struct {
+	Retry         retry.Policy  // политика повтора отправки
+	Timeout       time.Duration // таймаут одной попытки, по умолчанию 10s
+	QueueSize     int           // размер очереди событий, по умолчанию 100
+	Workers       int           // количество одновременных отправок, по умолчанию 1
+	DeadLetterLog string        // путь до журнала недоставленных событий, пустой - только лог
+}
+
+type delivery struct {
+	webhook *webhook
+	msg     Message
+}
+
+// Dispatcher доставляет события получателям в фоне.
+type Dispatcher struct {
+	webhooks   map[string]*webhook
+	opts       Options
+	queue      chan delivery
+	deadLetter *deadLetterLog
+	logger     *logging.Logger
+	now        func() time.Time
+
+	mutex   sync.RWMutex   // защищает stopped
+	stopped bool           // Run завершен, новые события не принимаются
+	active  sync.WaitGroup // вызовы Send и Deliver, начатые до остановки
+}
+
+// NewDispatcher создает Dispatcher для получателей. События отправляются после запуска Run.
+func NewDispatcher(receivers []Receiver, opts Options, logger *logging.Logger) (*Dispatcher, error) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultTimeout
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 100
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+
+	client := &http.Client{Timeout: opts.Timeout}
+	webhooks := make(map[string]*webhook, len(receivers))
+	for _, r := range receivers {
+		if _, ok := webhooks[r.Name]; ok {
+			return nil, fmt.Errorf("duplicate receiver %q", r.Name)
+		}
+		w, err := newWebhook(r, client)
+		if err != nil {
+			return nil, err
+		}
+		webhooks[r.Name] = w
+	}
+
+	d := &Dispatcher{
+		webhooks: webhooks,
+		opts:     opts,
+		queue:    make(chan delivery, opts.QueueSize),
+		logger:   logger,
+		now:      time.Now,
+	}
+
+	if opts.DeadLetterLog != "" {
+		deadLetter, err := openDeadLetterLog(opts.DeadLetterLog)
+		if err != nil {
+			return nil, fmt.Errorf("open dead letter log: %w", err)
+		}
+		d.deadLetter = deadLetter
+	}
+
+	return d, nil
+}
+
+// Send ставит событие в очередь на отправку получателю receiver.
+// Если очередь заполнена, событие сразу записывается в журнал недоставленных.
+// После остановки Run возвращает ErrShutdown.
+func (d *Dispatcher) Send(receiver string, msg Message) error {
+	w, ok := d.webhooks[receiver]
+	if !ok {
+		return fmt.Errorf("%w %q", ErrUnknownReceiver, receiver)
+	}
+	if msg.Time.IsZero() {
+		msg.Time = d.now()
+	}
+
+	if !d.begin() {
+		return ErrShutdown
+	}
+	defer d.active.Done()
+
+	select {
+	case d.queue <- delivery{webhook: w, msg: msg}:
+		return nil
+	default:
+		d.fail(w, msg, nil, 0, ErrQueueFull)
+		return ErrQueueFull
+	}
+}
+
+// begin регистрирует вызов Send или Deliver. После остановки возвращает false.
+func (d *Dispatcher) begin() bool {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	if d.stopped {
+		return false
+	}
+	d.active.Add(1)
+	return true
+}
+
+// Run отправляет события из очереди до отмены контекста.
+// После отмены Dispatcher перестает принимать события, дожидается начатых вызовов
+// Send и Deliver, записывает оставшиеся в очереди события в журнал недоставленных
+// и закрывает журнал.
+func (d *Dispatcher) Run(ctx context.Context) {
+	done := make(chan struct{})
+	for i := 0; i < d.opts.Workers; i++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			for ctx.Err() == nil {
+				select {
+				case <-ctx.Done():
+					return
+				case item := <-d.queue:
+					d.deliver(ctx, item.webhook, item.msg)
+				}
+			}
+		}()
+	}
+	for i := 0; i < d.opts.Workers; i++ {
+		<-done
+	}
+
+	d.mutex.Lock()
+	d.stopped = true
+	d.mutex.Unlock()
+	d.active.Wait()
+
+	for {
+		select {
+		case item := <-d.queue:
+			d.fail(item.webhook, item.msg, nil, 0, ErrShutdown)
+		default:
+			if d.deadLetter != nil {
+				d.deadLetter.close()
+			}
+			return
+		}
+	}
+}
+
+// Deliver отправляет событие получателю receiver, повторяя отправку согласно политике.
+// Недоставленное событие записывается в журнал недоставленных.
+// После остановки Run возвращает ErrShutdown.
+func (d *Dispatcher) Deliver(ctx context.Context, receiver string, msg Message) error {
+	w, ok := d.webhooks[receiver]
+	if !ok {
+		return fmt.Errorf("%w %q", ErrUnknownReceiver, receiver)
+	}
+	if msg.Time.IsZero() {
+		msg.Time = d.now()
+	}
+
+	if !d.begin() {
+		return ErrShutdown
+	}
+	defer d.active.Done()
+
+	return d.deliver(ctx, w, msg)
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, w *webhook, msg Message) error {
+	body, err := w.body(msg)
+	if err != nil {
+		err = fmt.Errorf("render body: %w", err)
+		d.fail(w, msg, nil, 0, err)
+		return err
+	}
+
+	attempts := 0
+	err = d.opts.Retry.Do(ctx, func(ctx context.Context) error {
+		attempts++
+		return w.post(ctx, msg.Event, body, d.now())
+	})
+	if err != nil {
+		d.fail(w, msg, body, attempts, err)
+		return err
+	}
+
+	d.logger.Tracef("%s event delivered to %s", msg.Event, w.Name)
+	return nil
+}
+
+// fail записывает недоставленное событие в журнал.
+func (d *Dispatcher) fail(w *webhook, msg Message, body []byte, attempts int, err error) {
+	d.logger.Errorf("deliver %s event to %s after %d attempts: %s", msg.Event, w.Name, attempts, err.Error())
+	if d.deadLetter == nil {
+		return
+	}
+
+	if body == nil {
+		body, _ = w.body(msg)
+	}
+	werr := d.deadLetter.write(DeadLetter{
+		Time:     d.now(),
+		Receiver: w.Name,
+		URL:      w.URL,
+		Event:    msg.Event,
+		Body:     body,
+		Attempts: attempts,
+		Error:    err.Error(),
+	})
+	if werr != nil {
+		d.logger.Errorf("write dead letter: %s", werr.Error())
+	}
+}
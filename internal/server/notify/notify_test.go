@@ -0,0 +1,198 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nickzhog/devops-tool/pkg/logging"
+	"github.com/nickzhog/devops-tool/pkg/retry"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testData struct {
+	Name  string  `json:"name"`
+	Value float64 `json:"value"`
+}
+
+func readDeadLetters(t *testing.T, path string) []DeadLetter {
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+
+	letters := make([]DeadLetter, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var letter DeadLetter
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &letter))
+		letters = append(letters, letter)
+	}
+	return letters
+}
+
+func TestDispatcher_Deliver(t *testing.T) {
+	var (
+		status   atomic.Int32
+		requests atomic.Int32
+		lastBody []byte
+		lastReq  *http.Request
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		lastBody, _ = io.ReadAll(r.Body)
+		lastReq = r
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer ts.Close()
+
+	deadLetterLog := filepath.Join(t.TempDir(), "dead.log")
+	d, err := NewDispatcher([]Receiver{
+		{Name: "plain", URL: ts.URL},
+		{
+			Name:     "signed",
+			URL:      ts.URL,
+			Secret:   "s3cret",
+			Template: `{"text": {{json (printf "%s=%v" .Data.Name .Data.Value)}}, "event": {{json .Event}}}`,
+			Headers:  map[string]string{"X-Team": "ops"},
+		},
+		{Name: "broken", URL: ts.URL, Template: `{"text": {{.Data.Name}}}`},
+	}, Options{
+		Retry:         retry.Policy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+		DeadLetterLog: deadLetterLog,
+	}, logging.GetLogger())
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	msg := Message{Event: "test", Data: testData{Name: "Alloc", Value: 1.5}}
+
+	t.Run("json body", func(t *testing.T) {
+		status.Store(http.StatusOK)
+		requests.Store(0)
+		require.NoError(t, d.Deliver(ctx, "plain", msg))
+		assert.Equal(t, int32(1), requests.Load())
+		assert.JSONEq(t, `{"name": "Alloc", "value": 1.5}`, string(lastBody))
+		assert.Equal(t, "test", lastReq.Header.Get(HeaderEvent))
+		assert.Empty(t, lastReq.Header.Get(HeaderSignature))
+	})
+
+	t.Run("template and signature", func(t *testing.T) {
+		require.NoError(t, d.Deliver(ctx, "signed", msg))
+		assert.JSONEq(t, `{"text": "Alloc=1.5", "event": "test"}`, string(lastBody))
+		assert.Equal(t, "ops", lastReq.Header.Get("X-Team"))
+
+		timestamp := lastReq.Header.Get(HeaderTimestamp)
+		assert.NotEmpty(t, timestamp)
+		assert.Equal(t, "sha256="+Sign("s3cret", timestamp, lastBody), lastReq.Header.Get(HeaderSignature))
+	})
+
+	t.Run("retry server errors", func(t *testing.T) {
+		status.Store(http.StatusServiceUnavailable)
+		requests.Store(0)
+		assert.Error(t, d.Deliver(ctx, "plain", msg))
+		assert.Equal(t, int32(3), requests.Load())
+	})
+
+	t.Run("client errors are not retried", func(t *testing.T) {
+		status.Store(http.StatusBadRequest)
+		requests.Store(0)
+		assert.Error(t, d.Deliver(ctx, "plain", msg))
+		assert.Equal(t, int32(1), requests.Load())
+	})
+
+	t.Run("invalid template output", func(t *testing.T) {
+		status.Store(http.StatusOK)
+		requests.Store(0)
+		assert.ErrorIs(t, d.Deliver(ctx, "broken", msg), ErrInvalidBody)
+		assert.Equal(t, int32(0), requests.Load())
+	})
+
+	assert.ErrorIs(t, d.Deliver(ctx, "unknown", msg), ErrUnknownReceiver)
+
+	letters := readDeadLetters(t, deadLetterLog)
+	require.Len(t, letters, 3)
+	assert.Equal(t, "plain", letters[0].Receiver)
+	assert.Equal(t, 3, letters[0].Attempts)
+	assert.JSONEq(t, `{"name": "Alloc", "value": 1.5}`, string(letters[0].Body))
+	assert.Equal(t, 1, letters[1].Attempts)
+	assert.Equal(t, "broken", letters[2].Receiver)
+	assert.Equal(t, 0, letters[2].Attempts)
+}
+
+func TestDispatcher_Run(t *testing.T) {
+	delivered := make(chan string, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var data testData
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&data))
+		delivered <- data.Name
+	}))
+	defer ts.Close()
+
+	d, err := NewDispatcher([]Receiver{{Name: "ops", URL: ts.URL}}, Options{Workers: 2}, logging.GetLogger())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+
+	require.NoError(t, d.Send("ops", Message{Event: "test", Data: testData{Name: "first"}}))
+	assert.ErrorIs(t, d.Send("unknown", Message{Event: "test"}), ErrUnknownReceiver)
+
+	select {
+	case name := <-delivered:
+		assert.Equal(t, "first", name)
+	case <-time.After(5 * time.Second):
+		t.Fatal("event is not delivered")
+	}
+}
+
+func TestDispatcher_RunShutdown(t *testing.T) {
+	deadLetterLog := filepath.Join(t.TempDir(), "dead.log")
+	d, err := NewDispatcher([]Receiver{{Name: "ops", URL: "http://localhost"}}, Options{
+		QueueSize:     1,
+		DeadLetterLog: deadLetterLog,
+	}, logging.GetLogger())
+	require.NoError(t, err)
+
+	require.NoError(t, d.Send("ops", Message{Event: "test", Data: testData{Name: "queued"}}))
+	assert.ErrorIs(t, d.Send("ops", Message{Event: "test", Data: testData{Name: "dropped"}}), ErrQueueFull)
+
+	// события, оставшиеся в очереди после остановки, записываются в журнал
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	d.Run(ctx)
+
+	letters := readDeadLetters(t, deadLetterLog)
+	require.Len(t, letters, 2)
+	assert.Equal(t, ErrQueueFull.Error(), letters[0].Error)
+	assert.JSONEq(t, `{"name": "dropped", "value": 0}`, string(letters[0].Body))
+	assert.Equal(t, ErrShutdown.Error(), letters[1].Error)
+	assert.JSONEq(t, `{"name": "queued", "value": 0}`, string(letters[1].Body))
+
+	// после остановки события не принимаются, журнал закрыт
+	assert.ErrorIs(t, d.Send("ops", Message{Event: "test"}), ErrShutdown)
+	assert.ErrorIs(t, d.Deliver(context.Background(), "ops", Message{Event: "test"}), ErrShutdown)
+	assert.Len(t, readDeadLetters(t, deadLetterLog), 2)
+}
+
+func TestNewDispatcher(t *testing.T) {
+	_, err := NewDispatcher([]Receiver{{Name: "ops"}}, Options{}, logging.GetLogger())
+	assert.Error(t, err)
+
+	_, err = NewDispatcher([]Receiver{{Name: "ops", URL: "http://localhost", Template: "{{"}}, Options{}, logging.GetLogger())
+	assert.Error(t, err)
+
+	_, err = NewDispatcher([]Receiver{
+		{Name: "ops", URL: "http://localhost"},
+		{Name: "ops", URL: "http://localhost"},
+	}, Options{}, logging.GetLogger())
+	assert.Error(t, err)
+}
@@ -0,0 +1,151 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/nickzhog/devops-tool/pkg/metric"
+	"github.com/nickzhog/devops-tool/pkg/retry"
+)
+
+// Заголовки запросов webhook.
+const (
+	HeaderEvent     = "X-Event"
+	HeaderTimestamp = "X-Timestamp"
+	HeaderSignature = "X-Signature" // "sha256=" и HMAC-SHA256 "<timestamp>.<body>" в hex
+)
+
+const defaultTimeout = 10 * time.Second
+
+var ErrInvalidBody = errors.New("template produced invalid json")
+
+// Receiver - получатель событий: URL, принимающий POST с JSON.
+//
+// Template - шаблон text/template тела запроса, по умолчанию тело - JSON данных события.
+// В шаблоне доступны .Receiver, .Event, .Time и .Data, функция json кодирует значение в JSON:
+//
+//	{"text": {{json (printf "%d alerts" (len .Data.Alerts))}}}
+//
+// Если задан Secret, запрос подписывается заголовком X-Signature.
+type Receiver struct {
+	Name     string            `json:"name"`
+	URL      string            `json:"url"`
+	Secret   string            `json:"secret,omitempty"`
+	Template string            `json:"template,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+}
+
+// templateData - данные шаблона тела запроса.
+type templateData struct {
+	Receiver string
+	Event    string
+	Time     time.Time
+	Data     interface{}
+}
+
+// webhook отправляет события одному получателю.
+type webhook struct {
+	Receiver
+	template *template.Template
+	client   *http.Client
+}
+
+func newWebhook(r Receiver, client *http.Client) (*webhook, error) {
+	if r.Name == "" || r.URL == "" {
+		return nil, errors.New("receiver name and url are required")
+	}
+
+	w := &webhook{Receiver: r, client: client}
+	if r.Template != "" {
+		tmpl, err := template.New(r.Name).Funcs(template.FuncMap{"json": toJSON}).Parse(r.Template)
+		if err != nil {
+			return nil, fmt.Errorf("receiver %s template: %w", r.Name, err)
+		}
+		w.template = tmpl
+	}
+
+	return w, nil
+}
+
+func toJSON(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	return string(data), err
+}
+
+// body возвращает тело запроса для события.
+func (w *webhook) body(msg Message) ([]byte, error) {
+	if w.template == nil {
+		return json.Marshal(msg.Data)
+	}
+
+	var buf bytes.Buffer
+	err := w.template.Execute(&buf, templateData{
+		Receiver: w.Name,
+		Event:    msg.Event,
+		Time:     msg.Time,
+		Data:     msg.Data,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, ErrInvalidBody
+	}
+
+	return buf.Bytes(), nil
+}
+
+// post отправляет тело запроса получателю.
+// Ответы 4xx, кроме 408 и 429, не повторяются.
+func (w *webhook) post(ctx context.Context, event string, body []byte, now time.Time) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return retry.Permanent(err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set(HeaderEvent, event)
+	if w.Secret != "" {
+		timestamp := strconv.FormatInt(now.Unix(), 10)
+		req.Header.Set(HeaderTimestamp, timestamp)
+		req.Header.Set(HeaderSignature, "sha256="+Sign(w.Secret, timestamp, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < http.StatusBadRequest {
+		return nil
+	}
+
+	err = fmt.Errorf("unexpected status %s", resp.Status)
+	if resp.StatusCode < http.StatusInternalServerError &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return retry.Permanent(err)
+	}
+	return err
+}
+
+// Sign возвращает подпись тела запроса: HMAC-SHA256 "<timestamp>.<body>" в hex.
+// Получатель проверяет подпись, вычисляя ее по заголовку X-Timestamp и телу запроса.
+func Sign(secret, timestamp string, body []byte) string {
+	data := make([]byte, 0, len(timestamp)+1+len(body))
+	data = append(data, timestamp...)
+	data = append(data, '.')
+	data = append(data, body...)
+
+	return metric.Sign(secret, data)
+}
@@ -0,0 +1,290 @@
+// Package query реализует язык запросов к метрикам хранилища:
+// отбор по шаблонам имен и матчерам меток, rate для counter и агрегации по сериям.
+package query
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/nickzhog/devops-tool/pkg/metric"
+)
+
+// Source - источник метрик и их истории.
+type Source interface {
+	FindAll(ctx context.Context, selector metric.Labels) ([]metric.Metric, error)
+	FindHistory(ctx context.Context, name, mtype string, labels metric.Labels, from, to time.Time, step time.Duration) ([]metric.Sample, error)
+}
+
+// Series - серия результата запроса.
+// Для gauge и counter Name и Type - имя и тип метрики, для counter Value - накопленное значение.
+// rate сохраняет имя без типа, агрегации - только метки By.
+type Series struct {
+	Name   string        `json:"name,omitempty"`
+	Type   string        `json:"type,omitempty"`
+	Labels metric.Labels `json:"labels,omitempty"`
+	Value  float64       `json:"value"`
+}
+
+// InstantLookback - насколько раньше момента EvaluateAt ищется значение серии в истории.
+const InstantLookback = 5 * time.Minute
+
+// Evaluate вычисляет выражение на момент now по текущим значениям метрик,
+// now задает только окно rate.
+// Серии результата отсортированы по имени и меткам.
+func Evaluate(ctx context.Context, src Source, expr Expr, now time.Time) ([]Series, error) {
+	return evaluate(ctx, &evaluator{src: src, now: now}, expr)
+}
+
+// EvaluateAt вычисляет выражение на прошедший момент at: селекторы возвращают последнее
+// значение серии из истории не раньше at-InstantLookback, серии без такого значения пропускаются.
+// Серии результата отсортированы по имени и меткам.
+func EvaluateAt(ctx context.Context, src Source, expr Expr, at time.Time) ([]Series, error) {
+	return evaluate(ctx, &evaluator{src: src, now: at, history: true}, expr)
+}
+
+func evaluate(ctx context.Context, e *evaluator, expr Expr) ([]Series, error) {
+	result, err := e.eval(ctx, expr)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Name != result[j].Name {
+			return result[i].Name < result[j].Name
+		}
+		return result[i].Labels.String() < result[j].Labels.String()
+	})
+	return result, nil
+}
+
+type evaluator struct {
+	src     Source
+	now     time.Time
+	history bool // значения селекторов читаются из истории на момент now
+	metrics []metric.Metric
+}
+
+func (e *evaluator) eval(ctx context.Context, expr Expr) ([]Series, error) {
+	switch expr := expr.(type) {
+	case *Selector:
+		metrics, err := e.selectMetrics(ctx, expr)
+		if err != nil {
+			return nil, err
+		}
+
+		result := make([]Series, 0, len(metrics))
+		for _, m := range metrics {
+			value, ok := metricValue(m)
+			if e.history {
+				value, ok, err = e.historyValue(ctx, m)
+				if err != nil {
+					return nil, err
+				}
+			}
+			if !ok {
+				continue
+			}
+			result = append(result, Series{Name: m.ID, Type: m.MType, Labels: m.Labels, Value: value})
+		}
+		return result, nil
+
+	case *Rate:
+		return e.evalRate(ctx, expr)
+
+	case *Aggregate:
+		series, err := e.eval(ctx, expr.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return aggregate(expr, series), nil
+	}
+
+	return nil, fmt.Errorf("unsupported expression %T", expr)
+}
+
+// selectMetrics возвращает метрики хранилища, подходящие под селектор.
+// Метрики читаются из хранилища один раз за запрос.
+func (e *evaluator) selectMetrics(ctx context.Context, s *Selector) ([]metric.Metric, error) {
+	if e.metrics == nil {
+		metrics, err := e.src.FindAll(ctx, nil)
+		if err != nil {
+			return nil, err
+		}
+		e.metrics = metrics
+	}
+
+	result := make([]metric.Metric, 0)
+	for _, m := range e.metrics {
+		if s.Match(m) {
+			result = append(result, m)
+		}
+	}
+	return result, nil
+}
+
+// historyValue возвращает последнее значение gauge или counter из истории
+// за InstantLookback до момента вычисления.
+func (e *evaluator) historyValue(ctx context.Context, m metric.Metric) (float64, bool, error) {
+	if m.MType != metric.GaugeType && m.MType != metric.CounterType {
+		return 0, false, nil
+	}
+
+	samples, err := e.src.FindHistory(ctx, m.ID, m.MType, m.Labels, e.now.Add(-InstantLookback), e.now, 0)
+	if err != nil || len(samples) < 1 {
+		return 0, false, err
+	}
+
+	last := samples[len(samples)-1]
+	switch {
+	case last.Value != nil && m.MType == metric.GaugeType:
+		return *last.Value, true, nil
+	case last.Delta != nil && m.MType == metric.CounterType:
+		return float64(*last.Delta), true, nil
+	}
+	return 0, false, nil
+}
+
+// Match сообщает, подходит ли метрика под селектор.
+func (s *Selector) Match(m metric.Metric) bool {
+	if !s.matchName(m.ID) {
+		return false
+	}
+	for _, matcher := range s.Matchers {
+		var v string
+		switch matcher.Label {
+		case NameLabel:
+			v = m.ID
+		case TypeLabel:
+			v = m.MType
+		default:
+			v = m.Labels[matcher.Label]
+		}
+		if !matcher.Match(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// evalRate вычисляет скорость роста counter по истории за окно.
+// Сброс counter (уменьшение значения) считается началом с нуля.
+// Серии, у которых в окне меньше двух точек, пропускаются.
+func (e *evaluator) evalRate(ctx context.Context, r *Rate) ([]Series, error) {
+	metrics, err := e.selectMetrics(ctx, r.Selector)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]Series, 0, len(metrics))
+	for _, m := range metrics {
+		if m.MType != metric.CounterType {
+			continue
+		}
+
+		samples, err := e.src.FindHistory(ctx, m.ID, m.MType, m.Labels, e.now.Add(-r.Range), e.now, 0)
+		if err != nil {
+			return nil, err
+		}
+		if len(samples) < 2 {
+			continue
+		}
+
+		var increase float64
+		for i := 1; i < len(samples); i++ {
+			prev, cur := samples[i-1].Delta, samples[i].Delta
+			if prev == nil || cur == nil {
+				continue
+			}
+			if *cur < *prev {
+				increase += float64(*cur)
+				continue
+			}
+			increase += float64(*cur - *prev)
+		}
+
+		elapsed := samples[len(samples)-1].Timestamp.Sub(samples[0].Timestamp).Seconds()
+		if elapsed <= 0 {
+			continue
+		}
+		result = append(result, Series{Name: m.ID, Labels: m.Labels, Value: increase / elapsed})
+	}
+
+	return result, nil
+}
+
+// aggregate объединяет серии в группы по значениям меток By.
+func aggregate(a *Aggregate, series []Series) []Series {
+	type group struct {
+		labels metric.Labels
+		values []float64
+	}
+
+	groups := make(map[string]*group)
+	for _, s := range series {
+		labels := make(metric.Labels, len(a.By))
+		for _, name := range a.By {
+			if v, ok := s.Labels[name]; ok {
+				labels[name] = v
+			}
+		}
+
+		key := labels.String()
+		g, ok := groups[key]
+		if !ok {
+			g = &group{labels: labels}
+			groups[key] = g
+		}
+		g.values = append(g.values, s.Value)
+	}
+
+	result := make([]Series, 0, len(groups))
+	for _, g := range groups {
+		if len(g.labels) < 1 {
+			g.labels = nil
+		}
+		result = append(result, Series{Labels: g.labels, Value: reduce(a.Op, g.values)})
+	}
+	return result
+}
+
+func reduce(op string, values []float64) float64 {
+	switch op {
+	case "count":
+		return float64(len(values))
+	case "min":
+		v := math.Inf(1)
+		for _, x := range values {
+			v = math.Min(v, x)
+		}
+		return v
+	case "max":
+		v := math.Inf(-1)
+		for _, x := range values {
+			v = math.Max(v, x)
+		}
+		return v
+	}
+
+	var sum float64
+	for _, x := range values {
+		sum += x
+	}
+	if op == "avg" {
+		return sum / float64(len(values))
+	}
+	return sum
+}
+
+// metricValue возвращает значение gauge или накопленное значение counter.
+func metricValue(m metric.Metric) (float64, bool) {
+	switch {
+	case m.MType == metric.GaugeType && m.Value != nil:
+		return *m.Value, true
+	case m.MType == metric.CounterType && m.Delta != nil:
+		return float64(*m.Delta), true
+	}
+	return 0, false
+}
@@ -0,0 +1,446 @@
+package query
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Специальные метки матчеров: имя и тип метрики.
+const (
+	NameLabel = "__name__"
+	TypeLabel = "__type__"
+)
+
+// defaultRange - окно rate, если оно не задано.
+const defaultRange = 5 * time.Minute
+
+var ErrSyntax = errors.New("query syntax error")
+
+// Expr - выражение запроса: *Selector, *Rate или *Aggregate.
+type Expr interface {
+	String() string
+}
+
+// MatchOp - оператор сравнения метки.
+type MatchOp string
+
+const (
+	MatchEqual     MatchOp = "="
+	MatchNotEqual  MatchOp = "!="
+	MatchRegexp    MatchOp = "=~"
+	MatchNotRegexp MatchOp = "!~"
+)
+
+// Matcher - условие на значение метки.
+type Matcher struct {
+	Label string
+	Op    MatchOp
+	Value string
+	re    *regexp.Regexp
+}
+
+func newMatcher(label string, op MatchOp, value string) (*Matcher, error) {
+	m := &Matcher{Label: label, Op: op, Value: value}
+	if op == MatchRegexp || op == MatchNotRegexp {
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrSyntax, err.Error())
+		}
+		m.re = re
+	}
+	return m, nil
+}
+
+// Match сообщает, подходит ли значение метки под условие. Отсутствующая метка - пустая строка.
+func (m *Matcher) Match(v string) bool {
+	switch m.Op {
+	case MatchEqual:
+		return v == m.Value
+	case MatchNotEqual:
+		return v != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(v)
+	default:
+		return !m.re.MatchString(v)
+	}
+}
+
+func (m *Matcher) String() string {
+	return m.Label + string(m.Op) + strconv.Quote(m.Value)
+}
+
+// Selector отбирает метрики по имени (допускаются шаблоны * и ?) и матчерам меток.
+type Selector struct {
+	Name     string
+	Matchers []*Matcher
+}
+
+func (s *Selector) String() string {
+	if len(s.Matchers) < 1 {
+		return s.Name
+	}
+	list := make([]string, 0, len(s.Matchers))
+	for _, m := range s.Matchers {
+		list = append(list, m.String())
+	}
+	return s.Name + "{" + strings.Join(list, ", ") + "}"
+}
+
+// matchName сообщает, подходит ли имя метрики под шаблон.
+func (s *Selector) matchName(name string) bool {
+	if s.Name == "" {
+		return true
+	}
+	ok, _ := path.Match(s.Name, name)
+	return ok
+}
+
+// Rate - скорость роста counter в секунду за окно Range.
+type Rate struct {
+	Selector *Selector
+	Range    time.Duration
+}
+
+func (r *Rate) String() string {
+	return fmt.Sprintf("rate(%s[%s])", r.Selector, r.Range)
+}
+
+// Aggregate объединяет серии выражения в одну или несколько, по значениям меток By.
+type Aggregate struct {
+	Op   string
+	By   []string
+	Expr Expr
+}
+
+func (a *Aggregate) String() string {
+	if len(a.By) < 1 {
+		return fmt.Sprintf("%s(%s)", a.Op, a.Expr)
+	}
+	return fmt.Sprintf("%s by (%s) (%s)", a.Op, strings.Join(a.By, ", "), a.Expr)
+}
+
+var aggregations = map[string]bool{"sum": true, "avg": true, "min": true, "max": true, "count": true}
+
+// Parse разбирает запрос.
+//
+// Грамматика:
+//
+//	expr      = aggregate | rate | selector
+//	aggregate = ("sum" | "avg" | "min" | "max" | "count") [by] "(" expr ")" [by]
+//	by        = "by" "(" label {"," label} ")"
+//	rate      = "rate" "(" selector ["[" duration "]"] ")"
+//	selector  = name ["{" matchers "}"] | "{" matchers "}"
+//	matchers  = label ("=" | "!=" | "=~" | "!~") string {"," ...}
+//
+// Примеры:
+//
+//	HeapAlloc{host="node1"}
+//	sum by (host) (rate(PollCount[1m]))
+//	max({__name__=~"Heap.*", env!="dev"})
+func Parse(q string) (Expr, error) {
+	p := &parser{input: q}
+	p.next()
+
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected %s", p.tok)
+	}
+	return expr, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokDuration
+	tokPunct
+	tokError
+)
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of query"
+	}
+	return strconv.Quote(t.value)
+}
+
+type parser struct {
+	input string
+	pos   int
+	tok   token
+	// inRange - лексер читает длительность в квадратных скобках
+	inRange bool
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w at %d: %s", ErrSyntax, p.tok.pos+1, fmt.Sprintf(format, args...))
+}
+
+func isIdentRune(r rune, glob bool) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == ':' || r == '.' || r == '-' ||
+		glob && (r == '*' || r == '?')
+}
+
+// next читает следующую лексему.
+func (p *parser) next() {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+	start := p.pos
+	if p.pos >= len(p.input) {
+		p.tok = token{kind: tokEOF, pos: start}
+		return
+	}
+
+	c := p.input[p.pos]
+	switch {
+	case p.inRange:
+		end := strings.IndexByte(p.input[p.pos:], ']')
+		if end < 0 {
+			end = len(p.input) - p.pos
+		}
+		p.pos += end
+		p.tok = token{kind: tokDuration, value: strings.TrimSpace(p.input[start:p.pos]), pos: start}
+
+	case c == '"' || c == '\'' || c == '`':
+		end := p.pos + 1
+		for end < len(p.input) && p.input[end] != c {
+			if p.input[end] == '\\' && c != '`' {
+				end++
+			}
+			end++
+		}
+		if end >= len(p.input) {
+			p.tok = token{kind: tokError, value: "unterminated string", pos: start}
+			p.pos = len(p.input)
+			return
+		}
+		p.pos = end + 1
+
+		raw := p.input[start:p.pos]
+		if c == '\'' {
+			raw = `"` + strings.ReplaceAll(raw[1:len(raw)-1], `"`, `\"`) + `"`
+		}
+		value, err := strconv.Unquote(raw)
+		if err != nil {
+			p.tok = token{kind: tokError, value: "wrong string " + raw, pos: start}
+			return
+		}
+		p.tok = token{kind: tokString, value: value, pos: start}
+
+	case isIdentRune(rune(c), true):
+		for p.pos < len(p.input) && isIdentRune(rune(p.input[p.pos]), true) {
+			p.pos++
+		}
+		p.tok = token{kind: tokIdent, value: p.input[start:p.pos], pos: start}
+
+	default:
+		p.pos++
+		if p.pos < len(p.input) && (c == '=' || c == '!') && (p.input[p.pos] == '~' || p.input[p.pos] == '=') {
+			p.pos++
+		}
+		p.tok = token{kind: tokPunct, value: p.input[start:p.pos], pos: start}
+	}
+}
+
+func (p *parser) expect(punct string) error {
+	if p.tok.kind != tokPunct || p.tok.value != punct {
+		return p.errorf("expected %q, got %s", punct, p.tok)
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) isPunct(punct string) bool {
+	return p.tok.kind == tokPunct && p.tok.value == punct
+}
+
+func (p *parser) parseExpr() (Expr, error) {
+	if p.tok.kind == tokError {
+		return nil, p.errorf("%s", p.tok.value)
+	}
+	if p.tok.kind == tokIdent {
+		name := p.tok.value
+		switch {
+		case aggregations[name]:
+			p.next()
+			if p.isPunct("(") || p.tok.kind == tokIdent && p.tok.value == "by" {
+				return p.parseAggregate(name)
+			}
+			return p.parseSelector(name)
+		case name == "rate":
+			p.next()
+			if p.isPunct("(") {
+				return p.parseRate()
+			}
+			return p.parseSelector(name)
+		}
+		p.next()
+		return p.parseSelector(name)
+	}
+	if p.isPunct("{") {
+		return p.parseSelector("")
+	}
+
+	return nil, p.errorf("unexpected %s", p.tok)
+}
+
+func (p *parser) parseAggregate(op string) (Expr, error) {
+	agg := &Aggregate{Op: op}
+	if p.tok.kind == tokIdent && p.tok.value == "by" {
+		by, err := p.parseBy()
+		if err != nil {
+			return nil, err
+		}
+		agg.By = by
+	}
+
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	agg.Expr = expr
+	if err = p.expect(")"); err != nil {
+		return nil, err
+	}
+
+	if p.tok.kind == tokIdent && p.tok.value == "by" {
+		if agg.By != nil {
+			return nil, p.errorf("duplicate by")
+		}
+		if agg.By, err = p.parseBy(); err != nil {
+			return nil, err
+		}
+	}
+
+	return agg, nil
+}
+
+func (p *parser) parseBy() ([]string, error) {
+	p.next()
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	labels := make([]string, 0)
+	for !p.isPunct(")") {
+		if p.tok.kind != tokIdent || strings.ContainsAny(p.tok.value, "*?") {
+			return nil, p.errorf("expected label, got %s", p.tok)
+		}
+		labels = append(labels, p.tok.value)
+		p.next()
+		if !p.isPunct(",") {
+			break
+		}
+		p.next()
+	}
+
+	return labels, p.expect(")")
+}
+
+func (p *parser) parseRate() (Expr, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokIdent && !p.isPunct("{") {
+		return nil, p.errorf("rate expects a selector, got %s", p.tok)
+	}
+
+	name := ""
+	if p.tok.kind == tokIdent {
+		name = p.tok.value
+		p.next()
+	}
+	selector, err := p.parseSelector(name)
+	if err != nil {
+		return nil, err
+	}
+
+	rate := &Rate{Selector: selector.(*Selector), Range: defaultRange}
+	if p.isPunct("[") {
+		p.inRange = true
+		p.next()
+		p.inRange = false
+
+		d, err := time.ParseDuration(p.tok.value)
+		if err != nil || d <= 0 {
+			return nil, p.errorf("wrong range %s", p.tok)
+		}
+		rate.Range = d
+		p.next()
+		if err = p.expect("]"); err != nil {
+			return nil, err
+		}
+	}
+
+	return rate, p.expect(")")
+}
+
+// parseSelector разбирает матчеры после имени. Имя уже прочитано.
+func (p *parser) parseSelector(name string) (Expr, error) {
+	s := &Selector{Name: name}
+	if _, err := path.Match(name, ""); err != nil {
+		return nil, p.errorf("wrong name pattern %q", name)
+	}
+	if !p.isPunct("{") {
+		return s, nil
+	}
+	p.next()
+
+	for !p.isPunct("}") {
+		if p.tok.kind != tokIdent || strings.ContainsAny(p.tok.value, "*?") {
+			return nil, p.errorf("expected label, got %s", p.tok)
+		}
+		label := p.tok.value
+		p.next()
+
+		op := MatchOp(p.tok.value)
+		if p.tok.kind != tokPunct || op != MatchEqual && op != MatchNotEqual && op != MatchRegexp && op != MatchNotRegexp {
+			return nil, p.errorf("expected match operator, got %s", p.tok)
+		}
+		p.next()
+
+		if p.tok.kind != tokString {
+			return nil, p.errorf("expected string, got %s", p.tok)
+		}
+		m, err := newMatcher(label, op, p.tok.value)
+		if err != nil {
+			return nil, err
+		}
+		s.Matchers = append(s.Matchers, m)
+		p.next()
+
+		if !p.isPunct(",") {
+			break
+		}
+		p.next()
+	}
+	if err := p.expect("}"); err != nil {
+		return nil, err
+	}
+
+	if s.Name == "" && len(s.Matchers) < 1 {
+		return nil, p.errorf("empty selector")
+	}
+	return s, nil
+}
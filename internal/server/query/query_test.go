@@ -0,0 +1,235 @@
+package query
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nickzhog/devops-tool/pkg/metric"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{query: "HeapAlloc", want: "HeapAlloc"},
+		{query: `Heap*{host="node1", env!='dev',}`, want: `Heap*{host="node1", env!="dev"}`},
+		{query: `{__name__=~"Heap.*"}`, want: `{__name__=~"Heap.*"}`},
+		{query: "rate(PollCount)", want: "rate(PollCount[5m0s])"},
+		{query: `rate(PollCount{host!~"test.*"}[1m])`, want: `rate(PollCount{host!~"test.*"}[1m0s])`},
+		{query: "sum(Alloc)", want: "sum(Alloc)"},
+		{query: "sum by (host) (rate(PollCount[30s]))", want: "sum by (host) (rate(PollCount[30s]))"},
+		{query: "max(avg(Alloc) by (host, env))", want: "max(avg by (host, env) (Alloc))"},
+		{query: "count", want: "count"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			expr, err := Parse(tt.query)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, expr.String())
+		})
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := []string{
+		"",
+		"{}",
+		"sum(",
+		"sum(Alloc",
+		"sum by host (Alloc)",
+		"sum by (host) (Alloc) by (env)",
+		"rate(sum(Alloc))",
+		"rate(PollCount[1x])",
+		`Alloc{host="node1"`,
+		`Alloc{host=node1}`,
+		`Alloc{host~"node1"}`,
+		`Alloc{host=~"("}`,
+		`Alloc{host="node1}`,
+		"Alloc Alloc",
+		"Heap[",
+	}
+	for _, q := range tests {
+		t.Run(q, func(t *testing.T) {
+			_, err := Parse(q)
+			assert.ErrorIs(t, err, ErrSyntax)
+		})
+	}
+}
+
+type fakeSource struct {
+	metrics []metric.Metric
+	history map[string][]metric.Sample
+}
+
+func (s *fakeSource) FindAll(ctx context.Context, selector metric.Labels) ([]metric.Metric, error) {
+	return s.metrics, nil
+}
+
+func (s *fakeSource) FindHistory(ctx context.Context, name, mtype string, labels metric.Labels, from, to time.Time, step time.Duration) ([]metric.Sample, error) {
+	samples := make([]metric.Sample, 0)
+	for _, v := range s.history[metric.SeriesKey(name, labels)] {
+		if !v.Timestamp.Before(from) && !v.Timestamp.After(to) {
+			samples = append(samples, v)
+		}
+	}
+	return samples, nil
+}
+
+func withLabels(m metric.Metric, labels metric.Labels) metric.Metric {
+	m.Labels = labels
+	return m
+}
+
+func TestEvaluate(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 10, 0, 0, time.UTC)
+	node1 := metric.Labels{"host": "node1", "env": "prod"}
+	node2 := metric.Labels{"host": "node2", "env": "prod"}
+	node3 := metric.Labels{"host": "node3", "env": "dev"}
+
+	counterSamples := func(totals ...int64) []metric.Sample {
+		samples := make([]metric.Sample, 0, len(totals))
+		for i, total := range totals {
+			samples = append(samples, metric.NewSample(metric.NewCounterMetric("PollCount", total),
+				now.Add(time.Duration(i-len(totals)+1)*10*time.Second)))
+		}
+		return samples
+	}
+
+	src := &fakeSource{
+		metrics: []metric.Metric{
+			withLabels(metric.NewGaugeMetric("HeapAlloc", 100), node1),
+			withLabels(metric.NewGaugeMetric("HeapAlloc", 300), node2),
+			withLabels(metric.NewGaugeMetric("HeapAlloc", 50), node3),
+			withLabels(metric.NewGaugeMetric("HeapInuse", 10), node1),
+			withLabels(metric.NewCounterMetric("PollCount", 60), node1),
+			withLabels(metric.NewCounterMetric("PollCount", 25), node2),
+			withLabels(metric.NewCounterMetric("PollCount", 5), node3),
+		},
+		history: map[string][]metric.Sample{
+			metric.SeriesKey("PollCount", node1): counterSamples(0, 20, 40, 60),
+			metric.SeriesKey("PollCount", node2): counterSamples(30, 5, 25), // сброс counter
+			metric.SeriesKey("PollCount", node3): counterSamples(5),
+		},
+	}
+
+	tests := []struct {
+		query string
+		want  []Series
+	}{
+		{
+			query: `HeapAlloc{env="prod"}`,
+			want: []Series{
+				{Name: "HeapAlloc", Type: metric.GaugeType, Labels: node1, Value: 100},
+				{Name: "HeapAlloc", Type: metric.GaugeType, Labels: node2, Value: 300},
+			},
+		},
+		{
+			query: `Heap*{host="node1"}`,
+			want: []Series{
+				{Name: "HeapAlloc", Type: metric.GaugeType, Labels: node1, Value: 100},
+				{Name: "HeapInuse", Type: metric.GaugeType, Labels: node1, Value: 10},
+			},
+		},
+		{
+			query: `{__name__=~"Poll.*", __type__="counter", host=~"node[12]"}`,
+			want: []Series{
+				{Name: "PollCount", Type: metric.CounterType, Labels: node1, Value: 60},
+				{Name: "PollCount", Type: metric.CounterType, Labels: node2, Value: 25},
+			},
+		},
+		{query: "sum(HeapAlloc)", want: []Series{{Value: 450}}},
+		{query: "avg(HeapAlloc)", want: []Series{{Value: 150}}},
+		{query: "min(HeapAlloc)", want: []Series{{Value: 50}}},
+		{query: "max(HeapAlloc)", want: []Series{{Value: 300}}},
+		{query: "count(Heap*)", want: []Series{{Value: 4}}},
+		{
+			query: "sum by (env) (HeapAlloc)",
+			want: []Series{
+				{Labels: metric.Labels{"env": "dev"}, Value: 50},
+				{Labels: metric.Labels{"env": "prod"}, Value: 400},
+			},
+		},
+		{
+			query: "rate(PollCount[1m])",
+			want: []Series{
+				{Name: "PollCount", Labels: node1, Value: 2},
+				{Name: "PollCount", Labels: node2, Value: 1.25},
+			},
+		},
+		{query: "rate(PollCount[15s])", want: []Series{
+			{Name: "PollCount", Labels: node1, Value: 2},
+			{Name: "PollCount", Labels: node2, Value: 2},
+		}},
+		{query: "sum(rate(PollCount))", want: []Series{{Value: 3.25}}},
+		{query: "rate(HeapAlloc)", want: []Series{}},
+		{query: "sum(Unknown)", want: []Series{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			expr, err := Parse(tt.query)
+			require.NoError(t, err)
+
+			got, err := Evaluate(context.Background(), src, expr, now)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestEvaluateAt(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 10, 0, 0, time.UTC)
+	node1 := metric.Labels{"host": "node1"}
+	node2 := metric.Labels{"host": "node2"}
+
+	src := &fakeSource{
+		metrics: []metric.Metric{
+			withLabels(metric.NewGaugeMetric("HeapAlloc", 100), node1),
+			withLabels(metric.NewGaugeMetric("HeapAlloc", 300), node2),
+			withLabels(metric.NewCounterMetric("PollCount", 60), node1),
+		},
+		history: map[string][]metric.Sample{
+			metric.SeriesKey("HeapAlloc", node1): {
+				metric.NewSample(withLabels(metric.NewGaugeMetric("HeapAlloc", 10), node1), now.Add(-10*time.Minute)),
+				metric.NewSample(withLabels(metric.NewGaugeMetric("HeapAlloc", 20), node1), now.Add(-2*time.Minute)),
+				metric.NewSample(withLabels(metric.NewGaugeMetric("HeapAlloc", 100), node1), now),
+			},
+			// последнее значение старше InstantLookback
+			metric.SeriesKey("HeapAlloc", node2): {
+				metric.NewSample(withLabels(metric.NewGaugeMetric("HeapAlloc", 300), node2), now.Add(-10*time.Minute)),
+			},
+			metric.SeriesKey("PollCount", node1): {
+				metric.NewSample(withLabels(metric.NewCounterMetric("PollCount", 40), node1), now.Add(-time.Minute)),
+				metric.NewSample(withLabels(metric.NewCounterMetric("PollCount", 60), node1), now),
+			},
+		},
+	}
+
+	tests := []struct {
+		query string
+		want  []Series
+	}{
+		{
+			query: "HeapAlloc",
+			want:  []Series{{Name: "HeapAlloc", Type: metric.GaugeType, Labels: node1, Value: 20}},
+		},
+		{query: "sum(HeapAlloc)", want: []Series{{Value: 20}}},
+		{
+			query: "PollCount",
+			want:  []Series{{Name: "PollCount", Type: metric.CounterType, Labels: node1, Value: 40}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			expr, err := Parse(tt.query)
+			require.NoError(t, err)
+
+			got, err := EvaluateAt(context.Background(), src, expr, now.Add(-time.Minute))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
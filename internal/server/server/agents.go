@@ -0,0 +1,63 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+type agentIDKey struct{}
+
+// WithAgentID сохраняет в контексте идентификатор агента, от которого пришел запрос.
+func WithAgentID(ctx context.Context, agentID string) context.Context {
+	return context.WithValue(ctx, agentIDKey{}, agentID)
+}
+
+// AgentIDFromContext возвращает идентификатор агента из контекста или пустую строку.
+func AgentIDFromContext(ctx context.Context) string {
+	agentID, _ := ctx.Value(agentIDKey{}).(string)
+	return agentID
+}
+
+// AgentInfo - сведения об агенте, полученные из метрик хранилища.
+type AgentInfo struct {
+	ID       string    `json:"id"`
+	LastSeen time.Time `json:"last_seen"`
+	Metrics  int       `json:"metrics"`
+}
+
+// FindAgents возвращает известных серверу агентов: время последнего отчета
+// и количество метрик, для которых агент является последним источником.
+func (s *Server) FindAgents(ctx context.Context) ([]AgentInfo, error) {
+	metrics, err := s.storage.ExportMetrics(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	agents := make(map[string]*AgentInfo)
+	for _, m := range metrics {
+		if m.Source == "" {
+			continue
+		}
+
+		info, ok := agents[m.Source]
+		if !ok {
+			info = &AgentInfo{ID: m.Source}
+			agents[m.Source] = info
+		}
+		info.Metrics++
+		if m.LastSeen != nil && m.LastSeen.After(info.LastSeen) {
+			info.LastSeen = *m.LastSeen
+		}
+	}
+
+	result := make([]AgentInfo, 0, len(agents))
+	for _, info := range agents {
+		result = append(result, *info)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+
+	return result, nil
+}
@@ -3,12 +3,15 @@ package grpc
 import (
 	"context"
 	"errors"
+	"io"
+	"time"
 
 	pb "github.com/nickzhog/devops-tool/internal/proto"
 	"github.com/nickzhog/devops-tool/internal/server/server"
 	"github.com/nickzhog/devops-tool/pkg/metric"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 var _ pb.MetricsServer = (*MetricServer)(nil)
@@ -29,34 +32,88 @@ func (s *MetricServer) SetMetrics(ctx context.Context, in *pb.SetMetricsRequest)
 	metrics := make([]metric.Metric, 0, len(in.Metrics))
 
 	for _, pbmetric := range in.Metrics {
-		var m metric.Metric
+		metrics = append(metrics, fromProto(pbmetric))
+	}
 
-		switch pbmetric.Mtype.String() {
-		case metric.GaugeType:
-			m = metric.NewGaugeMetric(pbmetric.Id, pbmetric.Value)
+	err := s.srv.UpsertMany(ctx, metrics)
+	if err != nil {
+		if metric.IsInvalid(err) {
+			return nil, status.Errorf(codes.InvalidArgument, err.Error())
+		}
+		return nil, status.Errorf(codes.Unknown, err.Error())
+	}
 
-		case metric.CounterType:
-			m = metric.NewCounterMetric(pbmetric.Id, pbmetric.Delta)
+	return &pb.SetMetricsResponse{Ok: true}, nil
+}
+
+// StreamMetrics принимает пакеты метрик из потока и подтверждает каждый пакет в порядке получения.
+// Ошибка сохранения пакета возвращается в подтверждении и не прерывает поток.
+// Поток, который клиент держит открытым, разрывается при остановке сервера по истечении stopTimeout.
+func (s *MetricServer) StreamMetrics(stream pb.Metrics_StreamMetricsServer) error {
+	for {
+		batch, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
 		}
 
-		m.Hash = pbmetric.Hash
+		metrics := make([]metric.Metric, 0, len(batch.Metrics))
+		for _, pbmetric := range batch.Metrics {
+			metrics = append(metrics, fromProto(pbmetric))
+		}
+
+		ack := &pb.MetricsAck{Seq: batch.Seq, Ok: true}
+		if err = s.srv.UpsertMany(stream.Context(), metrics); err != nil {
+			s.srv.Logger.Errorf("stream batch %d: %s", batch.Seq, err.Error())
+			ack.Ok = false
+			ack.Error = err.Error()
+		}
 
-		metrics = append(metrics, m)
+		if err = stream.Send(ack); err != nil {
+			return err
+		}
 	}
+}
 
-	err := s.srv.UpsertMany(ctx, metrics)
-	if err != nil {
-		return nil, status.Errorf(codes.Unknown, err.Error())
+// Watch отправляет в поток значения метрик, подходящих под запрос, после каждого их обновления.
+// Пустые списки ids и mtypes означают любые имена и типы.
+func (s *MetricServer) Watch(in *pb.WatchRequest, stream pb.Metrics_WatchServer) error {
+	filter := server.WatchFilter{
+		Names:  in.Ids,
+		Labels: in.Labels,
+	}
+	for _, t := range in.Mtypes {
+		filter.Types = append(filter.Types, t.String())
 	}
 
-	return &pb.SetMetricsResponse{Ok: true}, nil
+	sub := s.srv.Watch(filter)
+	defer sub.Close()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case m, ok := <-sub.C():
+			if !ok {
+				if errors.Is(sub.Err(), server.ErrWatchClosed) {
+					return status.Error(codes.Unavailable, sub.Err().Error())
+				}
+				return status.Error(codes.ResourceExhausted, sub.Err().Error())
+			}
+			if err := stream.Send(toProto(m)); err != nil {
+				return err
+			}
+		}
+	}
 }
 
 func (s *MetricServer) GetMetrics(ctx context.Context, in *pb.GetMetricsRequest) (*pb.GetMetricsResponse, error) {
 	var response pb.GetMetricsResponse
 
 	for _, pbMetric := range in.Request {
-		m, err := s.srv.FindMetric(ctx, pbMetric.Id, pbMetric.Mtype.String())
+		m, err := s.srv.FindMetric(ctx, pbMetric.Id, pbMetric.Mtype.String(), pbMetric.Labels)
 		if err != nil {
 			if errors.Is(err, metric.ErrNoResult) {
 				return nil, status.Errorf(codes.NotFound, metric.ErrNoResult.Error())
@@ -64,13 +121,47 @@ func (s *MetricServer) GetMetrics(ctx context.Context, in *pb.GetMetricsRequest)
 			return nil, status.Errorf(codes.Unknown, err.Error())
 		}
 
-		response.Metric = append(response.Metric,
-			&pb.Metric{
-				Id:    m.ID,
-				Mtype: pb.MType(pb.MType_value[m.MType]),
-				Value: *m.Value,
-				Delta: *m.Delta,
-			})
+		response.Metric = append(response.Metric, toProto(m))
+	}
+
+	return &response, nil
+}
+
+func (s *MetricServer) GetHistory(ctx context.Context, in *pb.GetHistoryRequest) (*pb.GetHistoryResponse, error) {
+	to := time.Now()
+	if in.To != nil {
+		to = in.To.AsTime()
+	}
+	from := to.Add(-time.Hour)
+	if in.From != nil {
+		from = in.From.AsTime()
+	}
+	var step time.Duration
+	if in.Step != nil {
+		step = in.Step.AsDuration()
+	}
+
+	samples, err := s.srv.FindHistory(ctx, in.Id, in.Mtype.String(), in.Labels, from, to, step)
+	if err != nil {
+		return nil, status.Errorf(codes.Unknown, err.Error())
+	}
+
+	response := pb.GetHistoryResponse{
+		Samples: make([]*pb.Sample, 0, len(samples)),
+	}
+	for _, v := range samples {
+		sample := &pb.Sample{Timestamp: timestamppb.New(v.Timestamp)}
+		if v.Value != nil {
+			sample.Value = *v.Value
+		}
+		if v.Delta != nil {
+			sample.Delta = *v.Delta
+		}
+		sample.Histogram = histogramToProto(v.Histogram)
+		sample.Summary = summaryToProto(v.Summary)
+		sample.Rollup = rollupToProto(v.Rollup)
+
+		response.Samples = append(response.Samples, sample)
 	}
 
 	return &response, nil
@@ -0,0 +1,132 @@
+package grpc
+
+import (
+	pb "github.com/nickzhog/devops-tool/internal/proto"
+	"github.com/nickzhog/devops-tool/pkg/metric"
+)
+
+func fromProto(pbmetric *pb.Metric) metric.Metric {
+	var m metric.Metric
+
+	switch pbmetric.Mtype.String() {
+	case metric.GaugeType:
+		m = metric.NewGaugeMetric(pbmetric.Id, pbmetric.Value)
+
+	case metric.CounterType:
+		m = metric.NewCounterMetric(pbmetric.Id, pbmetric.Delta)
+
+	case metric.HistogramType:
+		m = metric.Metric{ID: pbmetric.Id, MType: metric.HistogramType,
+			Histogram: histogramFromProto(pbmetric.Histogram)}
+
+	case metric.SummaryType:
+		m = metric.Metric{ID: pbmetric.Id, MType: metric.SummaryType,
+			Summary: summaryFromProto(pbmetric.Summary)}
+	}
+
+	m.Hash = pbmetric.Hash
+	m.Absolute = pbmetric.Absolute
+	if len(pbmetric.Labels) > 0 {
+		m.Labels = pbmetric.Labels
+	}
+
+	return m
+}
+
+func toProto(m metric.Metric) *pb.Metric {
+	pbmetric := &pb.Metric{
+		Id:       m.ID,
+		Mtype:    pb.MType(pb.MType_value[m.MType]),
+		Hash:     m.Hash,
+		Labels:   m.Labels,
+		Absolute: m.Absolute,
+	}
+	if m.Value != nil {
+		pbmetric.Value = *m.Value
+	}
+	if m.Delta != nil {
+		pbmetric.Delta = *m.Delta
+	}
+	pbmetric.Histogram = histogramToProto(m.Histogram)
+	pbmetric.Summary = summaryToProto(m.Summary)
+
+	return pbmetric
+}
+
+func histogramFromProto(h *pb.Histogram) *metric.Histogram {
+	if h == nil {
+		return nil
+	}
+
+	return &metric.Histogram{
+		Buckets: h.Buckets,
+		Counts:  h.Counts,
+		Count:   h.Count,
+		Sum:     h.Sum,
+	}
+}
+
+func histogramToProto(h *metric.Histogram) *pb.Histogram {
+	if h == nil {
+		return nil
+	}
+
+	return &pb.Histogram{
+		Buckets: h.Buckets,
+		Counts:  h.Counts,
+		Count:   h.Count,
+		Sum:     h.Sum,
+	}
+}
+
+func summaryFromProto(s *pb.Summary) *metric.Summary {
+	if s == nil {
+		return nil
+	}
+
+	summary := &metric.Summary{
+		Quantiles: make([]metric.Quantile, 0, len(s.Quantiles)),
+		Count:     s.Count,
+		Sum:       s.Sum,
+	}
+	for _, q := range s.Quantiles {
+		summary.Quantiles = append(summary.Quantiles, metric.Quantile{Quantile: q.Quantile, Value: q.Value})
+	}
+
+	return summary
+}
+
+func summaryToProto(s *metric.Summary) *pb.Summary {
+	if s == nil {
+		return nil
+	}
+
+	summary := &pb.Summary{
+		Quantiles: make([]*pb.Quantile, 0, len(s.Quantiles)),
+		Count:     s.Count,
+		Sum:       s.Sum,
+	}
+	for _, q := range s.Quantiles {
+		summary.Quantiles = append(summary.Quantiles, &pb.Quantile{Quantile: q.Quantile, Value: q.Value})
+	}
+
+	return summary
+}
+
+func rollupToProto(r *metric.Rollup) *pb.Rollup {
+	if r == nil {
+		return nil
+	}
+
+	rollup := &pb.Rollup{Seconds: r.Seconds, Count: r.Count}
+	if r.Min != nil {
+		rollup.Min, rollup.Max, rollup.Avg = *r.Min, *r.Max, *r.Avg
+	}
+	if r.Sum != nil {
+		rollup.Sum = *r.Sum
+	}
+	if r.Rate != nil {
+		rollup.Rate = *r.Rate
+	}
+	return rollup
+}
@@ -2,16 +2,49 @@ package grpc
 
 import (
 	"context"
-	"net"
+	"errors"
+	"path"
 
+	"github.com/nickzhog/devops-tool/internal/server/auth"
+	"github.com/nickzhog/devops-tool/internal/server/server"
+	"github.com/nickzhog/devops-tool/pkg/ipfilter"
 	"github.com/nickzhog/devops-tool/pkg/logging"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 )
 
-func NewIPinterceptor(trustedSubnet *net.IPNet, logger *logging.Logger) func(
+// checkIP проверяет, что вызов пришел из разрешенной подсети.
+// Адрес клиента определяется по адресу соединения, метаданные x-forwarded-for
+// и x-real-ip учитываются, только если соединение установлено доверенным прокси.
+func checkIP(ctx context.Context, filter *ipfilter.Filter, logger *logging.Logger) error {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return status.Error(codes.Unauthenticated, "missing client address")
+	}
+
+	var forwardedFor []string
+	var realIP string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		forwardedFor = md.Get("x-forwarded-for")
+		if ips := md.Get("x-real-ip"); len(ips) > 0 {
+			realIP = ips[0]
+		}
+	}
+
+	ip := filter.ClientIP(ipfilter.HostIP(p.Addr.String()), forwardedFor, realIP)
+	if filter.Allowed(ip) {
+		return nil
+	}
+
+	logger.Tracef("wrong ip: %s", ip)
+	return status.Error(codes.PermissionDenied, "client IP is not allowed")
+}
+
+// NewIPinterceptor пропускает вызовы только из разрешенных подсетей.
+func NewIPinterceptor(filter *ipfilter.Filter, logger *logging.Logger) func(
 	ctx context.Context,
 	req interface{},
 	info *grpc.UnaryServerInfo,
@@ -23,26 +56,132 @@ func NewIPinterceptor(trustedSubnet *net.IPNet, logger *logging.Logger) func(
 		info *grpc.UnaryServerInfo,
 		handler grpc.UnaryHandler) (interface{}, error) {
 
-		md, ok := metadata.FromIncomingContext(ctx)
-		if !ok {
-			return nil, status.Error(codes.InvalidArgument, "missing metadata")
+		if err := checkIP(ctx, filter, logger); err != nil {
+			return nil, err
 		}
+		return handler(ctx, req)
+	}
+}
 
-		ips, ok := md["x-real-ip"]
-		if !ok || len(ips) == 0 {
-			return nil, status.Error(codes.Unauthenticated, "missing client IP")
+// NewIPStreamInterceptor - NewIPinterceptor для потоковых вызовов.
+func NewIPStreamInterceptor(filter *ipfilter.Filter, logger *logging.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := checkIP(ss.Context(), filter, logger); err != nil {
+			return err
 		}
+		return handler(srv, ss)
+	}
+}
 
-		ip := net.ParseIP(ips[0])
-		if ip == nil {
-			return nil, status.Error(codes.InvalidArgument, "invalid client IP")
+// withAgentID сохраняет в контексте идентификатор агента из метаданных x-agent-id.
+func withAgentID(ctx context.Context) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if ok {
+		if ids := md.Get("x-agent-id"); len(ids) > 0 && ids[0] != "" {
+			ctx = server.WithAgentID(ctx, ids[0])
 		}
+	}
+	return ctx
+}
+
+// AgentIDInterceptor сохраняет в контексте идентификатор агента из метаданных x-agent-id.
+func AgentIDInterceptor(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+
+	return handler(withAgentID(ctx), req)
+}
+
+// AgentIDStreamInterceptor - AgentIDInterceptor для потоковых вызовов.
+func AgentIDStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &serverStream{ServerStream: ss, ctx: withAgentID(ss.Context())})
+}
+
+// serverStream - поток с контекстом, дополненным перехватчиками.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// methodPermissions - права, необходимые для вызова методов. Остальные методы требуют права admin.
+var methodPermissions = map[string]auth.Permission{
+	"SetMetrics": auth.PermWrite,
+	"GetMetrics": auth.PermRead,
+	"GetHistory": auth.PermRead,
+
+	"StreamMetrics": auth.PermWrite,
+	"Watch":         auth.PermRead,
+}
+
+// methodPermission возвращает право, необходимое для вызова метода fullMethod вида /package.Service/Method.
+func methodPermission(fullMethod string) auth.Permission {
+	if perm, ok := methodPermissions[path.Base(fullMethod)]; ok {
+		return perm
+	}
+	return auth.PermAdmin
+}
+
+// authenticate проверяет токен из метаданных authorization: Bearer <token>.
+func authenticate(ctx context.Context, keys auth.KeyStore, fullMethod string, logger *logging.Logger) (context.Context, error) {
+	var header string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			header = values[0]
+		}
+	}
+
+	key, err := auth.Authenticate(ctx, keys, header, methodPermission(fullMethod))
+	switch {
+	case err == nil:
+	case errors.Is(err, auth.ErrForbidden):
+		logger.Tracef("agent %q: %s for %s", key.AgentID, err.Error(), fullMethod)
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, auth.ErrNoToken), errors.Is(err, auth.ErrUnknownToken):
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	default:
+		logger.Errorf("auth: %s", err.Error())
+		return nil, status.Error(codes.Internal, "auth failed")
+	}
+
+	ctx = auth.WithKey(ctx, key)
+	if key.AgentID != "" {
+		ctx = server.WithAgentID(ctx, key.AgentID)
+	}
+	return ctx, nil
+}
+
+// NewAuthInterceptor проверяет токен и права ключа для вызова метода.
+// Идентификатор агента, которому выдан ключ, заменяет переданный в x-agent-id.
+func NewAuthInterceptor(keys auth.KeyStore, logger *logging.Logger) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler) (interface{}, error) {
+
+		ctx, err := authenticate(ctx, keys, info.FullMethod, logger)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
 
-		if trustedSubnet.Contains(ip) {
-			return handler(ctx, req)
+// NewAuthStreamInterceptor - NewAuthInterceptor для потоковых вызовов.
+func NewAuthStreamInterceptor(keys auth.KeyStore, logger *logging.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticate(ss.Context(), keys, info.FullMethod, logger)
+		if err != nil {
+			return err
 		}
 
-		logger.Tracef("wrong ip: %s", ip)
-		return nil, status.Error(codes.PermissionDenied, "client IP is not allowed")
+		return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
 	}
 }
@@ -3,35 +3,80 @@ package grpc
 import (
 	"context"
 	"net"
+	"time"
 
 	pb "github.com/nickzhog/devops-tool/internal/proto"
 	"github.com/nickzhog/devops-tool/internal/server/config"
 	"github.com/nickzhog/devops-tool/internal/server/server"
+	"github.com/nickzhog/devops-tool/pkg/encryption"
+	"github.com/nickzhog/devops-tool/pkg/ipfilter"
+	"github.com/nickzhog/devops-tool/pkg/tlsconfig"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
+// stopTimeout - сколько ждать завершения открытых вызовов при остановке сервера.
+const stopTimeout = 5 * time.Second
+
 func Serve(ctx context.Context, srv server.Server, cfg *config.Config) {
-	gRPCsrv := grpc.NewServer()
+	var interceptors []grpc.UnaryServerInterceptor
+	var streamInterceptors []grpc.StreamServerInterceptor
 
 	if cfg.Settings.TrustedSubnet != "" {
-		_, ipNet, err := net.ParseCIDR(cfg.Settings.TrustedSubnet)
+		filter, err := ipfilter.New(cfg.Settings.TrustedSubnet, cfg.Settings.TrustedProxies)
 		if err != nil {
 			srv.Logger.Fatal(err)
 		}
-		gRPCsrv = grpc.NewServer(
-			grpc.UnaryInterceptor(
-				NewIPinterceptor(ipNet, srv.Logger),
-			),
-		)
+		interceptors = append(interceptors, NewIPinterceptor(filter, srv.Logger))
+		streamInterceptors = append(streamInterceptors, NewIPStreamInterceptor(filter, srv.Logger))
 	}
 
-	pb.RegisterMetricsServer(gRPCsrv, NewMetricServer(srv))
-	go func() {
-		listen, err := net.Listen("tcp", cfg.Settings.AddressGRPC)
+	interceptors = append(interceptors, AgentIDInterceptor)
+	streamInterceptors = append(streamInterceptors, AgentIDStreamInterceptor)
+
+	if srv.KeyStore != nil {
+		interceptors = append(interceptors, NewAuthInterceptor(srv.KeyStore, srv.Logger))
+		streamInterceptors = append(streamInterceptors, NewAuthStreamInterceptor(srv.KeyStore, srv.Logger))
+	}
+
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(interceptors...),
+		grpc.ChainStreamInterceptor(streamInterceptors...),
+	}
+
+	if cfg.Settings.GRPCTLSCert != "" {
+		reloader, err := tlsconfig.NewReloader(cfg.Settings.GRPCTLSCert, cfg.Settings.GRPCTLSKey, cfg.Settings.GRPCTLSClientCA)
 		if err != nil {
 			srv.Logger.Fatal(err)
 		}
-		if err = gRPCsrv.Serve(listen); err != nil && err != grpc.ErrServerStopped {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(reloader.Config())))
+		go reloader.ReloadOnSignal(ctx, srv.Logger)
+	}
+
+	if cfg.Settings.CryptoKey != "" {
+		key, err := encryption.NewPrivateKey(cfg.Settings.CryptoKey)
+		if err != nil {
+			srv.Logger.Fatal(err)
+		}
+		opts = append(opts, grpc.ForceServerCodec(encryption.NewServerCodec(key)))
+	}
+
+	gRPCsrv := grpc.NewServer(opts...)
+	pb.RegisterMetricsServer(gRPCsrv, NewMetricServer(srv))
+
+	listen, err := net.Listen("tcp", cfg.Settings.AddressGRPC)
+	if err != nil {
+		srv.Logger.Fatal(err)
+	}
+
+	serve(ctx, srv, gRPCsrv, listen, stopTimeout)
+}
+
+// serve обслуживает соединения listen до отмены ctx. При остановке подписки Watch закрываются,
+// а открытые вызовы получают timeout на завершение, после чего соединения разрываются.
+func serve(ctx context.Context, srv server.Server, gRPCsrv *grpc.Server, listen net.Listener, timeout time.Duration) {
+	go func() {
+		if err := gRPCsrv.Serve(listen); err != nil && err != grpc.ErrServerStopped {
 			srv.Logger.Fatalf("grpc listen:%+s\n", err)
 		}
 	}()
@@ -40,7 +85,21 @@ func Serve(ctx context.Context, srv server.Server, cfg *config.Config) {
 
 	<-ctx.Done()
 
-	gRPCsrv.GracefulStop()
+	srv.CloseWatchers()
+
+	stopped := make(chan struct{})
+	go func() {
+		gRPCsrv.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		srv.Logger.Errorf("grpc server: open calls not finished in %s, closing connections", timeout)
+		gRPCsrv.Stop()
+		<-stopped
+	}
 
 	srv.Logger.Tracef("grpc server stopped")
 }
@@ -0,0 +1,123 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	pb "github.com/nickzhog/devops-tool/internal/proto"
+	"github.com/nickzhog/devops-tool/internal/server/config"
+	"github.com/nickzhog/devops-tool/internal/server/server"
+	"github.com/nickzhog/devops-tool/internal/server/service/cache"
+	"github.com/nickzhog/devops-tool/pkg/logging"
+	"github.com/nickzhog/devops-tool/pkg/metric"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// startServer запускает serve на bufconn и возвращает клиента и канал, закрываемый после возврата serve.
+func startServer(t *testing.T, ctx context.Context, srv *server.Server, timeout time.Duration) (pb.MetricsClient, <-chan struct{}) {
+	t.Helper()
+
+	listener := bufconn.Listen(1 << 20)
+	gRPCsrv := grpc.NewServer()
+	pb.RegisterMetricsServer(gRPCsrv, NewMetricServer(*srv))
+
+	done := make(chan struct{})
+	go func() {
+		serve(ctx, *srv, gRPCsrv, listener, timeout)
+		close(done)
+	}()
+
+	conn, err := grpc.Dial("bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewMetricsClient(conn), done
+}
+
+func waitStopped(t *testing.T, done <-chan struct{}, timeout time.Duration) {
+	t.Helper()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		t.Fatal("server did not stop")
+	}
+}
+
+func TestServe_StopsWithWatch(t *testing.T) {
+	srv := server.NewServer(logging.GetLogger(), &config.Config{}, cache.NewMemStorage(0))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client, done := startServer(t, ctx, srv, time.Minute)
+
+	stream, err := client.Watch(context.Background(), &pb.WatchRequest{})
+	require.NoError(t, err)
+
+	received := make(chan error, 1)
+	go func() {
+		for {
+			_, err := stream.Recv()
+			received <- err
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	// подписка создается асинхронно, поэтому метрика записывается, пока не придет в поток
+	require.Eventually(t, func() bool {
+		require.NoError(t, srv.UpsertMetric(context.Background(), metric.NewGaugeMetric("Alloc", 1)))
+		select {
+		case err := <-received:
+			return err == nil
+		case <-time.After(10 * time.Millisecond):
+			return false
+		}
+	}, 5*time.Second, time.Millisecond)
+
+	// остановка не ждет stopTimeout: подписка закрывается и поток завершается
+	cancel()
+	waitStopped(t, done, 5*time.Second)
+
+	for err := range received {
+		if err != nil {
+			assert.Equal(t, codes.Unavailable, status.Code(err))
+			break
+		}
+	}
+}
+
+func TestServe_StopsWithOpenStream(t *testing.T) {
+	srv := server.NewServer(logging.GetLogger(), &config.Config{}, cache.NewMemStorage(0))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client, done := startServer(t, ctx, srv, 100*time.Millisecond)
+
+	stream, err := client.StreamMetrics(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pb.MetricsBatch{Seq: 1, Metrics: []*pb.Metric{{Id: "PollCount", Mtype: pb.MType_counter, Delta: 1}}}))
+	ack, err := stream.Recv()
+	require.NoError(t, err)
+	assert.True(t, ack.Ok)
+
+	// клиент не закрывает поток, остановка разрывает соединение по истечении timeout
+	cancel()
+	waitStopped(t, done, 5*time.Second)
+
+	_, err = stream.Recv()
+	assert.Error(t, err)
+}
@@ -13,6 +13,8 @@ import (
 	"time"
 
 	"github.com/go-chi/chi"
+	"github.com/nickzhog/devops-tool/internal/server/alert"
+	"github.com/nickzhog/devops-tool/internal/server/query"
 	"github.com/nickzhog/devops-tool/internal/server/server"
 	"github.com/nickzhog/devops-tool/pkg/metric"
 )
@@ -49,6 +51,20 @@ var templ = template.Must(template.New("index").Parse(
         <p> {{.Key}} - {{.Value}}</p>
     <br><br>
     {{end}}
+    <br><hr><br>
+    <h1>Histogram:</h1>
+    <br><br>
+    {{range .HistogramValues}}
+        <p> {{.Key}} - {{.Value}}</p>
+    <br><br>
+    {{end}}
+    <br><hr><br>
+    <h1>Summary:</h1>
+    <br><br>
+    {{range .SummaryValues}}
+        <p> {{.Key}} - {{.Value}}</p>
+    <br><br>
+    {{end}}
     <br>
 </body>	
 	`,
@@ -65,10 +81,19 @@ func (h *handler) PingHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(nil)
 }
 
-// IndexHandler - главная страница, отображает все доступные метрики и их значения
+// IndexHandler - главная страница, отображает все доступные метрики и их значения.
+// Параметр labels отбирает метрики, содержащие указанные labels.
+//
+// Пример URL-запроса:
+// /?labels=host=node1,env=prod
 func (h *handler) IndexHandler(w http.ResponseWriter, r *http.Request) {
+	selector, err := metric.ParseLabels(r.URL.Query().Get("labels"))
+	if err != nil {
+		ErrBadRequest(err).Render(w, r)
+		return
+	}
 
-	metrics, err := h.srv.FindAll(r.Context())
+	metrics, err := h.srv.FindAll(r.Context(), selector)
 	if err != nil {
 		ErrInternalError(err).Render(w, r)
 		return
@@ -76,12 +101,18 @@ func (h *handler) IndexHandler(w http.ResponseWriter, r *http.Request) {
 
 	gaugeData := make([]ForTemplate, 0)
 	counterData := make([]ForTemplate, 0)
+	histogramData := make([]ForTemplate, 0)
+	summaryData := make([]ForTemplate, 0)
 	for _, v := range metrics {
 		switch v.MType {
 		case metric.CounterType:
-			counterData = append(counterData, ForTemplate{Key: v.ID, Value: fmt.Sprintf("%v", *v.Delta)})
+			counterData = append(counterData, ForTemplate{Key: v.SeriesKey(), Value: fmt.Sprintf("%v", *v.Delta)})
 		case metric.GaugeType:
-			gaugeData = append(gaugeData, ForTemplate{Key: v.ID, Value: fmt.Sprintf("%f", *v.Value)})
+			gaugeData = append(gaugeData, ForTemplate{Key: v.SeriesKey(), Value: fmt.Sprintf("%f", *v.Value)})
+		case metric.HistogramType:
+			histogramData = append(histogramData, ForTemplate{Key: v.SeriesKey(), Value: v.Histogram.String()})
+		case metric.SummaryType:
+			summaryData = append(summaryData, ForTemplate{Key: v.SeriesKey(), Value: v.Summary.String()})
 		}
 	}
 	sort.Slice(gaugeData, func(i, j int) bool {
@@ -90,11 +121,19 @@ func (h *handler) IndexHandler(w http.ResponseWriter, r *http.Request) {
 	sort.Slice(counterData, func(i, j int) bool {
 		return counterData[i].Key < counterData[j].Key
 	})
+	sort.Slice(histogramData, func(i, j int) bool {
+		return histogramData[i].Key < histogramData[j].Key
+	})
+	sort.Slice(summaryData, func(i, j int) bool {
+		return summaryData[i].Key < summaryData[j].Key
+	})
 
 	m := make(map[string]interface{})
 
 	m["GaugeValues"] = gaugeData
 	m["CounterValues"] = counterData
+	m["HistogramValues"] = histogramData
+	m["SummaryValues"] = summaryData
 
 	w.Header().Set("Content-Type", "text/html")
 	templ.Execute(w, m)
@@ -107,7 +146,8 @@ func (h *handler) IndexHandler(w http.ResponseWriter, r *http.Request) {
 //
 //	{
 //		"id": "good_metric",
-//		"type": "gauge"
+//		"type": "gauge",
+//		"labels": {"host": "node1"}
 //	}
 func (h *handler) SelectFromBody(w http.ResponseWriter, r *http.Request) {
 	body, err := io.ReadAll(r.Body)
@@ -122,7 +162,7 @@ func (h *handler) SelectFromBody(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	metricElem, err = h.srv.FindMetric(r.Context(), metricElem.ID, metricElem.MType)
+	metricElem, err = h.srv.FindMetric(r.Context(), metricElem.ID, metricElem.MType, metricElem.Labels)
 	if err != nil {
 		if err == metric.ErrNoResult {
 			ErrNotFound(err).Render(w, r)
@@ -162,11 +202,15 @@ func (h *handler) UpdateFromBody(w http.ResponseWriter, r *http.Request) {
 
 	err = h.srv.UpsertMetric(r.Context(), metricElem)
 	if err != nil {
+		if metric.IsInvalid(err) {
+			ErrBadRequest(err).Render(w, r)
+			return
+		}
 		ErrInternalError(err).Render(w, r)
 		return
 	}
 
-	mcurrent, err := h.srv.FindMetric(r.Context(), metricElem.ID, metricElem.MType)
+	mcurrent, err := h.srv.FindMetric(r.Context(), metricElem.ID, metricElem.MType, metricElem.Labels)
 	if err != nil {
 		ErrInternalError(err).Render(w, r)
 		return
@@ -178,12 +222,14 @@ func (h *handler) UpdateFromBody(w http.ResponseWriter, r *http.Request) {
 
 // Обработчик SelectFromURL используется для поиска метрики в хранилище
 // на основе данных, переданных в URL-параметрах.
+// Labels метрики передаются в параметре labels.
+// Значения histogram и summary возвращаются в формате JSON.
 //
 // Пример URL-запроса:
-// /value/gauge/good_metric
+// /value/gauge/good_metric?labels=host=node1
 func (h *handler) SelectFromURL(w http.ResponseWriter, r *http.Request) {
 	metricType := chi.URLParam(r, "metric_type")
-	if metricType != metric.CounterType && metricType != metric.GaugeType {
+	if !metric.IsValidType(metricType) {
 		ErrBadRequest(errors.New("metric_type is missing in parameters")).Render(w, r)
 		return
 	}
@@ -194,7 +240,13 @@ func (h *handler) SelectFromURL(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	metricElem, err := h.srv.FindMetric(r.Context(), metricName, metricType)
+	labels, err := metric.ParseLabels(r.URL.Query().Get("labels"))
+	if err != nil {
+		ErrBadRequest(err).Render(w, r)
+		return
+	}
+
+	metricElem, err := h.srv.FindMetric(r.Context(), metricName, metricType, labels)
 	if err != nil {
 		if err == metric.ErrNoResult {
 			ErrNotFound(err).Render(w, r)
@@ -210,6 +262,10 @@ func (h *handler) SelectFromURL(w http.ResponseWriter, r *http.Request) {
 		v = *metricElem.Delta
 	case metric.GaugeType:
 		v = *metricElem.Value
+	case metric.HistogramType, metric.SummaryType:
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(metricElem.Marshal())
+		return
 	}
 
 	w.Write([]byte(fmt.Sprintf("%v", v)))
@@ -217,9 +273,11 @@ func (h *handler) SelectFromURL(w http.ResponseWriter, r *http.Request) {
 
 // Обработчик UpdateFromURL используется для обновления/создания метрики в хранилище
 // на основе данных, переданных в URL-параметрах.
+// Labels метрики передаются в параметре labels.
+// Параметр absolute=true для counter заменяет сохраненное значение вместо прибавления.
 //
 // Пример URL-запроса:
-// /value/gauge/good_metric/10.5
+// /value/gauge/good_metric/10.5?labels=host=node1
 func (h *handler) UpdateFromURL(w http.ResponseWriter, r *http.Request) {
 	metricType := chi.URLParam(r, "metric_type")
 	metricName := chi.URLParam(r, "name")
@@ -228,6 +286,12 @@ func (h *handler) UpdateFromURL(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	labels, err := metric.ParseLabels(r.URL.Query().Get("labels"))
+	if err != nil {
+		ErrBadRequest(err).Render(w, r)
+		return
+	}
+
 	metricValue := chi.URLParam(r, "value")
 
 	var (
@@ -251,9 +315,13 @@ func (h *handler) UpdateFromURL(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		metricElem = metric.NewCounterMetric(metricName, value)
+		metricElem.Absolute = r.URL.Query().Get("absolute") == "true"
 		valueString = fmt.Sprintf("%v", value)
+		if metricElem.Absolute {
+			break
+		}
 
-		actualMetric, err := h.srv.FindMetric(r.Context(), metricName, metricType)
+		actualMetric, err := h.srv.FindMetric(r.Context(), metricName, metricType, labels)
 		if err != nil && !errors.Is(err, metric.ErrNoResult) {
 			ErrInternalError(err).Render(w, r)
 			return
@@ -264,9 +332,14 @@ func (h *handler) UpdateFromURL(w http.ResponseWriter, r *http.Request) {
 		ErrUnprocessableEntityRequest(errors.New("wrong metric type")).Render(w, r)
 		return
 	}
+	metricElem.Labels = labels
 
-	err := h.srv.UpsertMetric(r.Context(), metricElem)
+	err = h.srv.UpsertMetric(r.Context(), metricElem)
 	if err != nil {
+		if metric.IsInvalid(err) {
+			ErrBadRequest(err).Render(w, r)
+			return
+		}
 		ErrInternalError(err).Render(w, r)
 		return
 	}
@@ -307,9 +380,190 @@ func (h *handler) UpdateMany(w http.ResponseWriter, r *http.Request) {
 
 	err = h.srv.UpsertMany(r.Context(), metrics)
 	if err != nil {
+		if metric.IsInvalid(err) {
+			ErrBadRequest(err).Render(w, r)
+			return
+		}
 		ErrInternalError(err).Render(w, r)
 		return
 	}
 
 	w.Write(nil)
 }
+
+// Обработчик SelectHistory возвращает историю значений метрики за период.
+// Параметры from и to принимают время в формате RFC3339 или unix-время,
+// по умолчанию возвращается история за последний час.
+// Параметр step прореживает историю, оставляя последнее значение в каждом интервале,
+// labels метрики передаются в параметре labels.
+//
+// Пример URL-запроса:
+// /history/gauge/good_metric?from=2023-01-01T10:00:00Z&to=2023-01-01T11:00:00Z&step=1m&labels=host=node1
+func (h *handler) SelectHistory(w http.ResponseWriter, r *http.Request) {
+	metricType := chi.URLParam(r, "metric_type")
+	if !metric.IsValidType(metricType) {
+		ErrBadRequest(errors.New("metric_type is missing in parameters")).Render(w, r)
+		return
+	}
+
+	metricName := chi.URLParam(r, "name")
+	if len(metricName) < 1 {
+		ErrBadRequest(errors.New("name is missing in parameters")).Render(w, r)
+		return
+	}
+
+	query := r.URL.Query()
+	to, err := parseTime(query.Get("to"), time.Now())
+	if err != nil {
+		ErrBadRequest(fmt.Errorf("wrong to: %w", err)).Render(w, r)
+		return
+	}
+	from, err := parseTime(query.Get("from"), to.Add(-time.Hour))
+	if err != nil {
+		ErrBadRequest(fmt.Errorf("wrong from: %w", err)).Render(w, r)
+		return
+	}
+	step, err := parseDuration(query.Get("step"))
+	if err != nil {
+		ErrBadRequest(fmt.Errorf("wrong step: %w", err)).Render(w, r)
+		return
+	}
+	labels, err := metric.ParseLabels(query.Get("labels"))
+	if err != nil {
+		ErrBadRequest(err).Render(w, r)
+		return
+	}
+
+	samples, err := h.srv.FindHistory(r.Context(), metricName, metricType, labels, from, to, step)
+	if err != nil {
+		ErrInternalError(err).Render(w, r)
+		return
+	}
+
+	data, err := json.Marshal(samples)
+	if err != nil {
+		ErrInternalError(err).Render(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// Обработчик QueryHandler вычисляет запрос q на языке запросов на момент time (по умолчанию - текущий).
+//
+// Примеры запросов:
+//
+//	HeapAlloc{host="node1"}
+//	sum by (host) (rate(PollCount[1m]))
+//	max({__name__=~"Heap.*", env!="dev"})
+//
+// Пример ответа:
+//
+//	[
+//		{
+//			"labels": {"host": "node1"},
+//			"value": 2.5
+//		}
+//	]
+func (h *handler) QueryHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query().Get("q")
+	if q == "" {
+		ErrBadRequest(errors.New("q is missing in parameters")).Render(w, r)
+		return
+	}
+	at, err := parseTime(r.URL.Query().Get("time"), time.Time{})
+	if err != nil {
+		ErrBadRequest(fmt.Errorf("wrong time: %w", err)).Render(w, r)
+		return
+	}
+
+	series, err := h.srv.Query(r.Context(), q, at)
+	if errors.Is(err, query.ErrSyntax) {
+		ErrBadRequest(err).Render(w, r)
+		return
+	}
+	if err != nil {
+		ErrInternalError(err).Render(w, r)
+		return
+	}
+
+	data, err := json.Marshal(series)
+	if err != nil {
+		ErrInternalError(err).Render(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// Обработчик AgentsHandler возвращает список известных серверу агентов,
+// время их последнего отчета и количество присланных ими метрик.
+//
+// Пример ответа:
+//
+//	[
+//		{
+//			"id": "node1",
+//			"last_seen": "2023-01-01T10:00:00Z",
+//			"metrics": 31
+//		}
+//	]
+func (h *handler) AgentsHandler(w http.ResponseWriter, r *http.Request) {
+	agents, err := h.srv.FindAgents(r.Context())
+	if err != nil {
+		ErrInternalError(err).Render(w, r)
+		return
+	}
+
+	data, err := json.Marshal(agents)
+	if err != nil {
+		ErrInternalError(err).Render(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// Обработчик AlertsHandler возвращает оповещения в состояниях pending, firing
+// и недавно разрешенные (resolved). Параметр state отбирает оповещения в одном состоянии.
+//
+// Пример ответа:
+//
+//	[
+//		{
+//			"rule": "HighHeap",
+//			"metric": "HeapAlloc",
+//			"type": "gauge",
+//			"labels": {"host": "node1"},
+//			"state": "firing",
+//			"value": 612368384,
+//			"active_since": "2023-01-01T10:00:00Z",
+//			"fired_at": "2023-01-01T10:02:00Z"
+//		}
+//	]
+func (h *handler) AlertsHandler(w http.ResponseWriter, r *http.Request) {
+	state := alert.State(r.URL.Query().Get("state"))
+	switch state {
+	case "", alert.StatePending, alert.StateFiring, alert.StateResolved:
+	default:
+		ErrBadRequest(fmt.Errorf("wrong state %q", state)).Render(w, r)
+		return
+	}
+
+	alerts := make([]alert.Alert, 0)
+	if h.srv.AlertEngine != nil {
+		alerts = h.srv.AlertEngine.Alerts(state)
+	}
+
+	data, err := json.Marshal(alerts)
+	if err != nil {
+		ErrInternalError(err).Render(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
@@ -1,15 +1,25 @@
 package web
 
 import (
+	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi"
+	"github.com/nickzhog/devops-tool/internal/server/alert"
+	"github.com/nickzhog/devops-tool/internal/server/auth"
 	"github.com/nickzhog/devops-tool/internal/server/config"
 	"github.com/nickzhog/devops-tool/internal/server/server"
 	"github.com/nickzhog/devops-tool/internal/server/service/cache"
@@ -19,7 +29,7 @@ import (
 )
 
 func TestHandler_UpdateFromBody(t *testing.T) {
-	srv := server.NewServer(logging.GetLogger(), &config.Config{}, cache.NewMemStorage())
+	srv := server.NewServer(logging.GetLogger(), &config.Config{}, cache.NewMemStorage(0))
 	handler := NewHandler(*srv)
 
 	type request struct {
@@ -114,7 +124,7 @@ func TestHandler_UpdateFromBody(t *testing.T) {
 }
 
 func TestHandler_SelectFromBody(t *testing.T) {
-	srv := server.NewServer(logging.GetLogger(), &config.Config{}, cache.NewMemStorage())
+	srv := server.NewServer(logging.GetLogger(), &config.Config{}, cache.NewMemStorage(0))
 	handler := NewHandler(*srv)
 
 	nulFloat := float64(0)
@@ -210,10 +220,46 @@ func TestHandler_UpdateMany(t *testing.T) {
 			`),
 			wantCode: http.StatusOK,
 		},
+		{
+			name: "histogram",
+			requestData: []byte(`
+			[
+				{"id":"latency","type":"histogram","histogram":{"buckets":[0.5,1],"counts":[1,2,0],"count":3,"sum":1.9}}
+			]
+			`),
+			wantCode: http.StatusOK,
+		},
+		{
+			name: "histogram count mismatch",
+			requestData: []byte(`
+			[
+				{"id":"latency","type":"histogram","histogram":{"buckets":[0.5,1],"counts":[1,2,0],"count":5,"sum":1.9}}
+			]
+			`),
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name: "wrong type",
+			requestData: []byte(`
+			[
+				{"id":"good_metric","type":"new_type","value":1}
+			]
+			`),
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name: "missing value",
+			requestData: []byte(`
+			[
+				{"id":"good_metric","type":"gauge"}
+			]
+			`),
+			wantCode: http.StatusBadRequest,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			srv := server.NewServer(logging.GetLogger(), &config.Config{}, cache.NewMemStorage())
+			srv := server.NewServer(logging.GetLogger(), &config.Config{}, cache.NewMemStorage(0))
 			handler := NewHandler(*srv)
 
 			request := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewBuffer([]byte(tt.requestData)))
@@ -231,7 +277,7 @@ func TestHandler_UpdateMany(t *testing.T) {
 }
 
 func TestHandler_UpdateFromURL(t *testing.T) {
-	srv := server.NewServer(logging.GetLogger(), &config.Config{}, cache.NewMemStorage())
+	srv := server.NewServer(logging.GetLogger(), &config.Config{}, cache.NewMemStorage(0))
 	handler := NewHandler(*srv)
 
 	type want struct {
@@ -305,7 +351,7 @@ func TestHandler_UpdateFromURL(t *testing.T) {
 }
 
 func TestHandler_SelectFromURL(t *testing.T) {
-	srv := server.NewServer(logging.GetLogger(), &config.Config{}, cache.NewMemStorage())
+	srv := server.NewServer(logging.GetLogger(), &config.Config{}, cache.NewMemStorage(0))
 	handler := NewHandler(*srv)
 
 	type want struct {
@@ -372,3 +418,386 @@ func TestHandler_SelectFromURL(t *testing.T) {
 		})
 	}
 }
+
+func TestHandler_PrometheusHandler(t *testing.T) {
+	srv := server.NewServer(logging.GetLogger(), &config.Config{}, cache.NewMemStorage(0))
+	handler := NewHandler(*srv)
+
+	assert := assert.New(t)
+
+	metrics := []metric.Metric{
+		metric.NewGaugeMetric("heap.alloc", 1.5),
+		metric.NewCounterMetric("PollCount", 5),
+		metric.NewCounterMetric("1requests", 3),
+		metric.NewHistogramMetric("latency", []float64{0.5, 1}, 0.2, 0.7, 3),
+		metric.NewSummaryMetric("size", []float64{0.5}, []float64{1, 2, 3}),
+	}
+	err := handler.srv.UpsertMany(context.Background(), metrics)
+	assert.NoError(err)
+
+	request := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	w := httptest.NewRecorder()
+	handler.PrometheusHandler(metric.Labels{"env": "test\"1"}).ServeHTTP(w, request)
+	res := w.Result()
+	defer res.Body.Close()
+
+	resBody, err := io.ReadAll(res.Body)
+	assert.NoError(err)
+
+	assert.Equal(http.StatusOK, res.StatusCode)
+	assert.Equal(prometheusContentType, res.Header.Get("Content-Type"))
+	assert.Equal(`# TYPE _1requests counter
+_1requests{env="test\"1"} 3
+# TYPE PollCount counter
+PollCount{env="test\"1"} 5
+# TYPE heap_alloc gauge
+heap_alloc{env="test\"1"} 1.5
+# TYPE latency histogram
+latency_bucket{env="test\"1",le="0.5"} 1
+latency_bucket{env="test\"1",le="1"} 2
+latency_bucket{env="test\"1",le="+Inf"} 3
+latency_sum{env="test\"1"} 3.9
+latency_count{env="test\"1"} 3
+# TYPE size summary
+size{env="test\"1",quantile="0.5"} 2
+size_sum{env="test\"1"} 6
+size_count{env="test\"1"} 3
+`, string(resBody))
+}
+
+func TestHandler_PrometheusHandler_Families(t *testing.T) {
+	srv := server.NewServer(logging.GetLogger(), &config.Config{}, cache.NewMemStorage(0))
+	handler := NewHandler(*srv)
+
+	assert := assert.New(t)
+
+	withLabels := func(m metric.Metric, labels metric.Labels) metric.Metric {
+		m.Labels = labels
+		return m
+	}
+	metrics := []metric.Metric{
+		// одно семейство a_b
+		withLabels(metric.NewGaugeMetric("a.b", 1), metric.Labels{"x": "1"}),
+		withLabels(metric.NewGaugeMetric("a_b", 2), metric.Labels{"x": "2"}),
+		// совпадающая строка c_d выводится один раз
+		metric.NewGaugeMetric("c.d", 3),
+		metric.NewGaugeMetric("c_d", 30),
+		// lat_sum занято гистограммой lat
+		metric.NewHistogramMetric("lat", []float64{1}, 0.5),
+		metric.NewGaugeMetric("lat_sum", 4),
+		// req занято counter
+		metric.NewCounterMetric("req", 5),
+		metric.NewHistogramMetric("req", []float64{1}, 2),
+	}
+	err := handler.srv.UpsertMany(context.Background(), metrics)
+	assert.NoError(err)
+
+	request := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	w := httptest.NewRecorder()
+	handler.PrometheusHandler(nil).ServeHTTP(w, request)
+	res := w.Result()
+	defer res.Body.Close()
+
+	resBody, err := io.ReadAll(res.Body)
+	assert.NoError(err)
+
+	assert.Equal(http.StatusOK, res.StatusCode)
+	assert.Equal(`# TYPE a_b gauge
+a_b{x="1"} 1
+a_b{x="2"} 2
+# TYPE c_d gauge
+c_d 3
+# TYPE lat histogram
+lat_bucket{le="1"} 1
+lat_bucket{le="+Inf"} 1
+lat_sum 0.5
+lat_count 1
+# TYPE lat_sum_gauge gauge
+lat_sum_gauge 4
+# TYPE req counter
+req 5
+# TYPE req_histogram histogram
+req_histogram_bucket{le="1"} 0
+req_histogram_bucket{le="+Inf"} 1
+req_histogram_sum 2
+req_histogram_count 1
+`, string(resBody))
+}
+
+func TestHandler_AgentsHandler(t *testing.T) {
+	srv := server.NewServer(logging.GetLogger(), &config.Config{}, cache.NewMemStorage(0))
+	handler := NewHandler(*srv)
+
+	assert := assert.New(t)
+
+	ctx := server.WithAgentID(context.Background(), "node1")
+	err := handler.srv.UpsertMany(ctx, []metric.Metric{
+		metric.NewGaugeMetric("good_gauge", 1),
+		metric.NewCounterMetric("good_counter", 1),
+	})
+	assert.NoError(err)
+
+	err = handler.srv.UpsertMetric(context.Background(), metric.NewGaugeMetric("anonymous_gauge", 1))
+	assert.NoError(err)
+
+	request := httptest.NewRequest(http.MethodGet, "/agents", nil)
+	w := httptest.NewRecorder()
+	http.HandlerFunc(handler.AgentsHandler).ServeHTTP(w, request)
+	res := w.Result()
+	defer res.Body.Close()
+
+	assert.Equal(http.StatusOK, res.StatusCode)
+
+	var agents []server.AgentInfo
+	err = json.NewDecoder(res.Body).Decode(&agents)
+	assert.NoError(err)
+	if assert.Len(agents, 1) {
+		assert.Equal("node1", agents[0].ID)
+		assert.Equal(2, agents[0].Metrics)
+		assert.False(agents[0].LastSeen.IsZero())
+	}
+}
+
+func TestHandler_AlertsHandler(t *testing.T) {
+	srv := server.NewServer(logging.GetLogger(), &config.Config{}, cache.NewMemStorage(0))
+	handler := NewHandler(*srv)
+
+	assert := assert.New(t)
+
+	get := func(target string) (int, []alert.Alert) {
+		w := httptest.NewRecorder()
+		http.HandlerFunc(handler.AlertsHandler).ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		res := w.Result()
+		defer res.Body.Close()
+
+		var alerts []alert.Alert
+		if res.StatusCode == http.StatusOK {
+			assert.NoError(json.NewDecoder(res.Body).Decode(&alerts))
+		}
+		return res.StatusCode, alerts
+	}
+
+	code, alerts := get("/alerts")
+	assert.Equal(http.StatusOK, code)
+	assert.Empty(alerts)
+
+	handler.srv.AlertEngine = alert.NewEngine(&alert.Config{Rules: []alert.Rule{
+		{Name: "HighHeap", Metric: "HeapAlloc", Type: metric.GaugeType, Op: ">", Threshold: 100},
+	}}, srv, nil, logging.GetLogger())
+	assert.NoError(srv.UpsertMetric(context.Background(), metric.NewGaugeMetric("HeapAlloc", 200)))
+	assert.NoError(handler.srv.AlertEngine.Evaluate(context.Background(), time.Now()))
+
+	code, alerts = get("/alerts?state=firing")
+	assert.Equal(http.StatusOK, code)
+	if assert.Len(alerts, 1) {
+		assert.Equal("HighHeap", alerts[0].Rule)
+		assert.Equal(200.0, alerts[0].Value)
+	}
+
+	code, alerts = get("/alerts?state=pending")
+	assert.Equal(http.StatusOK, code)
+	assert.Empty(alerts)
+
+	code, _ = get("/alerts?state=unknown")
+	assert.Equal(http.StatusBadRequest, code)
+}
+
+func TestHandler_QueryHandler(t *testing.T) {
+	srv := server.NewServer(logging.GetLogger(), &config.Config{}, cache.NewMemStorage(time.Hour))
+	handler := NewHandler(*srv)
+
+	for host, v := range map[string]float64{"node1": 100, "node2": 300} {
+		m := metric.NewGaugeMetric("HeapAlloc", v)
+		m.Labels = metric.Labels{"host": host}
+		assert.NoError(t, srv.UpsertMetric(context.Background(), m))
+	}
+
+	tests := []struct {
+		name     string
+		target   string
+		wantCode int
+		want     string
+	}{
+		{
+			name:     "selector",
+			target:   "/query?q=" + url.QueryEscape(`HeapAlloc{host="node2"}`),
+			wantCode: http.StatusOK,
+			want:     `[{"name":"HeapAlloc","type":"gauge","labels":{"host":"node2"},"value":300}]`,
+		},
+		{
+			name:     "aggregation",
+			target:   "/query?q=" + url.QueryEscape(`avg(Heap*)`),
+			wantCode: http.StatusOK,
+			want:     `[{"value":200}]`,
+		},
+		{
+			name:     "syntax error",
+			target:   "/query?q=" + url.QueryEscape(`sum(HeapAlloc`),
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "empty query",
+			target:   "/query",
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			http.HandlerFunc(handler.QueryHandler).ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+			res := w.Result()
+			defer res.Body.Close()
+
+			assert.Equal(t, tt.wantCode, res.StatusCode)
+			if tt.want != "" {
+				body, err := io.ReadAll(res.Body)
+				assert.NoError(t, err)
+				assert.JSONEq(t, tt.want, string(body))
+			}
+		})
+	}
+}
+
+func TestNewRouter_Auth(t *testing.T) {
+	keysFile := filepath.Join(t.TempDir(), "keys.json")
+	err := os.WriteFile(keysFile, []byte(`[
+		{"token": "writer", "agent_id": "node1", "permissions": ["write"]},
+		{"token": "reader", "permissions": ["read"]}
+	]`), 0o600)
+	assert.NoError(t, err)
+
+	keys, err := auth.NewFileStore(keysFile, logging.GetLogger())
+	assert.NoError(t, err)
+
+	srv := server.NewServer(logging.GetLogger(), &config.Config{}, cache.NewMemStorage(0))
+	srv.KeyStore = keys
+	router := NewRouter(*srv, &config.Config{})
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		token  string
+		code   int
+	}{
+		{name: "ping is public", method: http.MethodGet, target: "/ping", code: http.StatusOK},
+		{name: "no token", method: http.MethodPost, target: "/update/gauge/g/1", code: http.StatusUnauthorized},
+		{name: "unknown token", method: http.MethodPost, target: "/update/gauge/g/1", token: "other", code: http.StatusUnauthorized},
+		{name: "reader can't write", method: http.MethodPost, target: "/update/gauge/g/1", token: "reader", code: http.StatusForbidden},
+		{name: "writer writes", method: http.MethodPost, target: "/update/gauge/g/1", token: "writer", code: http.StatusOK},
+		{name: "writer can't read", method: http.MethodGet, target: "/value/gauge/g", token: "writer", code: http.StatusForbidden},
+		{name: "reader reads", method: http.MethodGet, target: "/value/gauge/g", token: "reader", code: http.StatusOK},
+		{name: "debug requires admin", method: http.MethodGet, target: "/debug/pprof/", token: "reader", code: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, nil)
+			r.Header.Set("X-Agent-ID", "forged")
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			assert.Equal(t, tt.code, w.Code)
+		})
+	}
+
+	// источник метрики - агент, которому выдан токен, а не X-Agent-ID
+	agents, err := srv.FindAgents(context.Background())
+	assert.NoError(t, err)
+	if assert.Len(t, agents, 1) {
+		assert.Equal(t, "node1", agents[0].ID)
+	}
+}
+
+func TestNewRouter_TrustedSubnet(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Settings.TrustedSubnet = "192.168.0.0/16,2001:db8::/32"
+	cfg.Settings.TrustedProxies = "10.0.0.1"
+
+	srv := server.NewServer(logging.GetLogger(), cfg, cache.NewMemStorage(0))
+	router := NewRouter(*srv, cfg)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		code       int
+	}{
+		{name: "allowed peer", remoteAddr: "192.168.1.1:5000", code: http.StatusOK},
+		{name: "allowed ipv6 peer", remoteAddr: "[2001:db8::1]:5000", code: http.StatusOK},
+		{name: "forged real ip", remoteAddr: "203.0.113.1:5000", headers: map[string]string{"X-Real-IP": "192.168.1.1"}, code: http.StatusForbidden},
+		{name: "forged forwarded for", remoteAddr: "203.0.113.1:5000", headers: map[string]string{"X-Forwarded-For": "192.168.1.1"}, code: http.StatusForbidden},
+		{name: "trusted proxy", remoteAddr: "10.0.0.1:5000", headers: map[string]string{"X-Forwarded-For": "192.168.1.1"}, code: http.StatusOK},
+		{name: "trusted proxy, denied client", remoteAddr: "10.0.0.1:5000", headers: map[string]string{"X-Forwarded-For": "203.0.113.1"}, code: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/ping", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			assert.Equal(t, tt.code, w.Code)
+		})
+	}
+}
+
+func TestHandler_WatchHandler(t *testing.T) {
+	srv := server.NewServer(logging.GetLogger(), &config.Config{}, cache.NewMemStorage(0))
+	ts := httptest.NewServer(NewRouter(*srv, &config.Config{}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/watch?name=PollCount&type=counter", nil)
+	assert.NoError(t, err)
+	req.Header.Set("Accept-Encoding", "gzip")
+	res, err := http.DefaultClient.Do(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer res.Body.Close()
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+
+	// события приходят сразу, несмотря на сжатие
+	gz, err := gzip.NewReader(res.Body)
+	if !assert.NoError(t, err) {
+		return
+	}
+	events := bufio.NewReader(gz)
+
+	// подписка оформлена до ответа, обновления после него не теряются
+	assert.NoError(t, srv.UpsertMetric(ctx, metric.NewGaugeMetric("PollCount", 1)))
+	assert.NoError(t, srv.UpsertMetric(ctx, metric.NewCounterMetric("Other", 1)))
+	assert.NoError(t, srv.UpsertMetric(ctx, metric.NewCounterMetric("PollCount", 2)))
+	assert.NoError(t, srv.UpsertMany(ctx, []metric.Metric{metric.NewCounterMetric("PollCount", 3)}))
+
+	for _, want := range []int64{2, 5} {
+		line, err := events.ReadString('\n')
+		assert.NoError(t, err)
+		assert.Equal(t, "event: metric\n", line)
+
+		line, err = events.ReadString('\n')
+		assert.NoError(t, err)
+
+		var m metric.Metric
+		assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &m))
+		assert.Equal(t, "PollCount", m.ID)
+		assert.Equal(t, want, *m.Delta)
+
+		_, err = events.ReadString('\n')
+		assert.NoError(t, err)
+	}
+
+	res, err = http.Get(ts.URL + "/watch?type=unknown")
+	if assert.NoError(t, err) {
+		res.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	}
+}
@@ -0,0 +1,33 @@
+package web
+
+import (
+	"strconv"
+	"time"
+)
+
+// parseTime разбирает время в формате RFC3339 или unix-время в секундах.
+// Для пустой строки возвращается def.
+func parseTime(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+
+	return time.Parse(time.RFC3339, s)
+}
+
+// parseDuration разбирает длительность в формате time.ParseDuration или в секундах.
+func parseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Duration(sec) * time.Second, nil
+	}
+
+	return time.ParseDuration(s)
+}
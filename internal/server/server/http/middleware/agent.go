@@ -0,0 +1,17 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/nickzhog/devops-tool/internal/server/server"
+)
+
+// AgentID сохраняет в контексте запроса идентификатор агента из заголовка X-Agent-ID.
+func AgentID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if agentID := r.Header.Get("X-Agent-ID"); agentID != "" {
+			r = r.WithContext(server.WithAgentID(r.Context(), agentID))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
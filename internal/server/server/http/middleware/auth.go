@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/nickzhog/devops-tool/internal/server/auth"
+	"github.com/nickzhog/devops-tool/internal/server/server"
+	"github.com/nickzhog/devops-tool/pkg/logging"
+)
+
+// Authenticate проверяет токен из заголовка Authorization: Bearer <token> и наличие у ключа права perm.
+// Идентификатор агента, которому выдан ключ, заменяет переданный в X-Agent-ID.
+// Если хранилище ключей не задано, запросы пропускаются без проверки.
+func Authenticate(keys auth.KeyStore, perm auth.Permission, logger *logging.Logger) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if keys == nil {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, err := auth.Authenticate(r.Context(), keys, r.Header.Get("Authorization"), perm)
+			switch {
+			case err == nil:
+			case errors.Is(err, auth.ErrForbidden):
+				logger.Tracef("agent %q: %s for %s", key.AgentID, err.Error(), r.URL.Path)
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			case errors.Is(err, auth.ErrNoToken), errors.Is(err, auth.ErrUnknownToken):
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			default:
+				logger.Errorf("auth: %s", err.Error())
+				http.Error(w, "auth failed", http.StatusInternalServerError)
+				return
+			}
+
+			ctx := auth.WithKey(r.Context(), key)
+			if key.AgentID != "" {
+				ctx = server.WithAgentID(ctx, key.AgentID)
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
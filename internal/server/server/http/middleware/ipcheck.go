@@ -1,18 +1,22 @@
 package middleware
 
 import (
-	"net"
 	"net/http"
 
+	"github.com/nickzhog/devops-tool/pkg/ipfilter"
 	"github.com/nickzhog/devops-tool/pkg/logging"
 )
 
-func CheckIP(trustedSubnet *net.IPNet, logger *logging.Logger) func(next http.Handler) http.Handler {
+// CheckIP пропускает запросы только из разрешенных подсетей.
+// Адрес клиента определяется по адресу соединения, заголовки X-Forwarded-For
+// и X-Real-IP учитываются, только если соединение установлено доверенным прокси.
+func CheckIP(filter *ipfilter.Filter, logger *logging.Logger) func(next http.Handler) http.Handler {
 	fn := func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := filter.ClientIP(ipfilter.HostIP(r.RemoteAddr),
+				r.Header.Values("X-Forwarded-For"), r.Header.Get("X-Real-IP"))
 
-			ip := r.Header.Get("X-Real-IP")
-			if ip != "" && isIPInSubnet(ip, trustedSubnet) {
+			if filter.Allowed(ip) {
 				next.ServeHTTP(w, r)
 			} else {
 				logger.Tracef("wrong ip: %s", ip)
@@ -22,8 +26,3 @@ func CheckIP(trustedSubnet *net.IPNet, logger *logging.Logger) func(next http.Ha
 	}
 	return fn
 }
-
-func isIPInSubnet(ip string, subnet *net.IPNet) bool {
-	checkIP := net.ParseIP(ip)
-	return subnet.Contains(checkIP)
-}
@@ -0,0 +1,243 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nickzhog/devops-tool/pkg/metric"
+)
+
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Обработчик PrometheusHandler отдает все метрики хранилища
+// в текстовом формате Prometheus (exposition format 0.0.4).
+// Типы метрик отображаются в одноименные типы Prometheus: histogram выводится
+// накопительными корзинами _bucket{le} с _sum и _count, summary - квантилями с _sum и _count.
+// К labels каждой метрики добавляются общие labels из конфига.
+// Метрики одного типа, имена которых совпадают после приведения к формату Prometheus,
+// выводятся одним семейством (см. groupPrometheusFamilies), повторы строк пропускаются.
+//
+// Пример ответа:
+//
+//	# TYPE Alloc gauge
+//	Alloc{env="prod"} 1.234e+06
+//	# TYPE PollCount counter
+//	PollCount{env="prod"} 5
+func (h *handler) PrometheusHandler(constLabels metric.Labels) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metrics, err := h.srv.FindAll(r.Context(), nil)
+		if err != nil {
+			ErrInternalError(err).Render(w, r)
+			return
+		}
+
+		sort.Slice(metrics, func(i, j int) bool {
+			if metrics[i].ID != metrics[j].ID {
+				return metrics[i].ID < metrics[j].ID
+			}
+			if metrics[i].MType != metrics[j].MType {
+				return metrics[i].MType < metrics[j].MType
+			}
+			return metrics[i].Labels.String() < metrics[j].Labels.String()
+		})
+
+		var buf bytes.Buffer
+		for _, f := range groupPrometheusFamilies(metrics) {
+			fmt.Fprintf(&buf, "# TYPE %s %s\n", f.name, f.mtype)
+
+			series := make(map[string]bool, len(f.metrics))
+			for _, m := range f.metrics {
+				labels := mergeLabels(constLabels, m.Labels)
+
+				key := formatPrometheusLabels(labels)
+				if series[key] {
+					h.srv.Logger.Errorf("prometheus: metric %s %s%s duplicates series of %s, skipped",
+						m.MType, m.ID, m.Labels.String(), f.name)
+					continue
+				}
+				series[key] = true
+
+				writePrometheusMetric(&buf, f.name, labels, m)
+			}
+		}
+
+		w.Header().Set("Content-Type", prometheusContentType)
+		w.Write(buf.Bytes())
+	}
+}
+
+// prometheusFamily - семейство Prometheus: метрики одного типа, имена которых
+// совпадают после sanitizePrometheusName.
+type prometheusFamily struct {
+	name    string
+	mtype   string
+	metrics []metric.Metric
+}
+
+// groupPrometheusFamilies группирует метрики в семейства в порядке первого появления.
+// Если имя семейства уже занято семейством другого типа или строками _bucket, _sum и _count
+// гистограмм и summary, к имени добавляется суффикс _<тип>.
+func groupPrometheusFamilies(metrics []metric.Metric) []*prometheusFamily {
+	var families []*prometheusFamily
+	byKey := make(map[string]*prometheusFamily)
+	taken := make(map[string]bool)
+	for _, m := range metrics {
+		if !metric.IsValidType(m.MType) {
+			continue
+		}
+
+		base := sanitizePrometheusName(m.ID)
+		key := m.MType + "|" + base
+		f, ok := byKey[key]
+		if !ok {
+			name := base
+			for prometheusNameTaken(taken, name, m.MType) {
+				name += "_" + m.MType
+			}
+			for _, n := range prometheusSampleNames(name, m.MType) {
+				taken[n] = true
+			}
+
+			f = &prometheusFamily{name: name, mtype: m.MType}
+			byKey[key] = f
+			families = append(families, f)
+		}
+		f.metrics = append(f.metrics, m)
+	}
+
+	return families
+}
+
+func prometheusNameTaken(taken map[string]bool, name, mtype string) bool {
+	for _, n := range prometheusSampleNames(name, mtype) {
+		if taken[n] {
+			return true
+		}
+	}
+	return false
+}
+
+// prometheusSampleNames возвращает имя семейства и имена строк, которые выводит семейство.
+func prometheusSampleNames(name, mtype string) []string {
+	switch mtype {
+	case metric.HistogramType:
+		return []string{name, name + "_bucket", name + "_sum", name + "_count"}
+	case metric.SummaryType:
+		return []string{name, name + "_sum", name + "_count"}
+	}
+	return []string{name}
+}
+
+// writePrometheusMetric выводит строки метрики m семейства name.
+func writePrometheusMetric(buf *bytes.Buffer, name string, labels metric.Labels, m metric.Metric) {
+	switch m.MType {
+	case metric.GaugeType:
+		writePrometheusSample(buf, name, labels, strconv.FormatFloat(*m.Value, 'g', -1, 64))
+	case metric.CounterType:
+		writePrometheusSample(buf, name, labels, strconv.FormatInt(*m.Delta, 10))
+	case metric.HistogramType:
+		for i, c := range m.Histogram.Cumulative() {
+			le := "+Inf"
+			if i < len(m.Histogram.Buckets) {
+				le = strconv.FormatFloat(m.Histogram.Buckets[i], 'g', -1, 64)
+			}
+			writePrometheusSample(buf, name+"_bucket", mergeLabels(labels, metric.Labels{"le": le}),
+				strconv.FormatUint(c, 10))
+		}
+		writePrometheusSample(buf, name+"_sum", labels, strconv.FormatFloat(m.Histogram.Sum, 'g', -1, 64))
+		writePrometheusSample(buf, name+"_count", labels, strconv.FormatUint(m.Histogram.Count, 10))
+	case metric.SummaryType:
+		for _, q := range m.Summary.Quantiles {
+			quantile := strconv.FormatFloat(q.Quantile, 'g', -1, 64)
+			writePrometheusSample(buf, name, mergeLabels(labels, metric.Labels{"quantile": quantile}),
+				strconv.FormatFloat(q.Value, 'g', -1, 64))
+		}
+		writePrometheusSample(buf, name+"_sum", labels, strconv.FormatFloat(m.Summary.Sum, 'g', -1, 64))
+		writePrometheusSample(buf, name+"_count", labels, strconv.FormatUint(m.Summary.Count, 10))
+	}
+}
+
+func writePrometheusSample(buf *bytes.Buffer, name string, labels metric.Labels, value string) {
+	fmt.Fprintf(buf, "%s%s %s\n", name, formatPrometheusLabels(labels), value)
+}
+
+// sanitizePrometheusName приводит имя метрики к виду [a-zA-Z_:][a-zA-Z0-9_:]*,
+// заменяя недопустимые символы на '_'.
+func sanitizePrometheusName(name string) string {
+	return sanitizeName(name, true)
+}
+
+// sanitizePrometheusLabel приводит имя label к виду [a-zA-Z_][a-zA-Z0-9_]*.
+func sanitizePrometheusLabel(name string) string {
+	return sanitizeName(name, false)
+}
+
+func sanitizeName(name string, allowColon bool) string {
+	if name == "" {
+		return "_"
+	}
+
+	var b strings.Builder
+	for i, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		case c == ':' && allowColon:
+		case c >= '0' && c <= '9':
+			if i == 0 {
+				b.WriteByte('_')
+			}
+		default:
+			c = '_'
+		}
+		b.WriteRune(c)
+	}
+
+	return b.String()
+}
+
+// mergeLabels объединяет наборы labels, значения из labels приоритетнее base.
+func mergeLabels(base, labels metric.Labels) metric.Labels {
+	if len(base) < 1 {
+		return labels
+	}
+
+	merged := make(metric.Labels, len(base)+len(labels))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range labels {
+		merged[k] = v
+	}
+
+	return merged
+}
+
+// formatPrometheusLabels возвращает отсортированный набор labels в виде {k1="v1",k2="v2"}.
+func formatPrometheusLabels(labels metric.Labels) string {
+	if len(labels) < 1 {
+		return ""
+	}
+
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", sanitizePrometheusLabel(k), escapeLabelValue(labels[k])))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelValueReplacer.Replace(v)
+}
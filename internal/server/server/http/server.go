@@ -12,30 +12,34 @@ import (
 	"github.com/go-chi/chi"
 	chimiddleware "github.com/go-chi/chi/middleware"
 
+	"github.com/nickzhog/devops-tool/internal/server/auth"
 	"github.com/nickzhog/devops-tool/internal/server/config"
 	"github.com/nickzhog/devops-tool/internal/server/server"
 	"github.com/nickzhog/devops-tool/internal/server/server/http/middleware"
 	"github.com/nickzhog/devops-tool/pkg/encryption"
+	"github.com/nickzhog/devops-tool/pkg/ipfilter"
+	"github.com/nickzhog/devops-tool/pkg/metric"
+	"github.com/nickzhog/devops-tool/pkg/tlsconfig"
 )
 
-func Serve(ctx context.Context, srv server.Server, cfg *config.Config) {
+// NewRouter возвращает обработчик всех маршрутов HTTP-сервера.
+func NewRouter(srv server.Server, cfg *config.Config) http.Handler {
 	handlerData := NewHandler(srv)
 
 	r := chi.NewRouter()
 
 	// r.Use(chimiddleware.Logger)
 	if cfg.Settings.TrustedSubnet != "" {
-		r.Use(chimiddleware.RealIP)
-
-		_, ipNet, err := net.ParseCIDR(cfg.Settings.TrustedSubnet)
+		filter, err := ipfilter.New(cfg.Settings.TrustedSubnet, cfg.Settings.TrustedProxies)
 		if err != nil {
 			srv.Logger.Fatal(err)
 		}
-		r.Use(middleware.CheckIP(ipNet, srv.Logger))
+		r.Use(middleware.CheckIP(filter, srv.Logger))
 	}
 
 	r.Use(middleware.GzipCompress)
 	r.Use(middleware.GzipDecompress)
+	r.Use(middleware.AgentID)
 
 	if cfg.Settings.CryptoKey != "" {
 		key, err := encryption.NewPrivateKey(cfg.Settings.CryptoKey)
@@ -45,32 +49,81 @@ func Serve(ctx context.Context, srv server.Server, cfg *config.Config) {
 		r.Use(middleware.RequestDecryptMiddleWare(key, srv.Logger))
 	}
 
-	r.Mount("/debug", chimiddleware.Profiler())
+	authorize := func(perm auth.Permission) func(http.Handler) http.Handler {
+		return middleware.Authenticate(srv.KeyStore, perm, srv.Logger)
+	}
+
+	r.With(authorize(auth.PermAdmin)).Mount("/debug", chimiddleware.Profiler())
 
 	r.Get("/ping", handlerData.PingHandler)
 
-	r.Get("/", handlerData.IndexHandler)
+	promLabels, err := metric.ParseLabels(cfg.Settings.PrometheusLabels)
+	if err != nil {
+		srv.Logger.Fatal(err)
+	}
+
+	r.Group(func(r chi.Router) {
+		r.Use(authorize(auth.PermRead))
+
+		r.Get("/", handlerData.IndexHandler)
+		r.Get("/metrics", handlerData.PrometheusHandler(promLabels))
+
+		r.Route("/value", func(r chi.Router) {
+			r.Post("/", handlerData.SelectFromBody)
+			r.Get("/{metric_type}/{name}", handlerData.SelectFromURL)
+		})
+
+		r.Get("/history/{metric_type}/{name}", handlerData.SelectHistory)
+
+		r.Get("/query", handlerData.QueryHandler)
+
+		r.Get("/agents", handlerData.AgentsHandler)
 
-	r.Route("/value", func(r chi.Router) {
-		r.Post("/", handlerData.SelectFromBody)
-		r.Get("/{metric_type}/{name}", handlerData.SelectFromURL)
+		r.Get("/alerts", handlerData.AlertsHandler)
+
+		r.Get("/watch", handlerData.WatchHandler)
 	})
 
-	r.Route("/update", func(r chi.Router) {
-		r.Post("/", handlerData.UpdateFromBody)
-		r.Post("/{metric_type}/{name}/{value}", handlerData.UpdateFromURL)
+	r.Group(func(r chi.Router) {
+		r.Use(authorize(auth.PermWrite))
+
+		r.Route("/update", func(r chi.Router) {
+			r.Post("/", handlerData.UpdateFromBody)
+			r.Post("/{metric_type}/{name}/{value}", handlerData.UpdateFromURL)
+		})
+
+		// batch update
+		r.Post("/updates/", handlerData.UpdateMany)
 	})
 
-	// batch update
-	r.Post("/updates/", handlerData.UpdateMany)
+	return r
+}
 
+func Serve(ctx context.Context, srv server.Server, cfg *config.Config) {
 	httpSrv := &http.Server{
 		Addr:    cfg.Settings.Address,
-		Handler: r,
+		Handler: NewRouter(srv, cfg),
+		// контексты запросов отменяются при остановке, чтобы завершить потоки /watch
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+
+	if cfg.Settings.TLSCert != "" {
+		reloader, err := tlsconfig.NewReloader(cfg.Settings.TLSCert, cfg.Settings.TLSKey, cfg.Settings.TLSClientCA)
+		if err != nil {
+			srv.Logger.Fatal(err)
+		}
+		httpSrv.TLSConfig = reloader.Config()
+		go reloader.ReloadOnSignal(ctx, srv.Logger)
 	}
 
 	go func() {
-		if err := httpSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if httpSrv.TLSConfig != nil {
+			err = httpSrv.ListenAndServeTLS("", "")
+		} else {
+			err = httpSrv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("listen:%+s\n", err)
 		}
 	}()
@@ -0,0 +1,94 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nickzhog/devops-tool/internal/server/server"
+	"github.com/nickzhog/devops-tool/pkg/metric"
+)
+
+// watchHeartbeat - интервал комментариев, не дающих прокси закрыть простаивающее соединение.
+const watchHeartbeat = 15 * time.Second
+
+// Обработчик WatchHandler отправляет изменения метрик в формате Server-Sent Events.
+// Каждое событие metric содержит значение метрики после обновления в формате JSON.
+// Параметры name и type отбирают метрики по именам и типам через запятую, labels - по labels.
+// Если клиент не успевает читать события, поток завершается событием error.
+//
+// Пример URL-запроса:
+// /watch?name=PollCount,Alloc&type=gauge,counter&labels=host=node1
+//
+// Пример события:
+//
+//	event: metric
+//	data: {"id":"PollCount","type":"counter","delta":10}
+func (h *handler) WatchHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter := server.WatchFilter{
+		Names: splitParam(query.Get("name")),
+		Types: splitParam(query.Get("type")),
+	}
+	for _, t := range filter.Types {
+		if !metric.IsValidType(t) {
+			ErrBadRequest(fmt.Errorf("wrong metric type: %s", t)).Render(w, r)
+			return
+		}
+	}
+
+	var err error
+	filter.Labels, err = metric.ParseLabels(query.Get("labels"))
+	if err != nil {
+		ErrBadRequest(err).Render(w, r)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		ErrInternalError(errors.New("streaming is not supported")).Render(w, r)
+		return
+	}
+
+	sub := h.srv.Watch(filter)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(watchHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+		case m, ok := <-sub.C():
+			if !ok {
+				fmt.Fprintf(w, "event: error\ndata: %s\n\n", sub.Err())
+				flusher.Flush()
+				return
+			}
+			fmt.Fprintf(w, "event: metric\ndata: %s\n\n", m.Marshal())
+		}
+		flusher.Flush()
+	}
+}
+
+func splitParam(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -2,17 +2,25 @@ package server
 
 import (
 	"context"
+	"fmt"
+	"time"
 
+	"github.com/nickzhog/devops-tool/internal/server/alert"
+	"github.com/nickzhog/devops-tool/internal/server/auth"
 	"github.com/nickzhog/devops-tool/internal/server/config"
+	"github.com/nickzhog/devops-tool/internal/server/query"
 	"github.com/nickzhog/devops-tool/internal/server/service"
 	"github.com/nickzhog/devops-tool/pkg/logging"
 	"github.com/nickzhog/devops-tool/pkg/metric"
 )
 
 type Server struct {
-	Logger  *logging.Logger
-	cfg     *config.Config
-	storage service.Storage
+	Logger      *logging.Logger
+	KeyStore    auth.KeyStore // хранилище API-ключей, nil - аутентификация по токенам отключена
+	AlertEngine *alert.Engine // движок правил оповещений, nil - оповещения отключены
+	cfg         *config.Config
+	storage     service.Storage
+	watch       *watchHub
 }
 
 func NewServer(logger *logging.Logger, cfg *config.Config, storage service.Storage) *Server {
@@ -20,11 +28,12 @@ func NewServer(logger *logging.Logger, cfg *config.Config, storage service.Stora
 		Logger:  logger,
 		cfg:     cfg,
 		storage: storage,
+		watch:   newWatchHub(),
 	}
 }
 
-func (s *Server) FindMetric(ctx context.Context, name, mtype string) (metric.Metric, error) {
-	m, err := s.storage.FindMetric(ctx, name, mtype)
+func (s *Server) FindMetric(ctx context.Context, name, mtype string, labels metric.Labels) (metric.Metric, error) {
+	m, err := s.storage.FindMetric(ctx, name, mtype, labels)
 	if s.cfg.Settings.Key != "" {
 		m.Hash = m.GetHash(s.cfg.Settings.Key)
 	}
@@ -32,14 +41,32 @@ func (s *Server) FindMetric(ctx context.Context, name, mtype string) (metric.Met
 }
 
 func (s *Server) UpsertMetric(ctx context.Context, m metric.Metric) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+
 	if s.cfg.Settings.Key != "" && !m.IsValidHash(s.cfg.Settings.Key) {
 		return metric.ErrWrongHash
 	}
 
-	return s.storage.UpsertMetric(ctx, m)
+	setSource(ctx, &m, time.Now())
+
+	stored, err := s.storage.UpsertMetric(ctx, m)
+	if err != nil {
+		return err
+	}
+	s.notify(stored)
+
+	return nil
 }
 
 func (s *Server) UpsertMany(ctx context.Context, metrics []metric.Metric) error {
+	for _, m := range metrics {
+		if err := m.Validate(); err != nil {
+			return fmt.Errorf("%s: %w", m.ID, err)
+		}
+	}
+
 	if s.cfg.Settings.Key != "" {
 		for _, m := range metrics {
 			if !m.IsValidHash(s.cfg.Settings.Key) {
@@ -47,13 +74,67 @@ func (s *Server) UpsertMany(ctx context.Context, metrics []metric.Metric) error
 			}
 		}
 	}
-	return s.storage.ImportMetrics(ctx, metrics)
+
+	now := time.Now()
+	for i := range metrics {
+		setSource(ctx, &metrics[i], now)
+	}
+
+	stored, err := s.storage.ImportMetrics(ctx, metrics)
+	if err != nil {
+		return err
+	}
+	s.notify(stored...)
+
+	return nil
 }
 
-func (s *Server) FindAll(ctx context.Context) ([]metric.Metric, error) {
-	return s.storage.ExportMetrics(ctx)
+// FindAll возвращает все метрики, labels которых содержат selector.
+// Пустой selector возвращает все метрики хранилища.
+func (s *Server) FindAll(ctx context.Context, selector metric.Labels) ([]metric.Metric, error) {
+	metrics, err := s.storage.ExportMetrics(ctx)
+	if err != nil || len(selector) < 1 {
+		return metrics, err
+	}
+
+	filtered := make([]metric.Metric, 0, len(metrics))
+	for _, m := range metrics {
+		if m.Labels.Match(selector) {
+			filtered = append(filtered, m)
+		}
+	}
+
+	return filtered, nil
+}
+
+// Query вычисляет запрос на языке запросов на момент at по истории метрик,
+// нулевой at - по текущим значениям.
+// Ошибки разбора запроса оборачивают query.ErrSyntax.
+func (s *Server) Query(ctx context.Context, q string, at time.Time) ([]query.Series, error) {
+	expr, err := query.Parse(q)
+	if err != nil {
+		return nil, err
+	}
+	if at.IsZero() {
+		return query.Evaluate(ctx, s, expr, time.Now())
+	}
+	return query.EvaluateAt(ctx, s, expr, at)
+}
+
+func (s *Server) FindHistory(ctx context.Context, name, mtype string, labels metric.Labels, from, to time.Time, step time.Duration) ([]metric.Sample, error) {
+	return s.storage.FindHistory(ctx, name, mtype, labels, from, to, step)
 }
 
 func (s *Server) Ping(ctx context.Context) error {
 	return s.storage.Ping(ctx)
 }
+
+// setSource отмечает метрику идентификатором агента из контекста и временем получения.
+// Метрики без идентификатора агента сохраняются без источника.
+func setSource(ctx context.Context, m *metric.Metric, now time.Time) {
+	m.Source = AgentIDFromContext(ctx)
+	m.LastSeen = nil
+	if m.Source != "" {
+		m.LastSeen = &now
+	}
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/nickzhog/devops-tool/pkg/metric"
+)
+
+// watchBuffer - количество изменений, которые подписчик может не прочитать, прежде чем будет отключен.
+const watchBuffer = 256
+
+var ErrWatchLagged = errors.New("watcher is too slow, subscription closed")
+var ErrWatchClosed = errors.New("server is shutting down, subscription closed")
+
+// WatchFilter - отбор изменений метрик для подписки.
+// Пустые Names и Types означают любые имена и типы, Labels - labels, которые должна содержать метрика.
+type WatchFilter struct {
+	Names  []string
+	Types  []string
+	Labels metric.Labels
+}
+
+// Match сообщает, подходит ли метрика под фильтр.
+func (f WatchFilter) Match(m metric.Metric) bool {
+	return matchAny(f.Names, m.ID) && matchAny(f.Types, m.MType) && m.Labels.Match(f.Labels)
+}
+
+func matchAny(list []string, v string) bool {
+	if len(list) < 1 {
+		return true
+	}
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Subscription - подписка на изменения метрик.
+type Subscription struct {
+	filter WatchFilter
+	ch     chan metric.Metric
+	err    error
+	hub    *watchHub
+}
+
+// C возвращает канал изменений. Канал закрывается при отмене подписки
+// или отключении медленного подписчика, причину возвращает Err.
+func (s *Subscription) C() <-chan metric.Metric {
+	return s.ch
+}
+
+// Err возвращает причину закрытия канала или nil.
+func (s *Subscription) Err() error {
+	s.hub.mutex.RLock()
+	defer s.hub.mutex.RUnlock()
+	return s.err
+}
+
+// Close отменяет подписку.
+func (s *Subscription) Close() {
+	s.hub.remove(s, nil)
+}
+
+// watchHub рассылает изменения метрик подписчикам.
+type watchHub struct {
+	mutex  sync.RWMutex
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+func newWatchHub() *watchHub {
+	return &watchHub{subs: make(map[*Subscription]struct{})}
+}
+
+func (h *watchHub) subscribe(filter WatchFilter) *Subscription {
+	s := &Subscription{
+		filter: filter,
+		ch:     make(chan metric.Metric, watchBuffer),
+		hub:    h,
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.closed {
+		s.err = ErrWatchClosed
+		close(s.ch)
+		return s
+	}
+	h.subs[s] = struct{}{}
+
+	return s
+}
+
+// closeAll закрывает все подписки с ошибкой ErrWatchClosed, новые подписки закрываются сразу.
+func (h *watchHub) closeAll() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.closed = true
+	for s := range h.subs {
+		delete(h.subs, s)
+		s.err = ErrWatchClosed
+		close(s.ch)
+	}
+}
+
+func (h *watchHub) remove(s *Subscription, err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if _, ok := h.subs[s]; !ok {
+		return
+	}
+	delete(h.subs, s)
+	s.err = err
+	close(s.ch)
+}
+
+// wants сообщает, есть ли подписчики на изменения метрики.
+func (h *watchHub) wants(m metric.Metric) bool {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	for s := range h.subs {
+		if s.filter.Match(m) {
+			return true
+		}
+	}
+	return false
+}
+
+// publish отправляет изменение подписчикам, не блокируясь.
+// Подписчик, не успевающий читать изменения, отключается с ошибкой ErrWatchLagged.
+func (h *watchHub) publish(m metric.Metric) {
+	var lagged []*Subscription
+
+	h.mutex.RLock()
+	for s := range h.subs {
+		if !s.filter.Match(m) {
+			continue
+		}
+		select {
+		case s.ch <- m:
+		default:
+			lagged = append(lagged, s)
+		}
+	}
+	h.mutex.RUnlock()
+
+	for _, s := range lagged {
+		h.remove(s, ErrWatchLagged)
+	}
+}
+
+// Watch подписывает на изменения метрик, подходящих под filter.
+// Изменения содержат значения метрик после применения обновления.
+// Подписку нужно закрыть вызовом Close.
+func (s *Server) Watch(filter WatchFilter) *Subscription {
+	return s.watch.subscribe(filter)
+}
+
+// CloseWatchers закрывает все подписки на изменения метрик, чтобы потоки подписчиков
+// завершились при остановке сервера. Подписки, созданные после вызова, закрываются сразу.
+func (s *Server) CloseWatchers() {
+	s.watch.closeAll()
+}
+
+// notify рассылает подписчикам значения метрик, получившиеся после записи в хранилище.
+func (s *Server) notify(metrics ...metric.Metric) {
+	for _, m := range metrics {
+		if !s.watch.wants(m) {
+			continue
+		}
+
+		if s.cfg.Settings.Key != "" {
+			m.Hash = m.GetHash(s.cfg.Settings.Key)
+		}
+		s.watch.publish(m)
+	}
+}
@@ -2,8 +2,8 @@ package cache
 
 import (
 	"context"
-	"errors"
 	"sync"
+	"time"
 
 	"github.com/nickzhog/devops-tool/internal/server/service"
 	"github.com/nickzhog/devops-tool/pkg/metric"
@@ -11,17 +11,27 @@ import (
 
 var _ service.Storage = (*memStorage)(nil)
 
+// historyPruneInterval - как часто при записи удаляются устаревшие точки истории всех метрик,
+// в том числе метрик, которые больше не обновляются.
+const historyPruneInterval = time.Minute
+
 type memStorage struct {
-	mutex          *sync.RWMutex
-	gaugeMetrics   map[string]float64
-	counterMetrics map[string]int64
+	mutex   *sync.RWMutex
+	metrics map[string]metric.Metric
+
+	retention time.Duration
+	history   map[string][]metric.Sample
+	prunedAt  time.Time
 }
 
-func NewMemStorage() *memStorage {
+// NewMemStorage создает хранилище в памяти.
+// retention определяет, сколько хранить историю значений, 0 - история не ведется.
+func NewMemStorage(retention time.Duration) *memStorage {
 	return &memStorage{
-		mutex:          new(sync.RWMutex),
-		gaugeMetrics:   make(map[string]float64),
-		counterMetrics: make(map[string]int64),
+		mutex:     new(sync.RWMutex),
+		metrics:   make(map[string]metric.Metric),
+		retention: retention,
+		history:   make(map[string][]metric.Sample),
 	}
 }
 
@@ -29,49 +39,50 @@ func (m *memStorage) Ping(ctx context.Context) error {
 	return nil
 }
 
-func (m *memStorage) UpsertMetric(ctx context.Context, metricElem metric.Metric) error {
+func (m *memStorage) UpsertMetric(ctx context.Context, metricElem metric.Metric) (metric.Metric, error) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
+	key := storageKey(metricElem.ID, metricElem.MType, metricElem.Labels)
+	answer := metric.Metric{
+		ID:       metricElem.ID,
+		MType:    metricElem.MType,
+		Labels:   metricElem.Labels,
+		Source:   metricElem.Source,
+		LastSeen: metricElem.LastSeen,
+	}
+
 	switch metricElem.MType {
 	case metric.GaugeType:
-		m.gaugeMetrics[metricElem.ID] = *metricElem.Value
+		value := *metricElem.Value
+		answer.Value = &value
 	case metric.CounterType:
 		delta := *metricElem.Delta
-		oldDelta, exist := m.counterMetrics[metricElem.ID]
-		if exist {
-			delta += oldDelta
-			metricElem.Delta = &delta
+		old, exist := m.metrics[key]
+		if exist && !metricElem.Absolute {
+			delta += *old.Delta
 		}
-		m.counterMetrics[metricElem.ID] = delta
+		answer.Delta = &delta
+	case metric.HistogramType:
+		answer.Histogram = metric.MergeHistograms(m.metrics[key].Histogram, metricElem.Histogram)
+	case metric.SummaryType:
+		answer.Summary = metricElem.Summary.Copy()
 
 	default:
-		return errors.New("wrong metric type")
+		return metric.Metric{}, metric.ErrWrongType
 	}
 
-	return nil
+	m.metrics[key] = answer
+	m.appendHistory(key, answer)
+
+	return answer, nil
 }
 
-func (m *memStorage) FindMetric(ctx context.Context, name, mtype string) (metric.Metric, error) {
+func (m *memStorage) FindMetric(ctx context.Context, name, mtype string, labels metric.Labels) (metric.Metric, error) {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 
-	var (
-		answer = metric.Metric{ID: name, MType: mtype}
-		ok     bool
-		value  float64
-		delta  int64
-	)
-
-	switch mtype {
-	case metric.GaugeType:
-		value, ok = m.gaugeMetrics[name]
-		answer.Value = &value
-	case metric.CounterType:
-		delta, ok = m.counterMetrics[name]
-		answer.Delta = &delta
-	}
-
+	answer, ok := m.metrics[storageKey(name, mtype, labels)]
 	if !ok {
 		return metric.Metric{}, metric.ErrNoResult
 	}
@@ -83,29 +94,91 @@ func (m *memStorage) ExportMetrics(ctx context.Context) ([]metric.Metric, error)
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 
-	var metrics []metric.Metric
-	for k, v := range m.gaugeMetrics {
-		metrics = append(metrics, metric.NewGaugeMetric(k, v))
-	}
-
-	for k, v := range m.counterMetrics {
-		metrics = append(metrics, metric.NewCounterMetric(k, v))
+	if len(m.metrics) < 1 {
+		return nil, nil
 	}
 
-	if len(metrics) < 1 {
-		return nil, nil
+	metrics := make([]metric.Metric, 0, len(m.metrics))
+	for _, v := range m.metrics {
+		metrics = append(metrics, v)
 	}
 
 	return metrics, nil
 }
 
-func (m *memStorage) ImportMetrics(ctx context.Context, metrics []metric.Metric) error {
+func (m *memStorage) ImportMetrics(ctx context.Context, metrics []metric.Metric) ([]metric.Metric, error) {
+	stored := make([]metric.Metric, 0, len(metrics))
 	for _, v := range metrics {
-		err := m.UpsertMetric(ctx, v)
+		answer, err := m.UpsertMetric(ctx, v)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		stored = append(stored, answer)
 	}
 
-	return nil
+	return stored, nil
+}
+
+func (m *memStorage) FindHistory(ctx context.Context, name, mtype string, labels metric.Labels, from, to time.Time, step time.Duration) ([]metric.Sample, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	// точки старше retention могут быть еще не удалены
+	if cutoff := time.Now().Add(-m.retention); m.retention > 0 && from.Before(cutoff) {
+		from = cutoff
+	}
+
+	samples := make([]metric.Sample, 0)
+	for _, s := range m.history[storageKey(name, mtype, labels)] {
+		if s.Timestamp.Before(from) || s.Timestamp.After(to) {
+			continue
+		}
+		samples = append(samples, s)
+	}
+
+	return metric.Downsample(samples, step), nil
+}
+
+// appendHistory добавляет текущее значение метрики в историю и удаляет ее точки старше retention.
+// Не чаще historyPruneInterval устаревшие точки удаляются у всех метрик.
+// Вызывается под блокировкой на запись.
+func (m *memStorage) appendHistory(key string, metricElem metric.Metric) {
+	if m.retention <= 0 {
+		return
+	}
+
+	now := time.Now()
+	cutoff := now.Add(-m.retention)
+	m.history[key] = pruneSamples(append(m.history[key], metric.NewSample(metricElem, now)), cutoff)
+
+	interval := historyPruneInterval
+	if m.retention < interval {
+		interval = m.retention
+	}
+	if now.Sub(m.prunedAt) < interval {
+		return
+	}
+	m.prunedAt = now
+
+	for k, samples := range m.history {
+		samples = pruneSamples(samples, cutoff)
+		if len(samples) < 1 {
+			delete(m.history, k)
+			continue
+		}
+		m.history[k] = samples
+	}
+}
+
+// pruneSamples отбрасывает точки старше cutoff из отсортированной по времени истории.
+func pruneSamples(samples []metric.Sample, cutoff time.Time) []metric.Sample {
+	i := 0
+	for i < len(samples) && samples[i].Timestamp.Before(cutoff) {
+		i++
+	}
+	return samples[i:]
+}
+
+func storageKey(name, mtype string, labels metric.Labels) string {
+	return mtype + ":" + metric.SeriesKey(name, labels)
 }
@@ -4,13 +4,14 @@ import (
 	"context"
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/nickzhog/devops-tool/pkg/metric"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestMemStorage_Upsert(t *testing.T) {
-	storage := NewMemStorage()
+	storage := NewMemStorage(0)
 
 	tests := []struct {
 		name       string
@@ -27,21 +28,40 @@ func TestMemStorage_Upsert(t *testing.T) {
 			metric:     metric.NewCounterMetric("good_counter", 10),
 			wantResult: int64(20),
 		},
+		{
+			name: "absolute counter",
+			metric: func() metric.Metric {
+				m := metric.NewCounterMetric("good_counter", 7)
+				m.Absolute = true
+				return m
+			}(),
+			wantResult: int64(7),
+		},
 		{
 			name:       "gauge metric",
 			metric:     metric.NewGaugeMetric("good_gauge", 10),
 			wantResult: float64(10),
 		},
+		{
+			name: "labeled counter",
+			metric: func() metric.Metric {
+				m := metric.NewCounterMetric("good_counter", 5)
+				m.Labels = metric.Labels{"host": "node1"}
+				return m
+			}(),
+			wantResult: int64(5),
+		},
 	}
 	ctx := context.Background()
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			assert := assert.New(t)
 
-			err := storage.UpsertMetric(ctx, tt.metric)
+			stored, err := storage.UpsertMetric(ctx, tt.metric)
 			assert.NoError(err)
 
-			metricElem, err := storage.FindMetric(ctx, tt.metric.ID, tt.metric.MType)
+			metricElem, err := storage.FindMetric(ctx, tt.metric.ID, tt.metric.MType, tt.metric.Labels)
+			assert.Equal(metricElem, stored)
 
 			switch tt.metric.MType {
 			case metric.CounterType:
@@ -55,6 +75,94 @@ func TestMemStorage_Upsert(t *testing.T) {
 	}
 }
 
+func TestMemStorage_UpsertHistogram(t *testing.T) {
+	storage := NewMemStorage(0)
+	ctx := context.Background()
+	assert := assert.New(t)
+
+	buckets := []float64{1, 5}
+	_, err := storage.UpsertMetric(ctx, metric.NewHistogramMetric("latency", buckets, 0.5, 3))
+	assert.NoError(err)
+	_, err = storage.UpsertMetric(ctx, metric.NewHistogramMetric("latency", buckets, 10))
+	assert.NoError(err)
+
+	m, err := storage.FindMetric(ctx, "latency", metric.HistogramType, nil)
+	assert.NoError(err)
+	assert.Equal([]uint64{1, 1, 1}, m.Histogram.Counts)
+	assert.Equal(uint64(3), m.Histogram.Count)
+	assert.Equal(13.5, m.Histogram.Sum)
+
+	// при изменении границ корзин накопленные значения сбрасываются
+	_, err = storage.UpsertMetric(ctx, metric.NewHistogramMetric("latency", []float64{2}, 1))
+	assert.NoError(err)
+
+	m, err = storage.FindMetric(ctx, "latency", metric.HistogramType, nil)
+	assert.NoError(err)
+	assert.Equal([]uint64{1, 0}, m.Histogram.Counts)
+	assert.Equal(uint64(1), m.Histogram.Count)
+}
+
+func TestMemStorage_FindHistory(t *testing.T) {
+	storage := NewMemStorage(time.Hour)
+	ctx := context.Background()
+	assert := assert.New(t)
+
+	from := time.Now()
+	for i := 1; i <= 3; i++ {
+		_, err := storage.UpsertMetric(ctx, metric.NewCounterMetric("good_counter", 10))
+		assert.NoError(err)
+	}
+
+	samples, err := storage.FindHistory(ctx, "good_counter", metric.CounterType, nil, from, time.Now(), 0)
+	assert.NoError(err)
+	if assert.Len(samples, 3) {
+		for i, s := range samples {
+			assert.Equal(int64(10*(i+1)), *s.Delta)
+		}
+	}
+
+	samples, err = storage.FindHistory(ctx, "good_counter", metric.CounterType, nil, from, time.Now(), time.Hour)
+	assert.NoError(err)
+	if assert.NotEmpty(samples) {
+		assert.Equal(int64(30), *samples[len(samples)-1].Delta)
+	}
+
+	samples, err = storage.FindHistory(ctx, "unknown", metric.GaugeType, nil, from, time.Now(), 0)
+	assert.NoError(err)
+	assert.Empty(samples)
+}
+
+func TestMemStorage_HistoryRetention(t *testing.T) {
+	retention := 50 * time.Millisecond
+	storage := NewMemStorage(retention)
+	ctx := context.Background()
+	assert := assert.New(t)
+
+	from := time.Now()
+	_, err := storage.UpsertMetric(ctx, metric.NewGaugeMetric("idle", 1))
+	assert.NoError(err)
+
+	// метрика больше не обновляется, ее история устаревает
+	time.Sleep(2 * retention)
+
+	samples, err := storage.FindHistory(ctx, "idle", metric.GaugeType, nil, from, time.Now(), 0)
+	assert.NoError(err)
+	assert.Empty(samples)
+
+	// запись другой метрики удаляет устаревшую историю
+	_, err = storage.UpsertMetric(ctx, metric.NewGaugeMetric("active", 1))
+	assert.NoError(err)
+
+	storage.mutex.RLock()
+	_, ok := storage.history[storageKey("idle", metric.GaugeType, nil)]
+	storage.mutex.RUnlock()
+	assert.False(ok)
+
+	samples, err = storage.FindHistory(ctx, "active", metric.GaugeType, nil, from, time.Now(), 0)
+	assert.NoError(err)
+	assert.Len(samples, 1)
+}
+
 func BenchmarkExportMetrics(b *testing.B) {
 	metricsJSON := []byte(`
 	[
@@ -76,13 +184,13 @@ func BenchmarkExportMetrics(b *testing.B) {
 
 	assert := assert.New(b)
 
-	storage := NewMemStorage()
+	storage := NewMemStorage(0)
 
 	var metrics []metric.Metric
 	err := json.Unmarshal(metricsJSON, &metrics)
 	assert.NoError(err)
 
-	err = storage.ImportMetrics(context.Background(), metrics)
+	_, err = storage.ImportMetrics(context.Background(), metrics)
 	assert.NoError(err)
 
 	b.ResetTimer()
@@ -0,0 +1,211 @@
+package db
+
+import (
+	"context"
+	"sort"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/nickzhog/devops-tool/pkg/metric"
+)
+
+// copyMinRows - минимальный размер пакета после агрегации, начиная с которого
+// метрики записываются через COPY. На небольших пакетах создание временной таблицы
+// обходится дороже, чем отдельные запросы в pgx.Batch.
+const copyMinRows = 32
+
+// stagingColumns - колонки временной таблицы metrics_staging в порядке значений stagingRow.
+var stagingColumns = []string{"id", "type", "value", "delta", "labels", "source", "last_seen", "data", "absolute"}
+
+// aggregate объединяет повторы одной метрики в пакете, чтобы каждая метрика
+// записывалась в базу один раз:
+//   - приращения counter суммируются, counter с Absolute отбрасывает предыдущие приращения;
+//   - для gauge и summary остается последнее значение;
+//   - histogram с одинаковыми корзинами суммируются, при смене корзин остается последняя,
+//     а Absolute означает, что сохраненное значение заменяется, а не объединяется с пакетом.
+//
+// Source и LastSeen берутся из последнего повтора.
+// Результат отсортирован по типу, имени и labels, чтобы параллельные импорты
+// блокировали строки в одном порядке.
+func aggregate(metrics []metric.Metric) []metric.Metric {
+	type entry struct {
+		key string
+		m   metric.Metric
+	}
+
+	index := make(map[string]int, len(metrics))
+	entries := make([]entry, 0, len(metrics))
+	for _, m := range metrics {
+		key := m.MType + "|" + m.SeriesKey()
+
+		i, ok := index[key]
+		if !ok {
+			if m.MType == metric.HistogramType {
+				m.Absolute = false
+			}
+			index[key] = len(entries)
+			entries = append(entries, entry{key: key, m: m})
+			continue
+		}
+
+		prev := entries[i].m
+		switch m.MType {
+		case metric.CounterType:
+			if !m.Absolute {
+				delta := *prev.Delta + *m.Delta
+				m.Delta = &delta
+				m.Absolute = prev.Absolute
+			}
+		case metric.HistogramType:
+			if prev.Histogram.SameBuckets(m.Histogram) {
+				histogram := prev.Histogram.Copy()
+				_ = histogram.Merge(m.Histogram)
+				m.Histogram = histogram
+				m.Absolute = prev.Absolute
+			} else {
+				m.Absolute = true
+			}
+		}
+		entries[i].m = m
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+
+	result := make([]metric.Metric, 0, len(entries))
+	for _, e := range entries {
+		result = append(result, e.m)
+	}
+	return result
+}
+
+// stagingRow возвращает значения колонок stagingColumns для метрики.
+func stagingRow(m metric.Metric) []interface{} {
+	return []interface{}{
+		m.ID, m.MType, m.Value, m.Delta, encodeLabels(m.Labels),
+		m.Source, m.LastSeen, encodeData(m), m.Absolute,
+	}
+}
+
+// mergeQuery возвращает запрос, переносящий метрики из metrics_staging в metrics
+// и возвращающий их новые значения колонками returningColumns.
+// Приращения counter прибавляются к сохраненным, строки с absolute заменяют сохраненные значения.
+// Если включена история, новые значения метрик дополнительно записываются в metric_samples.
+func (r *repository) mergeQuery() string {
+	q := `
+	WITH added AS (
+		INSERT
+		INTO metrics
+			(id, type, value, delta, labels, source, last_seen, data)
+		SELECT id, type, value, delta, labels, source, last_seen, data
+		FROM metrics_staging
+		WHERE NOT absolute
+		ON CONFLICT (id,type,labels) DO UPDATE
+		SET value=excluded.value, delta=metrics.delta+excluded.delta,
+			source=excluded.source, last_seen=excluded.last_seen, data=excluded.data
+		RETURNING ` + returningColumns + `
+	), replaced AS (
+		INSERT
+		INTO metrics
+			(id, type, value, delta, labels, source, last_seen, data)
+		SELECT id, type, value, delta, labels, source, last_seen, data
+		FROM metrics_staging
+		WHERE absolute
+		ON CONFLICT (id,type,labels) DO UPDATE
+		SET value=excluded.value, delta=excluded.delta,
+			source=excluded.source, last_seen=excluded.last_seen, data=excluded.data
+		RETURNING ` + returningColumns + `
+	)`
+
+	if r.cfg.Settings.HistoryRetention > 0 {
+		q += `, history AS (
+		INSERT
+		INTO metric_samples
+			(id, type, value, delta, labels, data)
+		SELECT id, type, value, delta, labels, data FROM added
+		UNION ALL
+		SELECT id, type, value, delta, labels, data FROM replaced
+	)`
+	}
+
+	return q + `
+	SELECT ` + returningColumns + ` FROM added
+	UNION ALL
+	SELECT ` + returningColumns + ` FROM replaced;
+	`
+}
+
+// importCopy записывает агрегированный пакет через COPY во временную таблицу
+// metrics_staging и переносит его в metrics одним запросом.
+// Возвращает новые значения записанных метрик.
+func (r *repository) importCopy(ctx context.Context, tx pgx.Tx, metrics []metric.Metric) ([]metric.Metric, error) {
+	q := `
+	CREATE TEMP TABLE metrics_staging (
+		id TEXT NOT NULL,
+		type TEXT NOT NULL,
+		value DOUBLE PRECISION,
+		delta BIGINT,
+		labels JSONB NOT NULL,
+		source TEXT NOT NULL,
+		last_seen TIMESTAMPTZ,
+		data JSONB,
+		absolute BOOLEAN NOT NULL
+	) ON COMMIT DROP;
+	`
+	_, err := tx.Exec(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([][]interface{}, 0, len(metrics))
+	for _, m := range metrics {
+		rows = append(rows, stagingRow(m))
+	}
+
+	_, err = tx.CopyFrom(ctx, pgx.Identifier{"metrics_staging"}, stagingColumns, pgx.CopyFromRows(rows))
+	if err != nil {
+		return nil, err
+	}
+
+	merged, err := tx.Query(ctx, r.mergeQuery())
+	if err != nil {
+		return nil, err
+	}
+	defer merged.Close()
+
+	stored := make([]metric.Metric, 0, len(metrics))
+	for merged.Next() {
+		m, err := scanMetric(merged)
+		if err != nil {
+			return nil, err
+		}
+		stored = append(stored, m)
+	}
+
+	return stored, merged.Err()
+}
+
+// importBatch записывает пакет отдельными запросами upsertQuery в одном pgx.Batch.
+// Возвращает новые значения записанных метрик.
+func (r *repository) importBatch(ctx context.Context, tx pgx.Tx, metrics []metric.Metric) ([]metric.Metric, error) {
+	q := r.upsertQuery()
+
+	batch := &pgx.Batch{}
+	for _, v := range metrics {
+		batch.Queue(q, upsertArgs(v)...)
+	}
+
+	results := tx.SendBatch(ctx, batch)
+	defer results.Close()
+
+	stored := make([]metric.Metric, 0, len(metrics))
+	for range metrics {
+		m, err := scanMetric(results.QueryRow())
+		if err != nil {
+			return nil, err
+		}
+		stored = append(stored, m)
+	}
+
+	return stored, results.Close()
+}
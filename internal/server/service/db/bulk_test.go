@@ -0,0 +1,189 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/nickzhog/devops-tool/internal/server/config"
+	"github.com/nickzhog/devops-tool/migration"
+	"github.com/nickzhog/devops-tool/pkg/logging"
+	"github.com/nickzhog/devops-tool/pkg/metric"
+	"github.com/nickzhog/devops-tool/pkg/postgres"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAggregate(t *testing.T) {
+	assert := assert.New(t)
+
+	absolute := metric.NewCounterMetric("counter", 100)
+	absolute.Absolute = true
+
+	labeled := metric.NewGaugeMetric("gauge", 5)
+	labeled.Labels = metric.Labels{"host": "a"}
+
+	metrics := []metric.Metric{
+		metric.NewCounterMetric("counter", 1),
+		metric.NewGaugeMetric("gauge", 1),
+		metric.NewCounterMetric("counter", 2),
+		labeled,
+		metric.NewGaugeMetric("gauge", 3),
+		absolute,
+		metric.NewCounterMetric("counter", 4),
+		metric.NewCounterMetric("other", 10),
+	}
+
+	result := aggregate(metrics)
+	if !assert.Len(result, 4) {
+		return
+	}
+
+	assert.Equal("counter", result[0].ID)
+	assert.Equal(int64(104), *result[0].Delta)
+	assert.True(result[0].Absolute)
+
+	assert.Equal("other", result[1].ID)
+	assert.Equal(int64(10), *result[1].Delta)
+	assert.False(result[1].Absolute)
+
+	assert.Equal("gauge", result[2].ID)
+	assert.Nil(result[2].Labels)
+	assert.Equal(float64(3), *result[2].Value)
+
+	assert.Equal(labeled.Labels, result[3].Labels)
+	assert.Equal(float64(5), *result[3].Value)
+
+	// входные метрики не изменяются
+	assert.Equal(int64(1), *metrics[0].Delta)
+}
+
+func TestAggregate_Histogram(t *testing.T) {
+	assert := assert.New(t)
+
+	buckets := []float64{1, 5}
+	other := []float64{1, 10}
+
+	result := aggregate([]metric.Metric{
+		metric.NewHistogramMetric("merged", buckets, 0.5),
+		metric.NewHistogramMetric("merged", buckets, 3, 7),
+		metric.NewHistogramMetric("reset", buckets, 0.5),
+		metric.NewHistogramMetric("reset", other, 7),
+	})
+	if !assert.Len(result, 2) {
+		return
+	}
+
+	assert.False(result[0].Absolute)
+	assert.Equal(uint64(3), result[0].Histogram.Count)
+	assert.Equal([]uint64{1, 1, 1}, result[0].Histogram.Counts)
+
+	assert.True(result[1].Absolute)
+	assert.Equal(other, result[1].Histogram.Buckets)
+	assert.Equal(uint64(1), result[1].Histogram.Count)
+}
+
+// benchmarkMetrics возвращает пакет из size метрик, в котором каждая метрика повторяется repeats раз.
+func benchmarkMetrics(size, repeats int) []metric.Metric {
+	metrics := make([]metric.Metric, 0, size)
+	for i := 0; len(metrics) < size; i++ {
+		name := fmt.Sprintf("metric_%d", i%(size/repeats))
+		if i%2 == 0 {
+			metrics = append(metrics, metric.NewCounterMetric(name, int64(i)))
+		} else {
+			metrics = append(metrics, metric.NewGaugeMetric(name, float64(i)))
+		}
+	}
+	return metrics
+}
+
+func BenchmarkAggregate(b *testing.B) {
+	metrics := benchmarkMetrics(1000, 10)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		aggregate(metrics)
+	}
+}
+
+// BenchmarkImportMetrics сравнивает запись пакета отдельными запросами в pgx.Batch
+// и через COPY. Требует базу PostgreSQL в переменной окружения TEST_DATABASE_DSN.
+func BenchmarkImportMetrics(b *testing.B) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		b.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	err := migration.Migrate(dsn)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	ctx := context.Background()
+	client, err := postgres.NewClient(ctx, 1, dsn)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	repo := NewRepository(client, logging.GetLogger(), new(config.Config))
+
+	benchmarks := []struct {
+		name  string
+		write func(ctx context.Context, tx pgx.Tx, metrics []metric.Metric) ([]metric.Metric, error)
+	}{
+		{name: "batch", write: repo.importBatch},
+		{name: "copy", write: repo.importCopy},
+	}
+	for _, size := range []int{10, 100, 1000, 10000} {
+		metrics := benchmarkMetrics(size, 1)
+
+		for _, bm := range benchmarks {
+			b.Run(fmt.Sprintf("%s/%d", bm.name, size), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					tx, err := client.Begin(ctx)
+					if err != nil {
+						b.Fatal(err)
+					}
+					_, err = bm.write(ctx, tx, metrics)
+					if err != nil {
+						b.Fatal(err)
+					}
+					err = tx.Commit(ctx)
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+
+	// повторы метрик в пакете: прежний путь записывает каждую метрику,
+	// ImportMetrics объединяет повторы до записи
+	metrics := benchmarkMetrics(10000, 10)
+	b.Run("duplicates/batch", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			tx, err := client.Begin(ctx)
+			if err != nil {
+				b.Fatal(err)
+			}
+			_, err = repo.importBatch(ctx, tx, metrics)
+			if err != nil {
+				b.Fatal(err)
+			}
+			err = tx.Commit(ctx)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("duplicates/import", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, err := repo.ImportMetrics(ctx, metrics)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"time"
 
 	"github.com/jackc/pgx/v4"
 	"github.com/nickzhog/devops-tool/internal/server/config"
@@ -33,21 +34,22 @@ func (r *repository) Ping(ctx context.Context) error {
 	return r.client.Ping(ctx)
 }
 
-func (r *repository) FindMetric(ctx context.Context, name, mtype string) (metric.Metric, error) {
+func (r *repository) FindMetric(ctx context.Context, name, mtype string, labels metric.Labels) (metric.Metric, error) {
 	q := `
 		SELECT
-		 	delta, value 
+		 	delta, value, data, source, last_seen 
 		FROM 
 			public.metrics 
 		WHERE 
-			type = $1 and id = $2;
+			type = $1 and id = $2 and labels = $3::jsonb;
 	`
 
 	var delta sql.NullInt64
 	var value sql.NullFloat64
-	m := metric.Metric{ID: name, MType: mtype}
-	err := r.client.QueryRow(ctx, q, mtype, name).Scan(
-		&delta, &value)
+	var data []byte
+	m := metric.Metric{ID: name, MType: mtype, Labels: labels}
+	err := r.client.QueryRow(ctx, q, mtype, name, encodeLabels(labels)).Scan(
+		&delta, &value, &data, &m.Source, &m.LastSeen)
 
 	if err != nil {
 		r.logger.Errorf("metric find err:%s", err.Error())
@@ -69,23 +71,25 @@ func (r *repository) FindMetric(ctx context.Context, name, mtype string) (metric
 			return metric.Metric{}, metric.ErrNoResult
 		}
 		m.Value = &value.Float64
+	case metric.HistogramType, metric.SummaryType:
+		if data == nil {
+			return metric.Metric{}, metric.ErrNoResult
+		}
+		err = decodeData(mtype, data, &m.Histogram, &m.Summary)
+		if err != nil {
+			return metric.Metric{}, err
+		}
 	}
 
 	return m, nil
 }
 
-func (r *repository) UpsertMetric(ctx context.Context, metric metric.Metric) (err error) {
-	q := `
-	INSERT 
-	INTO metrics
-		(id, type, value, delta) 
-	VALUES 
-		($1, $2, $3, $4)
-	ON CONFLICT (id,type) DO UPDATE 
-	SET value=$3, delta=metrics.delta+$4;
-	`
-	_, err = r.client.Exec(ctx, q,
-		metric.ID, metric.MType, metric.Value, metric.Delta)
+func (r *repository) UpsertMetric(ctx context.Context, m metric.Metric) (stored metric.Metric, err error) {
+	if m.Histogram != nil {
+		stored, err = r.upsertHistogram(ctx, m)
+	} else {
+		stored, err = scanMetric(r.client.QueryRow(ctx, r.upsertQuery(), upsertArgs(m)...))
+	}
 
 	if err != nil {
 		r.logger.Trace(err)
@@ -94,41 +98,162 @@ func (r *repository) UpsertMetric(ctx context.Context, metric metric.Metric) (er
 	return
 }
 
-func (r *repository) ImportMetrics(ctx context.Context, metrics []metric.Metric) error {
+// ImportMetrics записывает пакет метрик в одной транзакции.
+// Повторы метрик в пакете предварительно объединяются (см. aggregate),
+// крупные пакеты записываются через COPY и один запрос слияния.
+func (r *repository) ImportMetrics(ctx context.Context, metrics []metric.Metric) ([]metric.Metric, error) {
+	metrics = aggregate(metrics)
+
 	tx, err := r.client.Begin(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer tx.Rollback(ctx)
 
+	for i := range metrics {
+		// гистограммы объединяются с сохраненным значением до записи,
+		// если корзины в пакете не менялись
+		if metrics[i].Histogram != nil && !metrics[i].Absolute {
+			err = r.mergeHistogram(ctx, tx, &metrics[i])
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var stored []metric.Metric
+	if len(metrics) < copyMinRows {
+		stored, err = r.importBatch(ctx, tx, metrics)
+	} else {
+		stored, err = r.importCopy(ctx, tx, metrics)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return stored, tx.Commit(ctx)
+}
+
+// FindHistory возвращает историю метрики за [from, to], прореженную до step.
+// Если для step подходит разрешение агрегатов, история читается из агрегатов,
+// а еще не агрегированный конец промежутка - из metric_samples.
+func (r *repository) FindHistory(ctx context.Context, name, mtype string, labels metric.Labels, from, to time.Time, step time.Duration) ([]metric.Sample, error) {
+	var samples []metric.Sample
+	if res, ok := pickResolution(r.resolutions(), mtype, step); ok {
+		rollups, end, err := r.findRollups(ctx, res, name, mtype, labels, from, to)
+		if err != nil {
+			return nil, err
+		}
+		samples = rollups
+		if end.After(from) {
+			from = end
+		}
+	}
+
+	raw, err := r.findSamples(ctx, name, mtype, labels, from, to)
+	if err != nil {
+		return nil, err
+	}
+	samples = append(samples, raw...)
+
+	return metric.Downsample(samples, step), nil
+}
+
+// findSamples возвращает значения метрики из metric_samples за [from, to].
+func (r *repository) findSamples(ctx context.Context, name, mtype string, labels metric.Labels, from, to time.Time) ([]metric.Sample, error) {
 	q := `
-	INSERT 
-	INTO metrics
-		(id, type, value, delta) 
-	VALUES 
-		($1, $2, $3, $4)
-	ON CONFLICT (id,type) DO UPDATE 
-	SET value=$3, delta=metrics.delta+$4;
+		SELECT
+			ts, delta, value, data 
+		FROM 
+			public.metric_samples 
+		WHERE 
+			type = $1 AND id = $2 AND labels = $3::jsonb AND ts BETWEEN $4 AND $5
+		ORDER BY ts;
 	`
 
-	batch := &pgx.Batch{}
-	for _, v := range metrics {
-		batch.Queue(q, v.ID, v.MType, v.Value, v.Delta)
+	rows, err := r.client.Query(ctx, q, mtype, name, encodeLabels(labels), from, to)
+	if err != nil {
+		r.logger.Errorf("history find err:%s", err.Error())
+		return nil, err
 	}
+	defer rows.Close()
 
-	result := tx.Conn().SendBatch(ctx, batch)
-	err = result.Close()
-	if err != nil {
-		return err
+	samples := make([]metric.Sample, 0)
+	for rows.Next() {
+		var s metric.Sample
+
+		var delta sql.NullInt64
+		var value sql.NullFloat64
+		var data []byte
+
+		err = rows.Scan(&s.Timestamp, &delta, &value, &data)
+		if err != nil {
+			r.logger.Errorf("history parse:%s", err.Error())
+			return nil, err
+		}
+
+		err = decodeData(mtype, data, &s.Histogram, &s.Summary)
+		if err != nil {
+			return nil, err
+		}
+
+		if delta.Valid {
+			s.Delta = &delta.Int64
+		}
+		if value.Valid {
+			s.Value = &value.Float64
+		}
+
+		samples = append(samples, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return samples, nil
+}
+
+// upsertQuery возвращает запрос обновления метрики, который возвращает ее новое значение
+// колонками returningColumns. Если включена история, новое значение метрики
+// дополнительно записывается в metric_samples.
+func (r *repository) upsertQuery() string {
+	if r.cfg.Settings.HistoryRetention <= 0 {
+		return `
+	INSERT 
+	INTO metrics
+		(id, type, value, delta, labels, source, last_seen, data) 
+	VALUES 
+		($1, $2, $3, $4, $5::jsonb, $6, $7, $8::jsonb)
+	ON CONFLICT (id,type,labels) DO UPDATE 
+	SET value=$3, delta=CASE WHEN $9::boolean THEN $4 ELSE metrics.delta+$4 END, source=$6, last_seen=$7, data=$8::jsonb
+	RETURNING ` + returningColumns + `;
+	`
 	}
 
-	return tx.Commit(ctx)
+	return `
+	WITH upserted AS (
+		INSERT 
+		INTO metrics
+			(id, type, value, delta, labels, source, last_seen, data) 
+		VALUES 
+			($1, $2, $3, $4, $5::jsonb, $6, $7, $8::jsonb)
+		ON CONFLICT (id,type,labels) DO UPDATE 
+		SET value=$3, delta=CASE WHEN $9::boolean THEN $4 ELSE metrics.delta+$4 END, source=$6, last_seen=$7, data=$8::jsonb
+		RETURNING ` + returningColumns + `
+	), history AS (
+		INSERT 
+		INTO metric_samples
+			(id, type, value, delta, labels, data)
+		SELECT id, type, value, delta, labels, data FROM upserted
+	)
+	SELECT ` + returningColumns + ` FROM upserted;
+	`
 }
 
 func (r *repository) ExportMetrics(ctx context.Context) ([]metric.Metric, error) {
 	q := `
 		SELECT
-		id, type, delta, value 
+		id, type, delta, value, data, labels, source, last_seen 
 		FROM public.metrics;
 	`
 
@@ -138,19 +263,27 @@ func (r *repository) ExportMetrics(ctx context.Context) ([]metric.Metric, error)
 		r.logger.Errorf("metrics find err:%s", err.Error())
 		return nil, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		var m metric.Metric
 
 		var delta sql.NullInt64
 		var value sql.NullFloat64
+		var data []byte
+		var labels []byte
 
-		err = rows.Scan(&m.ID, &m.MType, &delta, &value)
+		err = rows.Scan(&m.ID, &m.MType, &delta, &value, &data, &labels, &m.Source, &m.LastSeen)
 		if err != nil {
 			r.logger.Errorf("metrics parse:%s", err.Error())
 			return nil, err
 		}
 
+		m.Labels, err = decodeLabels(labels)
+		if err != nil {
+			return nil, err
+		}
+
 		switch m.MType {
 		case metric.CounterType:
 			if !delta.Valid {
@@ -162,10 +295,71 @@ func (r *repository) ExportMetrics(ctx context.Context) ([]metric.Metric, error)
 				return nil, fmt.Errorf("null value for %s", m.ID)
 			}
 			m.Value = &value.Float64
+		case metric.HistogramType, metric.SummaryType:
+			if data == nil {
+				return nil, fmt.Errorf("null data for %s", m.ID)
+			}
+			err = decodeData(m.MType, data, &m.Histogram, &m.Summary)
+			if err != nil {
+				return nil, err
+			}
 		}
 
 		metrics = append(metrics, m)
 	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
 
 	return metrics, nil
 }
+
+// upsertHistogram объединяет гистограмму с сохраненным значением и записывает результат
+// в одной транзакции, блокируя строку метрики на время обновления.
+func (r *repository) upsertHistogram(ctx context.Context, m metric.Metric) (metric.Metric, error) {
+	tx, err := r.client.Begin(ctx)
+	if err != nil {
+		return metric.Metric{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	err = r.mergeHistogram(ctx, tx, &m)
+	if err != nil {
+		return metric.Metric{}, err
+	}
+
+	stored, err := scanMetric(tx.QueryRow(ctx, r.upsertQuery(), upsertArgs(m)...))
+	if err != nil {
+		return metric.Metric{}, err
+	}
+
+	return stored, tx.Commit(ctx)
+}
+
+// mergeHistogram заменяет гистограмму метрики на результат ее объединения с сохраненным значением.
+func (r *repository) mergeHistogram(ctx context.Context, tx pgx.Tx, m *metric.Metric) error {
+	q := `
+		SELECT
+			data 
+		FROM 
+			public.metrics 
+		WHERE 
+			type = $1 and id = $2 and labels = $3::jsonb
+		FOR UPDATE;
+	`
+
+	var data []byte
+	err := tx.QueryRow(ctx, q, m.MType, m.ID, encodeLabels(m.Labels)).Scan(&data)
+	if err != nil && err != pgx.ErrNoRows {
+		return err
+	}
+
+	var current *metric.Histogram
+	err = decodeData(m.MType, data, &current, new(*metric.Summary))
+	if err != nil {
+		return err
+	}
+
+	m.Histogram = metric.MergeHistograms(current, m.Histogram)
+	return nil
+}
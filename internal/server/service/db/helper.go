@@ -0,0 +1,106 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/nickzhog/devops-tool/pkg/metric"
+)
+
+// returningColumns - колонки metrics, которые возвращают запросы записи, в порядке scanMetric.
+const returningColumns = "id, type, value, delta, labels, data, source, last_seen"
+
+// encodeLabels возвращает labels в виде JSON для колонки jsonb.
+func encodeLabels(labels metric.Labels) string {
+	if len(labels) < 1 {
+		return "{}"
+	}
+	data, _ := json.Marshal(labels)
+	return string(data)
+}
+
+func decodeLabels(data []byte) (metric.Labels, error) {
+	var labels metric.Labels
+	err := json.Unmarshal(data, &labels)
+	if err != nil {
+		return nil, err
+	}
+	if len(labels) < 1 {
+		return nil, nil
+	}
+	return labels, nil
+}
+
+// encodeData возвращает значение histogram или summary в виде JSON для колонки jsonb,
+// для остальных типов метрик - nil.
+func encodeData(m metric.Metric) interface{} {
+	var data []byte
+	switch {
+	case m.Histogram != nil:
+		data, _ = json.Marshal(m.Histogram)
+	case m.Summary != nil:
+		data, _ = json.Marshal(m.Summary)
+	default:
+		return nil
+	}
+	return string(data)
+}
+
+// decodeData заполняет значение histogram или summary из колонки jsonb.
+func decodeData(mtype string, data []byte, histogram **metric.Histogram, summary **metric.Summary) error {
+	if data == nil {
+		return nil
+	}
+
+	switch mtype {
+	case metric.HistogramType:
+		*histogram = new(metric.Histogram)
+		return json.Unmarshal(data, *histogram)
+	case metric.SummaryType:
+		*summary = new(metric.Summary)
+		return json.Unmarshal(data, *summary)
+	}
+
+	return nil
+}
+
+// upsertArgs возвращает параметры запроса upsertQuery для метрики.
+func upsertArgs(m metric.Metric) []interface{} {
+	return []interface{}{
+		m.ID, m.MType, m.Value, m.Delta, encodeLabels(m.Labels),
+		m.Source, m.LastSeen, encodeData(m), m.Absolute,
+	}
+}
+
+// scanMetric читает метрику из строки с колонками returningColumns.
+func scanMetric(row pgx.Row) (metric.Metric, error) {
+	var m metric.Metric
+
+	var delta sql.NullInt64
+	var value sql.NullFloat64
+	var labels, data []byte
+
+	err := row.Scan(&m.ID, &m.MType, &value, &delta, &labels, &data, &m.Source, &m.LastSeen)
+	if err != nil {
+		return metric.Metric{}, err
+	}
+
+	m.Labels, err = decodeLabels(labels)
+	if err != nil {
+		return metric.Metric{}, err
+	}
+	if delta.Valid {
+		m.Delta = &delta.Int64
+	}
+	if value.Valid {
+		m.Value = &value.Float64
+	}
+
+	err = decodeData(m.MType, data, &m.Histogram, &m.Summary)
+	if err != nil {
+		return metric.Metric{}, err
+	}
+
+	return m, nil
+}
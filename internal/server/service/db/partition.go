@@ -0,0 +1,248 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// Таблица metric_samples секционирована по времени ts. Каждая секция покрывает
+// промежуток [start, end) и называется metric_samples_<start>_<end> (время UTC),
+// строки вне созданных секций попадают в секцию metric_samples_default.
+const (
+	samplesTable        = "metric_samples"
+	samplesDefault      = "metric_samples_default"
+	partitionTimeLayout = "200601021504"
+
+	// partitionsAhead - сколько секций создается заранее после текущей.
+	partitionsAhead = 2
+	// defaultPartitionWidth - ширина секции, если она не задана в конфиге.
+	defaultPartitionWidth = 24 * time.Hour
+)
+
+// partition - секция metric_samples.
+type partition struct {
+	Name  string
+	Start time.Time
+	End   time.Time
+}
+
+func newPartition(start, end time.Time) partition {
+	start, end = start.UTC(), end.UTC()
+	return partition{
+		Name:  fmt.Sprintf("%s_%s_%s", samplesTable, start.Format(partitionTimeLayout), end.Format(partitionTimeLayout)),
+		Start: start,
+		End:   end,
+	}
+}
+
+// parsePartition восстанавливает границы секции по ее имени.
+// Возвращает false для секции по умолчанию и таблиц с другими именами.
+func parsePartition(name string) (partition, bool) {
+	bounds := strings.Split(strings.TrimPrefix(name, samplesTable+"_"), "_")
+	if len(bounds) != 2 || !strings.HasPrefix(name, samplesTable+"_") {
+		return partition{}, false
+	}
+
+	start, err := time.Parse(partitionTimeLayout, bounds[0])
+	if err != nil {
+		return partition{}, false
+	}
+	end, err := time.Parse(partitionTimeLayout, bounds[1])
+	if err != nil || !end.After(start) {
+		return partition{}, false
+	}
+
+	return partition{Name: name, Start: start, End: end}, true
+}
+
+// overlaps проверяет, пересекаются ли промежутки секций.
+func (p partition) overlaps(other partition) bool {
+	return p.Start.Before(other.End) && other.Start.Before(p.End)
+}
+
+// uncovered возвращает части промежутка секции, не занятые секциями existing, по возрастанию.
+func (p partition) uncovered(existing []partition) []partition {
+	sorted := make([]partition, len(existing))
+	copy(sorted, existing)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Start.Before(sorted[j].Start)
+	})
+
+	var gaps []partition
+	start := p.Start
+	for _, e := range sorted {
+		if !start.Before(p.End) {
+			break
+		}
+		if !e.End.After(start) || !e.Start.Before(p.End) {
+			continue
+		}
+		if e.Start.After(start) {
+			gaps = append(gaps, newPartition(start, e.Start))
+		}
+		start = e.End
+	}
+	if start.Before(p.End) {
+		gaps = append(gaps, newPartition(start, p.End))
+	}
+
+	return gaps
+}
+
+// planPartitions возвращает секции, которые нужно создать и удалить на момент now:
+// создаются текущая и partitionsAhead следующих секций ширины width, а если часть
+// их промежутка уже занята существующими секциями (например, другой ширины) -
+// секции на незанятые части. Удаляются секции, целиком старше retention.
+func planPartitions(existing []partition, now time.Time, width, retention time.Duration) (create, drop []partition) {
+	start := now.UTC().Truncate(width)
+	for i := 0; i <= partitionsAhead; i++ {
+		p := newPartition(start, start.Add(width))
+		start = p.End
+
+		create = append(create, p.uncovered(existing)...)
+	}
+
+	cutoff := now.Add(-retention)
+	for _, e := range existing {
+		if !e.End.After(cutoff) {
+			drop = append(drop, e)
+		}
+	}
+
+	return create, drop
+}
+
+// RunPartitions обслуживает секции metric_samples каждые interval, пока не отменен ctx.
+func (r *repository) RunPartitions(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := r.MaintainPartitions(ctx, now); err != nil {
+				r.logger.Errorf("maintain sample partitions: %s", err.Error())
+			}
+		}
+	}
+}
+
+// MaintainPartitions создает секции metric_samples на ближайшее время и удаляет
+// секции старше HistoryRetention, а также устаревшие строки секции по умолчанию.
+// Обслуживание выполняется в одной транзакции под advisory lock, поэтому
+// несколько серверов с одной базой не мешают друг другу.
+func (r *repository) MaintainPartitions(ctx context.Context, now time.Time) error {
+	width := r.cfg.PostgresStorage.SamplePartition
+	if width <= 0 {
+		width = defaultPartitionWidth
+	}
+
+	tx, err := r.client.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext($1));`, samplesTable)
+	if err != nil {
+		return err
+	}
+
+	existing, err := listPartitions(ctx, tx)
+	if err != nil {
+		return err
+	}
+
+	create, drop := planPartitions(existing, now, width, r.cfg.Settings.HistoryRetention)
+	for _, p := range create {
+		err = createPartition(ctx, tx, p)
+		if err != nil {
+			return fmt.Errorf("create partition %s: %w", p.Name, err)
+		}
+	}
+	for _, p := range drop {
+		_, err = tx.Exec(ctx, `DROP TABLE `+pgx.Identifier{p.Name}.Sanitize()+`;`)
+		if err != nil {
+			return fmt.Errorf("drop partition %s: %w", p.Name, err)
+		}
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM `+samplesDefault+` WHERE ts < $1;`,
+		now.Add(-r.cfg.Settings.HistoryRetention))
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return err
+	}
+
+	r.logger.Tracef("sample partitions: %d created, %d dropped", len(create), len(drop))
+	return nil
+}
+
+// listPartitions возвращает секции metric_samples, кроме секции по умолчанию.
+func listPartitions(ctx context.Context, tx pgx.Tx) ([]partition, error) {
+	q := `
+		SELECT
+			c.relname
+		FROM
+			pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE
+			i.inhparent = 'public.metric_samples'::regclass;
+	`
+
+	rows, err := tx.Query(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var partitions []partition
+	for rows.Next() {
+		var name string
+		err = rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+
+		p, ok := parsePartition(name)
+		if !ok {
+			continue
+		}
+		partitions = append(partitions, p)
+	}
+
+	return partitions, rows.Err()
+}
+
+// createPartition создает секцию и переносит в нее строки из секции по умолчанию,
+// иначе подключение секции завершилось бы ошибкой.
+func createPartition(ctx context.Context, tx pgx.Tx, p partition) error {
+	name := pgx.Identifier{p.Name}.Sanitize()
+	start := p.Start.Format(time.RFC3339)
+	end := p.End.Format(time.RFC3339)
+
+	queries := []string{
+		`CREATE TABLE ` + name + ` (LIKE ` + samplesTable + ` INCLUDING DEFAULTS);`,
+		fmt.Sprintf(`INSERT INTO %s SELECT * FROM %s WHERE ts >= '%s' AND ts < '%s';`, name, samplesDefault, start, end),
+		fmt.Sprintf(`DELETE FROM %s WHERE ts >= '%s' AND ts < '%s';`, samplesDefault, start, end),
+		fmt.Sprintf(`ALTER TABLE %s ATTACH PARTITION %s FOR VALUES FROM ('%s') TO ('%s');`, samplesTable, name, start, end),
+	}
+	for _, q := range queries {
+		_, err := tx.Exec(ctx, q)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
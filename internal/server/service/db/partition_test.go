@@ -0,0 +1,64 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePartition(t *testing.T) {
+	assert := assert.New(t)
+
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	p := newPartition(start, start.Add(24*time.Hour))
+	assert.Equal("metric_samples_202403010000_202403020000", p.Name)
+
+	parsed, ok := parsePartition(p.Name)
+	assert.True(ok)
+	assert.Equal(p, parsed)
+
+	for _, name := range []string{
+		"metric_samples_default",
+		"metric_samples_202403020000_202403010000",
+		"metrics_202403010000_202403020000",
+		"metric_samples_202403010000",
+	} {
+		_, ok = parsePartition(name)
+		assert.False(ok, name)
+	}
+}
+
+func TestPlanPartitions(t *testing.T) {
+	assert := assert.New(t)
+
+	day := 24 * time.Hour
+	now := time.Date(2024, 3, 10, 15, 30, 0, 0, time.UTC)
+	today := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+
+	existing := []partition{
+		newPartition(today.Add(-3*day), today.Add(-2*day)),
+		newPartition(today.Add(-2*day), today.Add(-day)),
+		newPartition(today.Add(-day), today),
+		newPartition(today, today.Add(day)),
+		// секции другой ширины занимают начало завтрашнего дня и середину послезавтрашнего
+		newPartition(today.Add(day), today.Add(day+time.Hour)),
+		newPartition(today.Add(2*day+6*time.Hour), today.Add(2*day+12*time.Hour)),
+	}
+
+	create, drop := planPartitions(existing, now, day, 36*time.Hour)
+
+	assert.Equal([]partition{
+		newPartition(today.Add(day+time.Hour), today.Add(2*day)),
+		newPartition(today.Add(2*day), today.Add(2*day+6*time.Hour)),
+		newPartition(today.Add(2*day+12*time.Hour), today.Add(3*day)),
+	}, create)
+	assert.Equal(existing[:2], drop)
+
+	create, drop = planPartitions(nil, now, time.Hour, time.Hour)
+	if assert.Len(create, 3) {
+		assert.Equal(time.Date(2024, 3, 10, 15, 0, 0, 0, time.UTC), create[0].Start)
+		assert.Equal(time.Date(2024, 3, 10, 18, 0, 0, 0, time.UTC), create[2].End)
+	}
+	assert.Empty(drop)
+}
@@ -0,0 +1,282 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/nickzhog/devops-tool/pkg/metric"
+)
+
+const (
+	// rollupMaxSpan ограничивает промежуток, агрегируемый за один проход,
+	// чтобы догоняющая агрегация после простоя не выполнялась одной огромной транзакцией.
+	rollupMaxSpan = 24 * time.Hour
+	// rollupDelay - задержка агрегации metric_samples, чтобы интервал не агрегировался
+	// раньше, чем завершатся транзакции, записывающие в него значения.
+	rollupDelay = 10 * time.Second
+)
+
+// resolution - разрешение агрегатов истории.
+type resolution struct {
+	name      string
+	width     time.Duration
+	retention time.Duration
+}
+
+// resolutions возвращает включенные разрешения агрегатов от мелкого к крупному.
+func (r *repository) resolutions() []resolution {
+	all := []resolution{
+		{name: "1m", width: time.Minute, retention: r.cfg.PostgresStorage.RollupMinuteRetention},
+		{name: "1h", width: time.Hour, retention: r.cfg.PostgresStorage.RollupHourRetention},
+	}
+
+	result := make([]resolution, 0, len(all))
+	for _, res := range all {
+		if res.retention > 0 {
+			result = append(result, res)
+		}
+	}
+	return result
+}
+
+// pickResolution возвращает самое крупное разрешение, не превышающее step.
+// Агрегаты есть только у gauge и counter, для остальных типов и step <= 0 читаются исходные значения.
+func pickResolution(resolutions []resolution, mtype string, step time.Duration) (resolution, bool) {
+	if mtype != metric.GaugeType && mtype != metric.CounterType {
+		return resolution{}, false
+	}
+
+	for i := len(resolutions) - 1; i >= 0; i-- {
+		if resolutions[i].width <= step {
+			return resolutions[i], true
+		}
+	}
+	return resolution{}, false
+}
+
+// rollupWindow возвращает промежуток [from, to), который нужно агрегировать:
+// от конца уже агрегированного done до последнего завершенного интервала источника sourceDone,
+// не раньше начала хранения разрешения и источника sourceFrom и не больше rollupMaxSpan.
+func rollupWindow(res resolution, done, sourceFrom, sourceDone, now time.Time) (from, to time.Time) {
+	from = done
+	if oldest := now.Add(-res.retention).Truncate(res.width); from.Before(oldest) {
+		from = oldest
+	}
+	if oldest := sourceFrom.Truncate(res.width); from.Before(oldest) {
+		from = oldest
+	}
+
+	to = sourceDone.Truncate(res.width)
+	if limit := from.Add(rollupMaxSpan); to.After(limit) {
+		to = limit
+	}
+	return from, to
+}
+
+// RunRollups агрегирует историю каждые interval, пока не отменен ctx.
+func (r *repository) RunRollups(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := r.Rollup(ctx, now); err != nil {
+				r.logger.Errorf("rollup history: %s", err.Error())
+			}
+		}
+	}
+}
+
+// Rollup агрегирует завершенные интервалы истории для каждого разрешения
+// и удаляет агрегаты старше срока хранения разрешения.
+// Минутные агрегаты строятся по metric_samples, более крупные - по агрегатам предыдущего разрешения.
+// Граница агрегированного хранится в metric_rollup_state, поэтому каждый интервал агрегируется один раз.
+func (r *repository) Rollup(ctx context.Context, now time.Time) error {
+	tx, err := r.client.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('metric_rollups'));`)
+	if err != nil {
+		return err
+	}
+
+	var source *resolution
+	sourceFrom := now.Add(-r.cfg.Settings.HistoryRetention)
+	sourceDone := now.Add(-rollupDelay)
+	for _, res := range r.resolutions() {
+		res := res
+		sourceDone, err = r.rollup(ctx, tx, res, source, sourceFrom, sourceDone, now)
+		if err != nil {
+			return err
+		}
+		source = &res
+		sourceFrom = now.Add(-res.retention)
+	}
+
+	return tx.Commit(ctx)
+}
+
+// rollup агрегирует очередной промежуток разрешения res по источнику source
+// (nil - metric_samples) и возвращает новую границу агрегированного.
+func (r *repository) rollup(ctx context.Context, tx pgx.Tx, res resolution, source *resolution, sourceFrom, sourceDone, now time.Time) (time.Time, error) {
+	var done sql.NullTime
+	err := tx.QueryRow(ctx, `SELECT done FROM metric_rollup_state WHERE resolution = $1;`, res.name).Scan(&done)
+	if err != nil && err != pgx.ErrNoRows {
+		return time.Time{}, err
+	}
+
+	from, to := rollupWindow(res, done.Time, sourceFrom, sourceDone, now)
+	if to.After(from) {
+		if source == nil {
+			_, err = tx.Exec(ctx, rollupSamplesQuery, from, to, res.width.Seconds(), res.name)
+		} else {
+			_, err = tx.Exec(ctx, rollupRollupsQuery, from, to, res.width.Seconds(), res.name, source.name)
+		}
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		q := `
+		INSERT INTO metric_rollup_state (resolution, done) VALUES ($1, $2)
+		ON CONFLICT (resolution) DO UPDATE SET done = $2;
+		`
+		_, err = tx.Exec(ctx, q, res.name, to)
+		if err != nil {
+			return time.Time{}, err
+		}
+		done.Time = to
+
+		r.logger.Tracef("rollup %s: %s - %s", res.name, from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM metric_rollups WHERE resolution = $1 AND ts < $2;`,
+		res.name, now.Add(-res.retention))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return done.Time, nil
+}
+
+// rollupSamplesQuery агрегирует metric_samples за [$1, $2) в интервалы длиной $3 секунд разрешения $4.
+// Прирост counter считается между соседними значениями, уменьшение значения считается сбросом счетчика.
+// Предыдущим значением для первого значения в промежутке служит total последнего агрегата разрешения $4
+// до начала промежутка, поэтому прирост не теряется, как бы редко ни обновлялся счетчик.
+const rollupSamplesQuery = `
+	INSERT INTO metric_rollups
+		(resolution, id, type, labels, ts, count, min, max, avg, last, sum, rate, total)
+	SELECT
+		$4, id, type, labels, bucket, count(*),
+		min(value), max(value), avg(value), (array_agg(value ORDER BY ts DESC))[1],
+		sum(increase), sum(increase) / $3::double precision, (array_agg(delta ORDER BY ts DESC))[1]
+	FROM (
+		SELECT
+			id, type, labels, ts, value, delta,
+			to_timestamp(floor(extract(epoch FROM ts) / $3::double precision) * $3::double precision) AS bucket,
+			CASE
+				WHEN delta IS NULL THEN NULL
+				WHEN prev IS NULL THEN 0
+				WHEN delta < prev THEN delta
+				ELSE delta - prev
+			END AS increase
+		FROM (
+			SELECT
+				id, type, labels, ts, value, delta,
+				CASE WHEN delta IS NOT NULL THEN coalesce(
+					lag(delta) OVER (PARTITION BY id, type, labels ORDER BY ts),
+					(
+						SELECT rollups.total
+						FROM metric_rollups AS rollups
+						WHERE rollups.resolution = $4 AND rollups.id = s.id AND rollups.type = s.type
+							AND rollups.labels = s.labels AND rollups.ts < $1
+						ORDER BY rollups.ts DESC
+						LIMIT 1
+					)
+				) END AS prev
+			FROM metric_samples AS s
+			WHERE type IN ('gauge', 'counter') AND ts >= $1 AND ts < $2
+		) AS raw
+	) AS samples
+	GROUP BY id, type, labels, bucket
+	ON CONFLICT (resolution, id, type, labels, ts) DO UPDATE
+	SET count=excluded.count, min=excluded.min, max=excluded.max, avg=excluded.avg, last=excluded.last,
+		sum=excluded.sum, rate=excluded.rate, total=excluded.total;
+`
+
+// rollupRollupsQuery агрегирует агрегаты разрешения $5 за [$1, $2) в интервалы длиной $3 секунд разрешения $4.
+const rollupRollupsQuery = `
+	INSERT INTO metric_rollups
+		(resolution, id, type, labels, ts, count, min, max, avg, last, sum, rate, total)
+	SELECT
+		$4, id, type, labels,
+		to_timestamp(floor(extract(epoch FROM ts) / $3::double precision) * $3::double precision) AS bucket,
+		sum(count), min(min), max(max), sum(avg * count) / sum(count), (array_agg(last ORDER BY ts DESC))[1],
+		sum(sum), sum(sum) / $3::double precision, (array_agg(total ORDER BY ts DESC))[1]
+	FROM metric_rollups
+	WHERE resolution = $5 AND ts >= $1 AND ts < $2
+	GROUP BY id, type, labels, bucket
+	ON CONFLICT (resolution, id, type, labels, ts) DO UPDATE
+	SET count=excluded.count, min=excluded.min, max=excluded.max, avg=excluded.avg, last=excluded.last,
+		sum=excluded.sum, rate=excluded.rate, total=excluded.total;
+`
+
+// findRollups возвращает агрегаты разрешения res за [from, to] в виде точек истории
+// и конец последнего найденного интервала.
+func (r *repository) findRollups(ctx context.Context, res resolution, name, mtype string, labels metric.Labels, from, to time.Time) ([]metric.Sample, time.Time, error) {
+	q := `
+		SELECT
+			ts, count, min, max, avg, last, sum, rate, total
+		FROM
+			public.metric_rollups
+		WHERE
+			resolution = $1 AND type = $2 AND id = $3 AND labels = $4::jsonb AND ts BETWEEN $5 AND $6
+		ORDER BY ts;
+	`
+
+	rows, err := r.client.Query(ctx, q, res.name, mtype, name, encodeLabels(labels), from.Truncate(res.width), to)
+	if err != nil {
+		r.logger.Errorf("rollups find err:%s", err.Error())
+		return nil, time.Time{}, err
+	}
+	defer rows.Close()
+
+	samples := make([]metric.Sample, 0)
+	var end time.Time
+	for rows.Next() {
+		var s metric.Sample
+		s.Rollup = &metric.Rollup{Seconds: int64(res.width.Seconds())}
+
+		var min, max, avg, last, rate sql.NullFloat64
+		var sum, total sql.NullInt64
+		err = rows.Scan(&s.Timestamp, &s.Rollup.Count, &min, &max, &avg, &last, &sum, &rate, &total)
+		if err != nil {
+			r.logger.Errorf("rollups parse:%s", err.Error())
+			return nil, time.Time{}, err
+		}
+
+		if min.Valid && max.Valid && avg.Valid && last.Valid {
+			s.Rollup.Min, s.Rollup.Max, s.Rollup.Avg = &min.Float64, &max.Float64, &avg.Float64
+			s.Value = &last.Float64
+		}
+		if sum.Valid && rate.Valid && total.Valid {
+			s.Rollup.Sum, s.Rollup.Rate = &sum.Int64, &rate.Float64
+			s.Delta = &total.Int64
+		}
+
+		samples = append(samples, s)
+		end = s.Timestamp.Add(res.width)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, time.Time{}, err
+	}
+
+	return samples, end, nil
+}
@@ -0,0 +1,179 @@
+package db
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nickzhog/devops-tool/internal/server/config"
+	"github.com/nickzhog/devops-tool/migration"
+	"github.com/nickzhog/devops-tool/pkg/logging"
+	"github.com/nickzhog/devops-tool/pkg/metric"
+	"github.com/nickzhog/devops-tool/pkg/postgres"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPickResolution(t *testing.T) {
+	minute := resolution{name: "1m", width: time.Minute}
+	hour := resolution{name: "1h", width: time.Hour}
+	resolutions := []resolution{minute, hour}
+
+	tests := []struct {
+		name        string
+		resolutions []resolution
+		mtype       string
+		step        time.Duration
+		want        resolution
+		wantOk      bool
+	}{
+		{name: "raw", resolutions: resolutions, mtype: metric.GaugeType, step: 0},
+		{name: "step below minute", resolutions: resolutions, mtype: metric.GaugeType, step: 30 * time.Second},
+		{name: "minute", resolutions: resolutions, mtype: metric.CounterType, step: 5 * time.Minute, want: minute, wantOk: true},
+		{name: "hour", resolutions: resolutions, mtype: metric.GaugeType, step: time.Hour, want: hour, wantOk: true},
+		{name: "day", resolutions: resolutions, mtype: metric.GaugeType, step: 24 * time.Hour, want: hour, wantOk: true},
+		{name: "hour disabled", resolutions: []resolution{minute}, mtype: metric.GaugeType, step: 24 * time.Hour, want: minute, wantOk: true},
+		{name: "histogram", resolutions: resolutions, mtype: metric.HistogramType, step: time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := pickResolution(tt.resolutions, tt.mtype, tt.step)
+			assert.Equal(t, tt.wantOk, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRollupWindow(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Date(2024, 3, 10, 15, 30, 20, 0, time.UTC)
+	res := resolution{name: "1m", width: time.Minute, retention: 7 * 24 * time.Hour}
+
+	// продолжение с сохраненной границы до последней завершенной минуты
+	done := time.Date(2024, 3, 10, 15, 20, 0, 0, time.UTC)
+	from, to := rollupWindow(res, done, now.Add(-time.Hour), now, now)
+	assert.Equal(done, from)
+	assert.Equal(time.Date(2024, 3, 10, 15, 30, 0, 0, time.UTC), to)
+
+	// первый проход начинается с начала хранения источника
+	from, to = rollupWindow(res, time.Time{}, now.Add(-time.Hour), now, now)
+	assert.Equal(time.Date(2024, 3, 10, 14, 30, 0, 0, time.UTC), from)
+	assert.Equal(time.Date(2024, 3, 10, 15, 30, 0, 0, time.UTC), to)
+
+	// после долгого простоя агрегируется не больше rollupMaxSpan за проход
+	from, to = rollupWindow(res, time.Time{}, time.Time{}, now, now)
+	assert.Equal(time.Date(2024, 3, 3, 15, 30, 0, 0, time.UTC), from)
+	assert.Equal(from.Add(rollupMaxSpan), to)
+
+	// крупное разрешение ждет агрегатов источника
+	hour := resolution{name: "1h", width: time.Hour, retention: 90 * 24 * time.Hour}
+	done = time.Date(2024, 3, 10, 15, 0, 0, 0, time.UTC)
+	from, to = rollupWindow(hour, done, now.Add(-res.retention), time.Date(2024, 3, 10, 15, 30, 0, 0, time.UTC), now)
+	assert.Equal(done, from)
+	assert.Equal(done, to)
+}
+
+// TestRepository_Rollup проверяет агрегацию metric_samples в минутные агрегаты
+// и минутных агрегатов в часовые. Требует базу PostgreSQL в переменной окружения
+// TEST_DATABASE_DSN, состояние агрегации в ней сбрасывается.
+func TestRepository_Rollup(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	require := require.New(t)
+	assert := assert.New(t)
+
+	require.NoError(migration.Migrate(dsn))
+
+	ctx := context.Background()
+	client, err := postgres.NewClient(ctx, 1, dsn)
+	require.NoError(err)
+
+	cfg := new(config.Config)
+	cfg.Settings.HistoryRetention = 24 * time.Hour
+	cfg.PostgresStorage.RollupMinuteRetention = 24 * time.Hour
+	cfg.PostgresStorage.RollupHourRetention = 7 * 24 * time.Hour
+	repo := NewRepository(client, logging.GetLogger(), cfg)
+
+	cleanup := func() {
+		_, err := client.Exec(ctx, `TRUNCATE metric_rollups, metric_rollup_state;`)
+		require.NoError(err)
+		_, err = client.Exec(ctx, `DELETE FROM metric_samples WHERE id LIKE 'rollup_test_%';`)
+		require.NoError(err)
+	}
+	cleanup()
+	t.Cleanup(cleanup)
+
+	at := func(hour, min, sec int) time.Time {
+		return time.Date(2024, 3, 10, hour, min, sec, 0, time.UTC)
+	}
+	insert := func(id, mtype string, ts time.Time, value interface{}) {
+		column := "value"
+		if mtype == metric.CounterType {
+			column = "delta"
+		}
+		_, err := client.Exec(ctx,
+			`INSERT INTO metric_samples (id, type, ts, `+column+`) VALUES ($1, $2, $3, $4);`,
+			id, mtype, ts, value)
+		require.NoError(err)
+	}
+
+	insert("rollup_test_counter", metric.CounterType, at(10, 0, 10), 100)
+	insert("rollup_test_counter", metric.CounterType, at(10, 0, 40), 130)
+	insert("rollup_test_gauge", metric.GaugeType, at(10, 0, 10), 1.0)
+	insert("rollup_test_gauge", metric.GaugeType, at(10, 0, 40), 3.0)
+
+	// первый проход агрегирует минуту 10:00, час 10:00 еще не завершен
+	require.NoError(repo.Rollup(ctx, at(10, 20, 30)))
+
+	// счетчик обновляется реже, чем раз в 10 минут, затем сбрасывается
+	insert("rollup_test_counter", metric.CounterType, at(10, 30, 0), 200)
+	insert("rollup_test_counter", metric.CounterType, at(11, 10, 0), 150)
+	insert("rollup_test_gauge", metric.GaugeType, at(10, 30, 0), 5.0)
+
+	require.NoError(repo.Rollup(ctx, at(12, 0, 30)))
+
+	minute, hour := repo.resolutions()[0], repo.resolutions()[1]
+
+	rollups, end, err := repo.findRollups(ctx, minute, "rollup_test_counter", metric.CounterType, nil, at(10, 0, 0), at(12, 0, 0))
+	require.NoError(err)
+	require.Len(rollups, 3)
+	assert.Equal(at(11, 11, 0), end)
+	// прирост первого значения после перерыва считается от total предыдущего агрегата
+	for i, want := range []struct {
+		ts         time.Time
+		count, sum int64
+		total      int64
+	}{
+		{ts: at(10, 0, 0), count: 2, sum: 30, total: 130},
+		{ts: at(10, 30, 0), count: 1, sum: 70, total: 200},
+		{ts: at(11, 10, 0), count: 1, sum: 150, total: 150},
+	} {
+		assert.True(want.ts.Equal(rollups[i].Timestamp), rollups[i].Timestamp)
+		assert.Equal(want.count, rollups[i].Rollup.Count)
+		assert.Equal(want.sum, *rollups[i].Rollup.Sum)
+		assert.Equal(want.total, *rollups[i].Delta)
+	}
+
+	rollups, _, err = repo.findRollups(ctx, hour, "rollup_test_counter", metric.CounterType, nil, at(10, 0, 0), at(12, 0, 0))
+	require.NoError(err)
+	require.Len(rollups, 2)
+	assert.Equal(int64(3), rollups[0].Rollup.Count)
+	assert.Equal(int64(100), *rollups[0].Rollup.Sum)
+	assert.InDelta(100.0/3600, *rollups[0].Rollup.Rate, 1e-9)
+	assert.Equal(int64(200), *rollups[0].Delta)
+	assert.Equal(int64(150), *rollups[1].Rollup.Sum)
+
+	rollups, _, err = repo.findRollups(ctx, hour, "rollup_test_gauge", metric.GaugeType, nil, at(10, 0, 0), at(12, 0, 0))
+	require.NoError(err)
+	require.Len(rollups, 1)
+	assert.Equal(int64(3), rollups[0].Rollup.Count)
+	assert.Equal(1.0, *rollups[0].Rollup.Min)
+	assert.Equal(5.0, *rollups[0].Rollup.Max)
+	assert.InDelta(3.0, *rollups[0].Rollup.Avg, 1e-9)
+	assert.Equal(5.0, *rollups[0].Value)
+}
@@ -1,9 +1,178 @@
 package redis
 
 import (
+	"encoding/json"
 	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/nickzhog/devops-tool/pkg/metric"
+)
+
+// Поля hash метрики, общие для всех типов.
+const (
+	fieldID       = "id"
+	fieldType     = "type"
+	fieldLabels   = "labels"    // labels в формате "k1=v1,k2=v2"
+	fieldSource   = "source"    // идентификатор агента
+	fieldLastSeen = "last_seen" // время последнего обновления от агента, RFC 3339
+)
+
+// Поля значения по типам метрик.
+const (
+	fieldValue     = "value"     // gauge
+	fieldDelta     = "delta"     // counter, целое для HINCRBY
+	fieldCount     = "count"     // histogram и summary, количество наблюдений
+	fieldSum       = "sum"       // histogram и summary, сумма наблюдений
+	fieldBuckets   = "buckets"   // histogram, границы корзин в JSON
+	fieldBucket    = "bucket:"   // histogram, префикс количества наблюдений в корзине i, "bucket:0"
+	fieldQuantiles = "quantiles" // summary, квантили в JSON
 )
 
-func prepareKey(id, mtype string) string {
-	return fmt.Sprintf("metric:%s_%s", id, mtype)
+// Схема ключей: metric:<type>:<series> для метрики и history:<type>:<series> для ее истории,
+// где series - metric.SeriesKey, например metric:gauge:Alloc{host=node1}.
+func prepareKey(id, mtype string, labels metric.Labels) string {
+	return fmt.Sprintf("metric:%s:%s", mtype, metric.SeriesKey(id, labels))
+}
+
+func prepareHistoryKey(id, mtype string, labels metric.Labels) string {
+	return fmt.Sprintf("history:%s:%s", mtype, metric.SeriesKey(id, labels))
+}
+
+// typePattern возвращает шаблон SCAN для ключей метрик типа mtype.
+func typePattern(mtype string) string {
+	return "metric:" + mtype + ":*"
+}
+
+// metaFields возвращает поля hash, описывающие метрику, без значения.
+func metaFields(m metric.Metric) map[string]interface{} {
+	fields := map[string]interface{}{
+		fieldID:     m.ID,
+		fieldType:   m.MType,
+		fieldLabels: m.Labels.String(),
+	}
+	if m.Source != "" && m.LastSeen != nil {
+		fields[fieldSource] = m.Source
+		fields[fieldLastSeen] = m.LastSeen.Format(time.RFC3339Nano)
+	}
+	return fields
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// histogramArgs возвращает аргументы скрипта mergeHistogram:
+// границы корзин в JSON, количество и сумма наблюдений, количества по корзинам.
+func histogramArgs(h *metric.Histogram) []interface{} {
+	buckets, _ := json.Marshal(h.Buckets)
+	args := make([]interface{}, 0, 3+len(h.Counts))
+	args = append(args, buckets, h.Count, formatFloat(h.Sum))
+	for _, c := range h.Counts {
+		args = append(args, c)
+	}
+	return args
+}
+
+// decodeMetric восстанавливает метрику из полей hash.
+func decodeMetric(fields map[string]string) (metric.Metric, error) {
+	m, err := decodeMeta(fields)
+	if err != nil {
+		return metric.Metric{}, err
+	}
+
+	switch m.MType {
+	case metric.GaugeType:
+		value, err := strconv.ParseFloat(fields[fieldValue], 64)
+		if err != nil {
+			return metric.Metric{}, fmt.Errorf("%s: %w", fieldValue, err)
+		}
+		m.Value = &value
+	case metric.CounterType:
+		delta, err := strconv.ParseInt(fields[fieldDelta], 10, 64)
+		if err != nil {
+			return metric.Metric{}, fmt.Errorf("%s: %w", fieldDelta, err)
+		}
+		m.Delta = &delta
+	case metric.HistogramType:
+		m.Histogram, err = decodeHistogram(fields)
+	case metric.SummaryType:
+		m.Summary, err = decodeSummary(fields)
+	default:
+		err = metric.ErrWrongType
+	}
+	if err != nil {
+		return metric.Metric{}, err
+	}
+
+	return m, nil
+}
+
+// decodeMeta восстанавливает метрику без значения из общих полей hash.
+func decodeMeta(fields map[string]string) (metric.Metric, error) {
+	labels, err := metric.ParseLabels(fields[fieldLabels])
+	if err != nil {
+		return metric.Metric{}, err
+	}
+	m := metric.Metric{
+		ID:     fields[fieldID],
+		MType:  fields[fieldType],
+		Labels: labels,
+		Source: fields[fieldSource],
+	}
+
+	if v, ok := fields[fieldLastSeen]; ok {
+		lastSeen, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return metric.Metric{}, fmt.Errorf("%s: %w", fieldLastSeen, err)
+		}
+		m.LastSeen = &lastSeen
+	}
+
+	return m, nil
+}
+
+func decodeHistogram(fields map[string]string) (*metric.Histogram, error) {
+	h := new(metric.Histogram)
+	if err := json.Unmarshal([]byte(fields[fieldBuckets]), &h.Buckets); err != nil {
+		return nil, fmt.Errorf("%s: %w", fieldBuckets, err)
+	}
+
+	var err error
+	if h.Count, err = strconv.ParseUint(fields[fieldCount], 10, 64); err != nil {
+		return nil, fmt.Errorf("%s: %w", fieldCount, err)
+	}
+	if h.Sum, err = strconv.ParseFloat(fields[fieldSum], 64); err != nil {
+		return nil, fmt.Errorf("%s: %w", fieldSum, err)
+	}
+
+	h.Counts = make([]uint64, len(h.Buckets)+1)
+	for i := range h.Counts {
+		v, ok := fields[fieldBucket+strconv.Itoa(i)]
+		if !ok {
+			continue
+		}
+		if h.Counts[i], err = strconv.ParseUint(v, 10, 64); err != nil {
+			return nil, fmt.Errorf("%s%d: %w", fieldBucket, i, err)
+		}
+	}
+
+	return h, nil
+}
+
+func decodeSummary(fields map[string]string) (*metric.Summary, error) {
+	s := new(metric.Summary)
+	if err := json.Unmarshal([]byte(fields[fieldQuantiles]), &s.Quantiles); err != nil {
+		return nil, fmt.Errorf("%s: %w", fieldQuantiles, err)
+	}
+
+	var err error
+	if s.Count, err = strconv.ParseUint(fields[fieldCount], 10, 64); err != nil {
+		return nil, fmt.Errorf("%s: %w", fieldCount, err)
+	}
+	if s.Sum, err = strconv.ParseFloat(fields[fieldSum], 64); err != nil {
+		return nil, fmt.Errorf("%s: %w", fieldSum, err)
+	}
+
+	return s, nil
 }
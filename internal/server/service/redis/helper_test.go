@@ -0,0 +1,97 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nickzhog/devops-tool/pkg/metric"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeMetric(t *testing.T) {
+	lastSeen := time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)
+	gauge := metric.NewGaugeMetric("Alloc", 1.5)
+	gauge.Labels = metric.Labels{"host": "node1"}
+	gauge.Source = "node1"
+	gauge.LastSeen = &lastSeen
+
+	tests := []struct {
+		name   string
+		fields map[string]string
+		want   metric.Metric
+	}{
+		{
+			name: "gauge",
+			fields: map[string]string{
+				"id": "Alloc", "type": "gauge", "labels": "host=node1", "value": "1.5",
+				"source": "node1", "last_seen": "2023-01-01T10:00:00Z",
+			},
+			want: gauge,
+		},
+		{
+			name:   "counter",
+			fields: map[string]string{"id": "PollCount", "type": "counter", "labels": "", "delta": "42"},
+			want:   metric.NewCounterMetric("PollCount", 42),
+		},
+		{
+			name: "histogram",
+			fields: map[string]string{
+				"id": "Latency", "type": "histogram", "buckets": "[0.1,1]",
+				"count": "4", "sum": "2.5", "bucket:0": "1", "bucket:2": "3",
+			},
+			want: metric.Metric{ID: "Latency", MType: metric.HistogramType, Histogram: &metric.Histogram{
+				Buckets: []float64{0.1, 1}, Counts: []uint64{1, 0, 3}, Count: 4, Sum: 2.5,
+			}},
+		},
+		{
+			name: "summary",
+			fields: map[string]string{
+				"id": "Latency", "type": "summary", "quantiles": `[{"quantile":0.5,"value":0.2}]`,
+				"count": "10", "sum": "3",
+			},
+			want: metric.Metric{ID: "Latency", MType: metric.SummaryType, Summary: &metric.Summary{
+				Quantiles: []metric.Quantile{{Quantile: 0.5, Value: 0.2}}, Count: 10, Sum: 3,
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decodeMetric(tt.fields)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err := decodeMetric(map[string]string{"id": "Alloc", "type": "gauge", "value": "x"})
+	assert.Error(t, err)
+	_, err = decodeMetric(map[string]string{"id": "Alloc", "type": "unknown"})
+	assert.ErrorIs(t, err, metric.ErrWrongType)
+}
+
+func TestHistogramArgs(t *testing.T) {
+	h := &metric.Histogram{Buckets: []float64{0.1, 1}, Counts: []uint64{1, 0, 3}, Count: 4, Sum: 2.5}
+	assert.Equal(t, []interface{}{[]byte("[0.1,1]"), uint64(4), "2.5", uint64(1), uint64(0), uint64(3)}, histogramArgs(h))
+}
+
+func TestLegacyHistoryKey(t *testing.T) {
+	tests := []struct {
+		key    string
+		want   string
+		wantOK bool
+	}{
+		{key: "history:Alloc_gauge", want: "history:gauge:Alloc", wantOK: true},
+		{key: "history:Alloc{host=node_1}_counter", want: "history:counter:Alloc{host=node_1}", wantOK: true},
+		{key: "history:gauge:Alloc", wantOK: false},
+		{key: "history:Alloc_unknown", wantOK: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			got, ok := legacyHistoryKey(tt.key)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	assert.Equal(t, "metric:gauge:Alloc{host=node1}", prepareKey("Alloc", metric.GaugeType, metric.Labels{"host": "node1"}))
+}
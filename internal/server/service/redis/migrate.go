@@ -0,0 +1,192 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/nickzhog/devops-tool/pkg/metric"
+	"github.com/redis/go-redis/v9"
+)
+
+// Поля hash прежнего формата.
+const (
+	legacyFieldHistogram = "histogram"
+	legacyFieldSummary   = "summary"
+)
+
+// MigrateStats - результат миграции.
+type MigrateStats struct {
+	Metrics int // перенесено метрик
+	History int // перенесено историй метрик
+	Failed  int // ключей, которые не удалось перенести
+}
+
+// Migrate переносит метрики и их историю из прежних форматов в текущую схему ключей:
+//   - metric:<series>_<type> со строкой JSON;
+//   - metric:<series>_<type> с hash, где гистограмма и summary хранятся в JSON;
+//   - history:<series>_<type> с историей метрики.
+//
+// Если метрика уже есть в новой схеме, counter и гистограммы складываются с ней,
+// а gauge и summary остаются новыми. Перенесенные ключи удаляются.
+// При dryRun ключи только подсчитываются.
+func (r *repository) Migrate(ctx context.Context, dryRun bool) (MigrateStats, error) {
+	var stats MigrateStats
+
+	err := r.scan(ctx, "metric:*", func(keys []string) error {
+		for _, key := range keys {
+			ok, err := r.migrateMetric(ctx, key, dryRun)
+			if err != nil {
+				r.logger.Errorf("migrate %s: %s", key, err.Error())
+				stats.Failed++
+				continue
+			}
+			if ok {
+				stats.Metrics++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	err = r.scan(ctx, "history:*", func(keys []string) error {
+		for _, key := range keys {
+			ok, err := r.migrateHistory(ctx, key, dryRun)
+			if err != nil {
+				r.logger.Errorf("migrate %s: %s", key, err.Error())
+				stats.Failed++
+				continue
+			}
+			if ok {
+				stats.History++
+			}
+		}
+		return nil
+	})
+
+	return stats, err
+}
+
+// migrateMetric переносит ключ метрики в прежнем формате.
+// Возвращает false для ключей, уже хранящихся в текущей схеме.
+func (r *repository) migrateMetric(ctx context.Context, key string, dryRun bool) (bool, error) {
+	m, current, err := r.readLegacyMetric(ctx, key)
+	if err != nil || current {
+		return false, err
+	}
+	if err = m.Validate(); err != nil {
+		return false, err
+	}
+
+	newKey := prepareKey(m.ID, m.MType, m.Labels)
+	r.logger.Tracef("migrate %s to %s", key, newKey)
+	if dryRun {
+		return true, nil
+	}
+
+	exists, err := r.client.Exists(ctx, newKey).Result()
+	if err != nil {
+		return false, err
+	}
+
+	m.Absolute = false
+	_, err = r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if exists == 0 || m.MType == metric.CounterType || m.MType == metric.HistogramType {
+			queueUpdate(ctx, pipe, m, r.cfg.RedisStorage.MetricTTL)
+		}
+		pipe.Del(ctx, key)
+		return nil
+	})
+
+	return err == nil, err
+}
+
+// readLegacyMetric читает метрику из строки JSON или hash.
+// current сообщает, что ключ уже хранится в текущей схеме.
+func (r *repository) readLegacyMetric(ctx context.Context, key string) (m metric.Metric, current bool, err error) {
+	kind, err := r.client.Type(ctx, key).Result()
+	if err != nil {
+		return m, false, err
+	}
+
+	switch kind {
+	case "string":
+		data, err := r.client.Get(ctx, key).Bytes()
+		if err != nil {
+			return m, false, err
+		}
+		err = json.Unmarshal(data, &m)
+		return m, false, err
+
+	case "hash":
+		fields, err := r.client.HGetAll(ctx, key).Result()
+		if err != nil {
+			return m, false, err
+		}
+		if m, err = decodeMeta(fields); err != nil {
+			return m, false, err
+		}
+		if prepareKey(m.ID, m.MType, m.Labels) == key {
+			return m, true, nil
+		}
+
+		// в прежнем формате hash гистограмма и summary хранятся в JSON
+		switch m.MType {
+		case metric.HistogramType:
+			m.Histogram = new(metric.Histogram)
+			err = json.Unmarshal([]byte(fields[legacyFieldHistogram]), m.Histogram)
+		case metric.SummaryType:
+			m.Summary = new(metric.Summary)
+			err = json.Unmarshal([]byte(fields[legacyFieldSummary]), m.Summary)
+		default:
+			m, err = decodeMetric(fields)
+		}
+		return m, false, err
+	}
+
+	return m, false, fmt.Errorf("unexpected key type %s", kind)
+}
+
+// migrateHistory переносит историю метрики из ключа history:<series>_<type>.
+// Если история уже есть в новой схеме, точки объединяются.
+func (r *repository) migrateHistory(ctx context.Context, key string, dryRun bool) (bool, error) {
+	newKey, ok := legacyHistoryKey(key)
+	if !ok {
+		return false, nil
+	}
+	r.logger.Tracef("migrate %s to %s", key, newKey)
+	if dryRun {
+		return true, nil
+	}
+
+	retention := r.cfg.Settings.HistoryRetention
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZUnionStore(ctx, newKey, &redis.ZStore{Keys: []string{newKey, key}, Aggregate: "MAX"})
+		pipe.Del(ctx, key)
+		if retention > 0 {
+			pipe.Expire(ctx, newKey, retention)
+		}
+		return nil
+	})
+
+	return err == nil, err
+}
+
+// legacyHistoryKey возвращает ключ истории в текущей схеме для ключа history:<series>_<type>.
+func legacyHistoryKey(key string) (string, bool) {
+	series := strings.TrimPrefix(key, "history:")
+	for _, mtype := range []string{metric.GaugeType, metric.CounterType, metric.HistogramType, metric.SummaryType} {
+		if strings.HasPrefix(series, mtype+":") {
+			return "", false
+		}
+	}
+
+	i := strings.LastIndexByte(series, '_')
+	if i < 0 || !metric.IsValidType(series[i+1:]) {
+		return "", false
+	}
+	return "history:" + series[i+1:] + ":" + series[:i], true
+}
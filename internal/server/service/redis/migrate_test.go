@@ -0,0 +1,82 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nickzhog/devops-tool/internal/server/config"
+	"github.com/nickzhog/devops-tool/pkg/metric"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepository_Migrate(t *testing.T) {
+	cfg := new(config.Config)
+	cfg.Settings.HistoryRetention = time.Hour
+	r, server := newTestRepository(t, cfg)
+	ctx := context.Background()
+
+	host := metric.Labels{"host": "node1"}
+
+	// строка JSON
+	require.NoError(t, server.Set("metric:Alloc_gauge", `{"id":"Alloc","type":"gauge","value":1.5}`))
+	// hash с гистограммой в JSON
+	server.HSet("metric:Latency{host=node1}_histogram",
+		"id", "Latency", "type", "histogram", "labels", "host=node1",
+		"histogram", `{"buckets":[1],"counts":[1,0],"count":1,"sum":0.5}`)
+	// hash с counter, который уже есть в новой схеме
+	server.HSet("metric:PollCount_counter", "id", "PollCount", "type", "counter", "labels", "", "delta", "5")
+	_, err := r.UpsertMetric(ctx, metric.NewCounterMetric("PollCount", 3))
+	require.NoError(t, err)
+	// история
+	_, err = server.ZAdd("history:PollCount_counter", 1000, `{"timestamp":"2023-01-01T10:00:00Z","delta":5}`)
+	require.NoError(t, err)
+
+	legacy := []string{
+		"metric:Alloc_gauge",
+		"metric:Latency{host=node1}_histogram",
+		"metric:PollCount_counter",
+		"history:PollCount_counter",
+	}
+
+	// при dryRun ключи только подсчитываются
+	stats, err := r.Migrate(ctx, true)
+	require.NoError(t, err)
+	assert.Equal(t, MigrateStats{Metrics: 3, History: 1}, stats)
+	for _, key := range legacy {
+		assert.True(t, server.Exists(key), key)
+	}
+	assert.False(t, server.Exists(prepareKey("Alloc", metric.GaugeType, nil)))
+
+	stats, err = r.Migrate(ctx, false)
+	require.NoError(t, err)
+	assert.Equal(t, MigrateStats{Metrics: 3, History: 1}, stats)
+	for _, key := range legacy {
+		assert.False(t, server.Exists(key), key)
+	}
+
+	m, err := r.FindMetric(ctx, "Alloc", metric.GaugeType, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1.5, *m.Value)
+
+	m, err = r.FindMetric(ctx, "PollCount", metric.CounterType, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), *m.Delta)
+
+	m, err = r.FindMetric(ctx, "Latency", metric.HistogramType, host)
+	require.NoError(t, err)
+	assert.Equal(t, &metric.Histogram{Buckets: []float64{1}, Counts: []uint64{1, 0}, Count: 1, Sum: 0.5}, m.Histogram)
+
+	historyKey := prepareHistoryKey("PollCount", metric.CounterType, nil)
+	members, err := server.ZMembers(historyKey)
+	require.NoError(t, err)
+	// точка прежней истории объединяется с точкой, записанной UpsertMetric
+	assert.Len(t, members, 2)
+	assert.Equal(t, time.Hour, server.TTL(historyKey))
+
+	// повторная миграция ничего не переносит
+	stats, err = r.Migrate(ctx, false)
+	require.NoError(t, err)
+	assert.Equal(t, MigrateStats{}, stats)
+}
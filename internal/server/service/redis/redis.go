@@ -3,6 +3,9 @@ package redis
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
 
 	"github.com/nickzhog/devops-tool/internal/server/config"
 	"github.com/nickzhog/devops-tool/internal/server/service"
@@ -13,6 +16,36 @@ import (
 
 var _ service.Storage = (*repository)(nil)
 
+// scanCount - размер страницы SCAN.
+const scanCount = 1000
+
+// mergeHistogram атомарно добавляет наблюдения гистограммы к сохраненным.
+// Если границы корзин изменились или гистограммы нет, накопленные значения сбрасываются.
+//
+// KEYS[1] - ключ метрики, ARGV[1] - границы корзин в JSON, ARGV[2] - количество,
+// ARGV[3] - сумма наблюдений, ARGV[4..] - количества наблюдений по корзинам.
+var mergeHistogram = redis.NewScript(`
+local key = KEYS[1]
+if redis.call('HGET', key, 'buckets') ~= ARGV[1] then
+	for _, field in ipairs(redis.call('HKEYS', key)) do
+		if string.sub(field, 1, 7) == 'bucket:' then
+			redis.call('HDEL', key, field)
+		end
+	end
+	redis.call('HSET', key, 'buckets', ARGV[1], 'count', 0, 'sum', 0)
+end
+redis.call('HINCRBY', key, 'count', ARGV[2])
+redis.call('HINCRBYFLOAT', key, 'sum', ARGV[3])
+for i = 4, #ARGV do
+	redis.call('HINCRBY', key, 'bucket:' .. (i - 4), ARGV[i])
+end
+return 1
+`)
+
+// repository хранит каждую метрику в hash с полями по ее типу (см. helper.go).
+// Counter обновляются HINCRBY, гистограммы - скриптом mergeHistogram, gauge и summary - HSET,
+// поэтому одновременные обновления не теряются. Если задан TTL, ключ метрики
+// удаляется, когда метрика не обновлялась дольше TTL.
 type repository struct {
 	client *redis.Client
 	logger *logging.Logger
@@ -33,66 +66,227 @@ func (r *repository) Ping(ctx context.Context) error {
 	return err
 }
 
-func (r *repository) FindMetric(ctx context.Context, name, mtype string) (metric.Metric, error) {
-	result, err := r.client.Get(ctx, prepareKey(name, mtype)).Bytes()
+func (r *repository) FindMetric(ctx context.Context, name, mtype string, labels metric.Labels) (metric.Metric, error) {
+	fields, err := r.client.HGetAll(ctx, prepareKey(name, mtype, labels)).Result()
 	if err != nil {
-		if err == redis.Nil {
-			return metric.Metric{}, metric.ErrNoResult
-		}
 		return metric.Metric{}, err
 	}
+	if len(fields) < 1 {
+		return metric.Metric{}, metric.ErrNoResult
+	}
 
-	var answer metric.Metric
-	err = json.Unmarshal(result, &answer)
+	return decodeMetric(fields)
+}
+
+func (r *repository) UpsertMetric(ctx context.Context, m metric.Metric) (metric.Metric, error) {
+	stored, err := r.ImportMetrics(ctx, []metric.Metric{m})
 	if err != nil {
 		return metric.Metric{}, err
 	}
 
-	return answer, nil
+	return stored[0], nil
 }
 
-func (r *repository) UpsertMetric(ctx context.Context, m metric.Metric) error {
-	if m.MType == metric.CounterType {
-		mcurrent, err := r.FindMetric(ctx, m.ID, metric.CounterType)
-		if err != nil && err != metric.ErrNoResult {
-			return err
+// ImportMetrics сохраняет метрики одной транзакцией MULTI/EXEC
+// и в той же транзакции читает значения, получившиеся после обновления.
+func (r *repository) ImportMetrics(ctx context.Context, metrics []metric.Metric) ([]metric.Metric, error) {
+	if len(metrics) < 1 {
+		return nil, nil
+	}
+	for _, m := range metrics {
+		if err := m.Validate(); err != nil {
+			return nil, err
 		}
-		*m.Delta += *mcurrent.Delta
 	}
-	return r.client.Set(ctx, prepareKey(m.ID, m.MType), m.Marshal(), 0).Err()
-}
 
-func (r *repository) ImportMetrics(ctx context.Context, metrics []metric.Metric) error {
-	for _, m := range metrics {
-		err := r.UpsertMetric(ctx, m)
+	cmds := make([]*redis.MapStringStringCmd, len(metrics))
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, m := range metrics {
+			queueUpdate(ctx, pipe, m, r.cfg.RedisStorage.MetricTTL)
+			cmds[i] = pipe.HGetAll(ctx, prepareKey(m.ID, m.MType, m.Labels))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	stored := make([]metric.Metric, 0, len(metrics))
+	for _, cmd := range cmds {
+		m, err := decodeMetric(cmd.Val())
 		if err != nil {
-			return err
+			return nil, err
 		}
+		stored = append(stored, m)
 	}
 
-	return nil
+	return stored, r.appendHistory(ctx, stored...)
 }
 
-func (r *repository) ExportMetrics(ctx context.Context) ([]metric.Metric, error) {
-	keys, err := r.client.Keys(ctx, "metric:*").Result()
-	if err != nil {
-		return nil, err
+// queueUpdate добавляет в транзакцию обновление метрики и продление ее TTL.
+func queueUpdate(ctx context.Context, pipe redis.Pipeliner, m metric.Metric, ttl time.Duration) {
+	key := prepareKey(m.ID, m.MType, m.Labels)
+	fields := metaFields(m)
+	if m.Source == "" || m.LastSeen == nil {
+		pipe.HDel(ctx, key, fieldSource, fieldLastSeen)
+	}
+
+	switch m.MType {
+	case metric.GaugeType:
+		fields[fieldValue] = formatFloat(*m.Value)
+		pipe.HSet(ctx, key, fields)
+
+	case metric.CounterType:
+		if m.Absolute {
+			fields[fieldDelta] = *m.Delta
+			pipe.HSet(ctx, key, fields)
+			break
+		}
+		pipe.HSet(ctx, key, fields)
+		pipe.HIncrBy(ctx, key, fieldDelta, *m.Delta)
+
+	case metric.HistogramType:
+		pipe.HSet(ctx, key, fields)
+		mergeHistogram.Eval(ctx, pipe, []string{key}, histogramArgs(m.Histogram)...)
+
+	case metric.SummaryType:
+		quantiles, _ := json.Marshal(m.Summary.Quantiles)
+		fields[fieldQuantiles] = quantiles
+		fields[fieldCount] = m.Summary.Count
+		fields[fieldSum] = formatFloat(m.Summary.Sum)
+		pipe.HSet(ctx, key, fields)
 	}
 
+	if ttl > 0 {
+		pipe.PExpire(ctx, key, ttl)
+	}
+}
+
+// ExportMetrics обходит ключи метрик каждого типа через SCAN и читает их пакетами в pipeline.
+func (r *repository) ExportMetrics(ctx context.Context) ([]metric.Metric, error) {
 	metrics := make([]metric.Metric, 0)
-	for _, k := range keys {
-		data, err := r.client.Get(ctx, k).Bytes()
+	for _, mtype := range []string{metric.GaugeType, metric.CounterType, metric.HistogramType, metric.SummaryType} {
+		err := r.scan(ctx, typePattern(mtype), func(keys []string) error {
+			cmds := make([]*redis.MapStringStringCmd, len(keys))
+			r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+				for i, k := range keys {
+					cmds[i] = pipe.HGetAll(ctx, k)
+				}
+				return nil
+			})
+
+			for i, cmd := range cmds {
+				var replyErr redis.Error
+				switch err := cmd.Err(); {
+				case errors.As(err, &replyErr):
+					// например, WRONGTYPE для ключа в другом формате
+					r.logger.Errorf("redis key %s: %s", keys[i], err.Error())
+					continue
+				case err != nil:
+					return err
+				}
+
+				fields := cmd.Val()
+				if len(fields) < 1 {
+					// ключ удален или истек после SCAN
+					continue
+				}
+				m, err := decodeMetric(fields)
+				if err != nil {
+					r.logger.Errorf("redis key %s: %s", keys[i], err.Error())
+					continue
+				}
+				metrics = append(metrics, m)
+			}
+			return nil
+		})
 		if err != nil {
 			return nil, err
 		}
-		var m metric.Metric
-		err = json.Unmarshal(data, &m)
+	}
+
+	return metrics, nil
+}
+
+// scan передает в fn ключи, подходящие под шаблон, страницами SCAN без повторов.
+func (r *repository) scan(ctx context.Context, pattern string, fn func(keys []string) error) error {
+	seen := make(map[string]bool)
+
+	var cursor uint64
+	for {
+		keys, next, err := r.client.Scan(ctx, cursor, pattern, scanCount).Result()
+		if err != nil {
+			return err
+		}
+
+		// SCAN может вернуть ключ несколько раз
+		unique := keys[:0]
+		for _, k := range keys {
+			if !seen[k] {
+				seen[k] = true
+				unique = append(unique, k)
+			}
+		}
+		if len(unique) > 0 {
+			if err = fn(unique); err != nil {
+				return err
+			}
+		}
+
+		cursor = next
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+func (r *repository) FindHistory(ctx context.Context, name, mtype string, labels metric.Labels, from, to time.Time, step time.Duration) ([]metric.Sample, error) {
+	result, err := r.client.ZRangeByScore(ctx, prepareHistoryKey(name, mtype, labels), &redis.ZRangeBy{
+		Min: strconv.FormatInt(from.UnixMilli(), 10),
+		Max: strconv.FormatInt(to.UnixMilli(), 10),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	samples := make([]metric.Sample, 0, len(result))
+	for _, v := range result {
+		var s metric.Sample
+		err = json.Unmarshal([]byte(v), &s)
 		if err != nil {
 			return nil, err
 		}
 
-		metrics = append(metrics, m)
+		samples = append(samples, s)
 	}
 
-	return metrics, nil
+	return metric.Downsample(samples, step), nil
+}
+
+// appendHistory сохраняет текущие значения метрик в отсортированные по времени множества
+// и удаляет из них точки старше заданного в конфиге срока хранения.
+func (r *repository) appendHistory(ctx context.Context, metrics ...metric.Metric) error {
+	retention := r.cfg.Settings.HistoryRetention
+	if retention <= 0 || len(metrics) < 1 {
+		return nil
+	}
+
+	now := time.Now()
+	cutoff := strconv.FormatInt(now.Add(-retention).UnixMilli(), 10)
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, m := range metrics {
+			data, err := json.Marshal(metric.NewSample(m, now))
+			if err != nil {
+				return err
+			}
+
+			key := prepareHistoryKey(m.ID, m.MType, m.Labels)
+			pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.UnixMilli()), Member: data})
+			pipe.ZRemRangeByScore(ctx, key, "-inf", cutoff)
+			pipe.Expire(ctx, key, retention)
+		}
+		return nil
+	})
+
+	return err
 }
@@ -0,0 +1,158 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/nickzhog/devops-tool/internal/server/config"
+	"github.com/nickzhog/devops-tool/pkg/logging"
+	"github.com/nickzhog/devops-tool/pkg/metric"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRepository(t *testing.T, cfg *config.Config) (*repository, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return NewRepository(client, logging.GetLogger(), cfg), server
+}
+
+func TestRepository_ConcurrentIncrements(t *testing.T) {
+	r, _ := newTestRepository(t, new(config.Config))
+	ctx := context.Background()
+
+	// при чтении и записи значения целиком часть одновременных приращений терялась бы
+	const workers, updates = 10, 50
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < updates; j++ {
+				_, err := r.UpsertMetric(ctx, metric.NewCounterMetric("PollCount", 1))
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	m, err := r.FindMetric(ctx, "PollCount", metric.CounterType, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(workers*updates), *m.Delta)
+
+	// absolute заменяет накопленное значение
+	absolute := metric.NewCounterMetric("PollCount", 7)
+	absolute.Absolute = true
+	stored, err := r.UpsertMetric(ctx, absolute)
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), *stored.Delta)
+}
+
+func TestRepository_ExportMetrics(t *testing.T) {
+	r, server := newTestRepository(t, new(config.Config))
+	ctx := context.Background()
+
+	// больше одной страницы SCAN
+	want := make(map[string]metric.Metric)
+	metrics := make([]metric.Metric, 0, 2*scanCount+10)
+	for i := 0; i < cap(metrics); i++ {
+		var m metric.Metric
+		switch i % 4 {
+		case 0:
+			m = metric.NewGaugeMetric(fmt.Sprintf("gauge%d", i), float64(i))
+		case 1:
+			m = metric.NewCounterMetric(fmt.Sprintf("counter%d", i), int64(i))
+		case 2:
+			m = metric.NewHistogramMetric(fmt.Sprintf("histogram%d", i), []float64{1, 10}, float64(i))
+		case 3:
+			m = metric.NewSummaryMetric(fmt.Sprintf("summary%d", i), []float64{0.5}, []float64{float64(i)})
+		}
+		m.Labels = metric.Labels{"host": "node1"}
+		metrics = append(metrics, m)
+		want[prepareKey(m.ID, m.MType, m.Labels)] = m
+	}
+	_, err := r.ImportMetrics(ctx, metrics)
+	require.NoError(t, err)
+
+	// ключ в чужом формате пропускается
+	require.NoError(t, server.Set("metric:gauge:broken", "1"))
+
+	exported, err := r.ExportMetrics(ctx)
+	require.NoError(t, err)
+	require.Len(t, exported, len(want))
+	for _, m := range exported {
+		assert.Equal(t, want[prepareKey(m.ID, m.MType, m.Labels)], m)
+	}
+}
+
+func TestRepository_ConcurrentHistograms(t *testing.T) {
+	r, _ := newTestRepository(t, new(config.Config))
+	ctx := context.Background()
+
+	const workers, updates = 10, 20
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < updates; j++ {
+				_, err := r.UpsertMetric(ctx, metric.NewHistogramMetric("latency", []float64{1, 10}, 0.5, 5))
+				assert.NoError(t, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	m, err := r.FindMetric(ctx, "latency", metric.HistogramType, nil)
+	require.NoError(t, err)
+	n := uint64(workers * updates)
+	assert.Equal(t, &metric.Histogram{
+		Buckets: []float64{1, 10},
+		Counts:  []uint64{n, n, 0},
+		Count:   2 * n,
+		Sum:     5.5 * float64(n),
+	}, m.Histogram)
+
+	// при смене границ корзин накопленные значения сбрасываются
+	stored, err := r.UpsertMetric(ctx, metric.NewHistogramMetric("latency", []float64{2}, 3))
+	require.NoError(t, err)
+	assert.Equal(t, &metric.Histogram{Buckets: []float64{2}, Counts: []uint64{0, 1}, Count: 1, Sum: 3}, stored.Histogram)
+}
+
+func TestRepository_MetricTTL(t *testing.T) {
+	cfg := new(config.Config)
+	cfg.RedisStorage.MetricTTL = time.Minute
+	r, server := newTestRepository(t, cfg)
+	ctx := context.Background()
+
+	key := prepareKey("PollCount", metric.CounterType, nil)
+	_, err := r.UpsertMetric(ctx, metric.NewCounterMetric("PollCount", 1))
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, server.TTL(key))
+
+	// запись продлевает TTL
+	server.FastForward(40 * time.Second)
+	assert.Equal(t, 20*time.Second, server.TTL(key))
+	_, err = r.UpsertMetric(ctx, metric.NewCounterMetric("PollCount", 1))
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, server.TTL(key))
+
+	server.FastForward(40 * time.Second)
+	m, err := r.FindMetric(ctx, "PollCount", metric.CounterType, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), *m.Delta)
+
+	// метрика без обновлений дольше TTL удаляется
+	server.FastForward(time.Minute)
+	_, err = r.FindMetric(ctx, "PollCount", metric.CounterType, nil)
+	assert.ErrorIs(t, err, metric.ErrNoResult)
+}
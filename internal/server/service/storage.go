@@ -2,14 +2,19 @@ package service
 
 import (
 	"context"
+	"time"
 
 	"github.com/nickzhog/devops-tool/pkg/metric"
 )
 
+// Storage - хранилище метрик.
+// UpsertMetric и ImportMetrics возвращают значения метрик, получившиеся после записи:
+// ImportMetrics - для каждой метрики пакета, хранилище может объединить повторы одной метрики в одно значение.
 type Storage interface {
-	UpsertMetric(ctx context.Context, metric metric.Metric) error
-	FindMetric(ctx context.Context, name, mtype string) (metric.Metric, error)
+	UpsertMetric(ctx context.Context, metric metric.Metric) (metric.Metric, error)
+	FindMetric(ctx context.Context, name, mtype string, labels metric.Labels) (metric.Metric, error)
 	ExportMetrics(ctx context.Context) ([]metric.Metric, error)
-	ImportMetrics(ctx context.Context, metrics []metric.Metric) error
+	ImportMetrics(ctx context.Context, metrics []metric.Metric) ([]metric.Metric, error)
+	FindHistory(ctx context.Context, name, mtype string, labels metric.Labels, from, to time.Time, step time.Duration) ([]metric.Sample, error)
 	Ping(ctx context.Context) error
 }
@@ -104,12 +104,12 @@ func importFromFile(ctx context.Context, file string, storage service.Storage) e
 	}
 
 	for _, v := range metrics {
-		_, err := storage.FindMetric(ctx, v.ID, v.MType)
+		_, err := storage.FindMetric(ctx, v.ID, v.MType, v.Labels)
 		if err != nil {
 			if !errors.Is(err, metric.ErrNoResult) {
 				return err
 			}
-			err = storage.UpsertMetric(ctx, v)
+			_, err = storage.UpsertMetric(ctx, v)
 			if err != nil {
 				return err
 			}
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS public.metric_history (
+    id TEXT NOT NULL,
+    type TEXT NOT NULL,
+    ts TIMESTAMPTZ NOT NULL DEFAULT now(),
+    value DOUBLE PRECISION,
+    delta BIGINT
+);
+
+CREATE INDEX IF NOT EXISTS metric_history_id_type_ts_idx
+    ON public.metric_history (id, type, ts);
\ No newline at end of file
@@ -0,0 +1,8 @@
+ALTER TABLE public.metrics ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE public.metrics DROP CONSTRAINT IF EXISTS metrics_pkey;
+ALTER TABLE public.metrics ADD PRIMARY KEY (id, type, labels);
+
+ALTER TABLE public.metric_history ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}';
+DROP INDEX IF EXISTS metric_history_id_type_ts_idx;
+CREATE INDEX IF NOT EXISTS metric_history_id_type_labels_ts_idx
+    ON public.metric_history (id, type, labels, ts);
\ No newline at end of file
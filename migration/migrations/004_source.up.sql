@@ -0,0 +1,2 @@
+ALTER TABLE public.metrics ADD COLUMN IF NOT EXISTS source TEXT NOT NULL DEFAULT '';
+ALTER TABLE public.metrics ADD COLUMN IF NOT EXISTS last_seen TIMESTAMPTZ;
\ No newline at end of file
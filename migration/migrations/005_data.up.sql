@@ -0,0 +1,2 @@
+ALTER TABLE public.metrics ADD COLUMN IF NOT EXISTS data JSONB;
+ALTER TABLE public.metric_history ADD COLUMN IF NOT EXISTS data JSONB;
\ No newline at end of file
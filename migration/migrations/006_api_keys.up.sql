@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS public.api_keys (
+    token_hash TEXT PRIMARY KEY,
+    agent_id TEXT NOT NULL DEFAULT '',
+    permissions TEXT[] NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ
+);
\ No newline at end of file
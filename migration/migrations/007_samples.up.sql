@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS public.metric_samples (
+    id TEXT NOT NULL,
+    type TEXT NOT NULL,
+    labels JSONB NOT NULL DEFAULT '{}',
+    ts TIMESTAMPTZ NOT NULL DEFAULT now(),
+    value DOUBLE PRECISION,
+    delta BIGINT,
+    data JSONB
+) PARTITION BY RANGE (ts);
+
+CREATE INDEX IF NOT EXISTS metric_samples_id_type_labels_ts_idx
+    ON public.metric_samples (id, type, labels, ts);
+
+CREATE TABLE IF NOT EXISTS public.metric_samples_default
+    PARTITION OF public.metric_samples DEFAULT;
\ No newline at end of file
@@ -0,0 +1,4 @@
+INSERT INTO public.metric_samples (id, type, labels, ts, value, delta, data)
+SELECT id, type, labels, ts, value, delta, data FROM public.metric_history;
+
+DROP TABLE IF EXISTS public.metric_history;
\ No newline at end of file
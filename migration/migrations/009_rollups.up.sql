@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS public.metric_rollups (
+    resolution TEXT NOT NULL,
+    id TEXT NOT NULL,
+    type TEXT NOT NULL,
+    labels JSONB NOT NULL DEFAULT '{}',
+    ts TIMESTAMPTZ NOT NULL,
+    count BIGINT NOT NULL,
+    min DOUBLE PRECISION,
+    max DOUBLE PRECISION,
+    avg DOUBLE PRECISION,
+    last DOUBLE PRECISION,
+    sum BIGINT,
+    rate DOUBLE PRECISION,
+    total BIGINT,
+    PRIMARY KEY (resolution, id, type, labels, ts)
+);
+
+CREATE INDEX IF NOT EXISTS metric_rollups_resolution_ts_idx
+    ON public.metric_rollups (resolution, ts);
+
+CREATE TABLE IF NOT EXISTS public.metric_rollup_state (
+    resolution TEXT PRIMARY KEY,
+    done TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS metric_samples_ts_idx
+    ON public.metric_samples USING brin (ts);
\ No newline at end of file
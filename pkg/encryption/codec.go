@@ -0,0 +1,79 @@
+package encryption
+
+import (
+	"crypto/rsa"
+	"fmt"
+
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/protobuf/proto"
+)
+
+// codecName совпадает с именем стандартного кодека gRPC,
+// поэтому content-subtype запросов не меняется.
+const codecName = "proto"
+
+// clientCodec шифрует исходящие сообщения клиента gRPC, ответы сервера не шифруются.
+type clientCodec struct {
+	key *rsa.PublicKey
+}
+
+// NewClientCodec возвращает кодек gRPC для клиента, шифрующий запросы публичным ключом.
+// Используется с grpc.ForceCodec.
+func NewClientCodec(key *rsa.PublicKey) encoding.Codec {
+	return clientCodec{key: key}
+}
+
+func (c clientCodec) Marshal(v interface{}) ([]byte, error) {
+	data, err := marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return EncryptData(data, c.key)
+}
+
+func (c clientCodec) Unmarshal(data []byte, v interface{}) error {
+	return unmarshal(data, v)
+}
+
+func (c clientCodec) Name() string { return codecName }
+
+// serverCodec расшифровывает входящие сообщения сервера gRPC, ответы не шифруются.
+type serverCodec struct {
+	key *rsa.PrivateKey
+}
+
+// NewServerCodec возвращает кодек gRPC для сервера, расшифровывающий запросы приватным ключом.
+// Используется с grpc.ForceServerCodec.
+func NewServerCodec(key *rsa.PrivateKey) encoding.Codec {
+	return serverCodec{key: key}
+}
+
+func (c serverCodec) Marshal(v interface{}) ([]byte, error) {
+	return marshal(v)
+}
+
+func (c serverCodec) Unmarshal(data []byte, v interface{}) error {
+	data, err := DecryptData(data, c.key)
+	if err != nil {
+		return err
+	}
+	return unmarshal(data, v)
+}
+
+func (c serverCodec) Name() string { return codecName }
+
+func marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("failed to marshal, message is %T, want proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("failed to unmarshal, message is %T, want proto.Message", v)
+	}
+	return proto.Unmarshal(data, m)
+}
@@ -1,21 +1,114 @@
 package encryption
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
+	"encoding/binary"
 	"encoding/pem"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 )
 
+// Формат зашифрованных данных (envelope encryption, версия 1):
+//
+//	version (1 байт) | длина ключа (2 байта, big endian) | ключ AES, зашифрованный RSA-OAEP | nonce (12 байт) | данные, зашифрованные AES-256-GCM
+//
+// Для каждого сообщения генерируется новый ключ AES, поэтому размер данных не ограничен размером ключа RSA.
+// Данные длиной ровно в размер ключа RSA считаются зашифрованными напрямую RSA-OAEP (прежний формат).
+const (
+	versionEnvelope = 1
+
+	aesKeySize = 32
+	headerSize = 3
+)
+
+var ErrUnknownFormat = errors.New("unknown encrypted data format")
+
+// DecryptData расшифровывает данные, зашифрованные EncryptData.
 func DecryptData(data []byte, privateKey *rsa.PrivateKey) ([]byte, error) {
+	if len(data) == privateKey.Size() {
+		return decryptRSA(data, privateKey)
+	}
+	if len(data) < headerSize || data[0] != versionEnvelope {
+		return nil, ErrUnknownFormat
+	}
+
+	keyLen := int(binary.BigEndian.Uint16(data[1:headerSize]))
+	data = data[headerSize:]
+	if len(data) < keyLen {
+		return nil, ErrUnknownFormat
+	}
+
+	key, err := decryptRSA(data[:keyLen], privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt key: %w", err)
+	}
+	if len(key) != aesKeySize {
+		return nil, ErrUnknownFormat
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	data = data[keyLen:]
+	if len(data) < gcm.NonceSize() {
+		return nil, ErrUnknownFormat
+	}
+
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], nil)
+}
+
+// EncryptData шифрует данные случайным ключом AES-256-GCM, ключ шифруется публичным ключом RSA.
+func EncryptData(data []byte, publicKey *rsa.PublicKey) ([]byte, error) {
+	key := make([]byte, aesKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+
+	encryptedKey, err := encryptRSA(key, publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt key: %w", err)
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, headerSize, headerSize+len(encryptedKey)+len(nonce)+len(data)+gcm.Overhead())
+	out[0] = versionEnvelope
+	binary.BigEndian.PutUint16(out[1:headerSize], uint16(len(encryptedKey)))
+	out = append(out, encryptedKey...)
+	out = append(out, nonce...)
+
+	return gcm.Seal(out, nonce, data, nil), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func decryptRSA(data []byte, privateKey *rsa.PrivateKey) ([]byte, error) {
 	label := []byte("")
 	return rsa.DecryptOAEP(sha256.New(), rand.Reader, privateKey, data, label)
 }
 
-func EncryptData(data []byte, publicKey *rsa.PublicKey) ([]byte, error) {
+func encryptRSA(data []byte, publicKey *rsa.PublicKey) ([]byte, error) {
 	label := []byte("")
 	return rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey, data, label)
 }
@@ -0,0 +1,83 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	pb "github.com/nickzhog/devops-tool/internal/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptData(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "empty", data: nil},
+		{name: "small", data: []byte(`[{"id":"PollCount","type":"counter","delta":1}]`)},
+		{name: "larger than key", data: bytes.Repeat([]byte("metric"), 10000)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			encrypted, err := EncryptData(tt.data, &key.PublicKey)
+			assert.NoError(err)
+			assert.Equal(byte(versionEnvelope), encrypted[0])
+
+			decrypted, err := DecryptData(encrypted, key)
+			assert.NoError(err)
+			assert.Equal(tt.data, decrypted)
+
+			encrypted[len(encrypted)-1] ^= 1
+			_, err = DecryptData(encrypted, key)
+			assert.Error(err)
+		})
+	}
+}
+
+func TestDecryptData_Legacy(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	data := []byte(`{"id":"Alloc","type":"gauge","value":1}`)
+	encrypted, err := encryptRSA(data, &key.PublicKey)
+	require.NoError(t, err)
+
+	decrypted, err := DecryptData(encrypted, key)
+	assert.NoError(t, err)
+	assert.Equal(t, data, decrypted)
+
+	_, err = DecryptData([]byte{2, 0, 0}, key)
+	assert.ErrorIs(t, err, ErrUnknownFormat)
+}
+
+func TestCodec(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	assert := assert.New(t)
+
+	client := NewClientCodec(&key.PublicKey)
+	server := NewServerCodec(key)
+
+	request := &pb.Metric{Id: "PollCount", Mtype: pb.MType_counter, Delta: 5}
+	data, err := client.Marshal(request)
+	assert.NoError(err)
+
+	var got pb.Metric
+	assert.NoError(server.Unmarshal(data, &got))
+	assert.Equal(request.Id, got.Id)
+	assert.Equal(request.Delta, got.Delta)
+
+	data, err = server.Marshal(&got)
+	assert.NoError(err)
+	var response pb.Metric
+	assert.NoError(client.Unmarshal(data, &response))
+	assert.Equal(request.Id, response.Id)
+}
@@ -0,0 +1,129 @@
+// Package ipfilter проверяет адреса клиентов по списку разрешенных подсетей
+// с учетом доверенных прокси-серверов.
+package ipfilter
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Filter - список разрешенных подсетей и доверенных прокси.
+type Filter struct {
+	allowed []*net.IPNet
+	proxies []*net.IPNet
+}
+
+// New создает фильтр. allowed и trustedProxies - списки подсетей в нотации CIDR
+// или отдельных адресов IPv4 и IPv6 через запятую.
+// Заголовки X-Forwarded-For и X-Real-IP учитываются только от доверенных прокси.
+func New(allowed, trustedProxies string) (*Filter, error) {
+	var f Filter
+	var err error
+
+	f.allowed, err = parseNets(allowed)
+	if err != nil {
+		return nil, fmt.Errorf("trusted subnet: %w", err)
+	}
+	f.proxies, err = parseNets(trustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("trusted proxies: %w", err)
+	}
+
+	return &f, nil
+}
+
+// Allowed сообщает, входит ли ip в одну из разрешенных подсетей.
+func (f *Filter) Allowed(ip net.IP) bool {
+	return ip != nil && contains(f.allowed, ip)
+}
+
+// ClientIP возвращает адрес клиента.
+// peer - адрес TCP-соединения, forwardedFor - значения X-Forwarded-For, realIP - значение X-Real-IP.
+// Если peer не является доверенным прокси, заголовки игнорируются и возвращается peer.
+// Иначе X-Forwarded-For просматривается справа налево и возвращается первый адрес,
+// не принадлежащий доверенному прокси.
+func (f *Filter) ClientIP(peer net.IP, forwardedFor []string, realIP string) net.IP {
+	if peer == nil || !contains(f.proxies, peer) {
+		return peer
+	}
+
+	var chain []string
+	for _, v := range forwardedFor {
+		chain = append(chain, strings.Split(v, ",")...)
+	}
+	if len(chain) < 1 && realIP != "" {
+		chain = []string{realIP}
+	}
+
+	ip := peer
+	for i := len(chain) - 1; i >= 0; i-- {
+		next := parseIP(chain[i])
+		if next == nil {
+			// адрес, добавленный недоверенной стороной, не может быть проверен
+			return ip
+		}
+		ip = next
+		if !contains(f.proxies, ip) {
+			return ip
+		}
+	}
+
+	return ip
+}
+
+// HostIP возвращает адрес из строки вида host:port, как в http.Request.RemoteAddr и net.Addr.
+func HostIP(addr string) net.IP {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return parseIP(host)
+}
+
+func parseIP(s string) net.IP {
+	s = strings.Trim(strings.TrimSpace(s), "[]")
+	if i := strings.IndexByte(s, '%'); i >= 0 {
+		s = s[:i]
+	}
+	return net.ParseIP(s)
+}
+
+func parseNets(list string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0)
+	for _, v := range strings.Split(list, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", v)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func contains(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+package ipfilter
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilter_Allowed(t *testing.T) {
+	f, err := New("10.0.0.0/8, 192.168.1.5, 2001:db8::/32", "")
+	require.NoError(t, err)
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{ip: "10.1.2.3", want: true},
+		{ip: "::ffff:10.1.2.3", want: true},
+		{ip: "192.168.1.5", want: true},
+		{ip: "192.168.1.6", want: false},
+		{ip: "2001:db8::1", want: true},
+		{ip: "2001:db9::1", want: false},
+		{ip: "", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			assert.Equal(t, tt.want, f.Allowed(net.ParseIP(tt.ip)))
+		})
+	}
+
+	_, err = New("10.0.0.0/33", "")
+	assert.Error(t, err)
+	_, err = New("", "proxy")
+	assert.Error(t, err)
+}
+
+func TestFilter_ClientIP(t *testing.T) {
+	f, err := New("", "10.0.0.1, fd00::/8")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		peer         string
+		forwardedFor []string
+		realIP       string
+		want         string
+	}{
+		{
+			name:         "untrusted peer headers are ignored",
+			peer:         "203.0.113.7",
+			forwardedFor: []string{"10.1.1.1"},
+			realIP:       "10.1.1.1",
+			want:         "203.0.113.7",
+		},
+		{
+			name:         "forwarded for",
+			peer:         "10.0.0.1",
+			forwardedFor: []string{"203.0.113.7"},
+			want:         "203.0.113.7",
+		},
+		{
+			name:         "client-supplied prefix is skipped",
+			peer:         "10.0.0.1",
+			forwardedFor: []string{"10.1.1.1, 203.0.113.7", "fd00::2"},
+			want:         "203.0.113.7",
+		},
+		{
+			name:   "real ip",
+			peer:   "fd00::1",
+			realIP: "2001:db8::7",
+			want:   "2001:db8::7",
+		},
+		{
+			name:         "garbage in chain",
+			peer:         "10.0.0.1",
+			forwardedFor: []string{"203.0.113.7, junk"},
+			want:         "10.0.0.1",
+		},
+		{
+			name: "no headers",
+			peer: "10.0.0.1",
+			want: "10.0.0.1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := f.ClientIP(net.ParseIP(tt.peer), tt.forwardedFor, tt.realIP)
+			assert.Equal(t, tt.want, got.String())
+		})
+	}
+}
+
+func TestHostIP(t *testing.T) {
+	assert.Equal(t, "192.0.2.1", HostIP("192.0.2.1:8080").String())
+	assert.Equal(t, "2001:db8::1", HostIP("[2001:db8::1]:443").String())
+	assert.Equal(t, "fe80::1", HostIP("[fe80::1%eth0]:443").String())
+	assert.Equal(t, "192.0.2.1", HostIP("192.0.2.1").String())
+	assert.Nil(t, HostIP("bufconn"))
+}
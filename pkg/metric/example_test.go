@@ -2,6 +2,7 @@ package metric
 
 import (
 	"fmt"
+	"time"
 )
 
 func ExampleNewGaugeMetric() {
@@ -58,3 +59,77 @@ func ExampleMetric_IsValidHash() {
 	// IsValid: true
 	// IsValid: false
 }
+
+func ExampleParseLabels() {
+	// Разбор labels из строки
+	labels, _ := ParseLabels("service=api,host=node1")
+
+	gauge := NewGaugeMetric("response_time", 100)
+	gauge.Labels = labels
+
+	fmt.Printf("Series: %s\n", gauge.SeriesKey())
+	fmt.Printf("Match: %t\n", labels.Match(Labels{"host": "node1"}))
+
+	// Output:
+	// Series: response_time{host=node1,service=api}
+	// Match: true
+}
+
+func ExampleNewHistogramMetric() {
+	// Создание метрики типа histogram с корзинами 0.1, 0.5 и 1
+	histogram := NewHistogramMetric("request_duration", []float64{0.1, 0.5, 1}, 0.05, 0.3, 0.7, 2)
+	fmt.Printf("Histogram: id: %s, type: %s, %s\n",
+		histogram.ID, histogram.MType, histogram.Histogram)
+
+	// Output:
+	// Histogram: id: request_duration, type: histogram, count=4 sum=3.05 [le=0.1:1 le=0.5:2 le=1:3 le=+Inf:4]
+}
+
+func ExampleNewSummaryMetric() {
+	// Создание метрики типа summary с медианой и 90-м перцентилем
+	summary := NewSummaryMetric("request_duration", []float64{0.5, 0.9}, []float64{1, 2, 3, 4, 5})
+	fmt.Printf("Summary: id: %s, type: %s, %s\n",
+		summary.ID, summary.MType, summary.Summary)
+
+	// Output:
+	// Summary: id: request_duration, type: summary, count=5 sum=15 [q0.5=3 q0.9=5]
+}
+
+func ExampleRollup_Merge() {
+	// Агрегаты counter за две соседние минуты
+	first, second := int64(30), int64(90)
+	a := &Rollup{Seconds: 60, Count: 6, Sum: &first}
+	b := &Rollup{Seconds: 60, Count: 6, Sum: &second}
+
+	// Агрегаты за две минуты
+	merged := a.Merge(b)
+	fmt.Printf("Rollup: seconds: %d, count: %d, sum: %d, rate: %g\n",
+		merged.Seconds, merged.Count, *merged.Sum, *merged.Rate)
+
+	// У агрегатов нулевой длительности скорость не рассчитывается
+	instant := (&Rollup{Count: 1, Sum: &first}).Merge(&Rollup{Count: 1, Sum: &second})
+	fmt.Printf("Rollup: seconds: %d, sum: %d, rate: %v\n", instant.Seconds, *instant.Sum, instant.Rate)
+
+	// Output:
+	// Rollup: seconds: 120, count: 12, sum: 120, rate: 1
+	// Rollup: seconds: 0, sum: 120, rate: <nil>
+}
+
+func ExampleDownsample() {
+	// Агрегат counter за первую минуту и сырое значение после него
+	sum, last, current := int64(60), int64(100), int64(130)
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	samples := []Sample{
+		{Timestamp: start, Delta: &last, Rollup: &Rollup{Seconds: 60, Count: 6, Sum: &sum}},
+		{Timestamp: start.Add(90 * time.Second), Delta: &current},
+	}
+
+	// Сырое значение дополняет агрегат интервала в 5 минут
+	result := Downsample(samples, 5*time.Minute)
+	rollup := result[0].Rollup
+	fmt.Printf("Samples: %d, delta: %d, count: %d, sum: %d, rate: %g\n",
+		len(result), *result[0].Delta, rollup.Count, *rollup.Sum, *rollup.Rate)
+
+	// Output:
+	// Samples: 1, delta: 130, count: 7, sum: 90, rate: 1
+}
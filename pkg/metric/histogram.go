@@ -0,0 +1,210 @@
+package metric
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Histogram - распределение наблюдений по корзинам.
+// Counts содержит количество наблюдений в каждой корзине (не накопительно),
+// последний элемент Counts - корзина +Inf, поэтому len(Counts) == len(Buckets)+1.
+type Histogram struct {
+	Buckets []float64 `json:"buckets"` // верхние границы корзин по возрастанию
+	Counts  []uint64  `json:"counts"`  // количество наблюдений в корзинах
+	Count   uint64    `json:"count"`   // общее количество наблюдений
+	Sum     float64   `json:"sum"`     // сумма наблюдений
+}
+
+// Quantile - значение квантиля summary.
+type Quantile struct {
+	Quantile float64 `json:"quantile"`
+	Value    float64 `json:"value"`
+}
+
+// Summary - квантили наблюдений, рассчитанные на стороне клиента.
+type Summary struct {
+	Quantiles []Quantile `json:"quantiles"`
+	Count     uint64     `json:"count"`
+	Sum       float64    `json:"sum"`
+}
+
+// NewHistogramMetric создает метрику c типом "histogram" с заданными границами корзин
+// и распределяет по ним переданные наблюдения.
+func NewHistogramMetric(name string, buckets []float64, observations ...float64) Metric {
+	bounds := append([]float64(nil), buckets...)
+	sort.Float64s(bounds)
+
+	h := &Histogram{
+		Buckets: bounds,
+		Counts:  make([]uint64, len(bounds)+1),
+	}
+	for _, v := range observations {
+		h.Observe(v)
+	}
+
+	return Metric{
+		ID:        name,
+		MType:     HistogramType,
+		Histogram: h,
+	}
+}
+
+// NewSummaryMetric создает метрику c типом "summary", рассчитывая
+// заданные квантили (от 0 до 1) по переданным наблюдениям.
+// Без наблюдений значения квантилей равны 0.
+func NewSummaryMetric(name string, quantiles []float64, observations []float64) Metric {
+	sorted := append([]float64(nil), observations...)
+	sort.Float64s(sorted)
+
+	s := &Summary{
+		Quantiles: make([]Quantile, 0, len(quantiles)),
+		Count:     uint64(len(sorted)),
+	}
+	for _, v := range sorted {
+		s.Sum += v
+	}
+	for _, q := range quantiles {
+		var value float64
+		if len(sorted) > 0 {
+			i := int(math.Ceil(q*float64(len(sorted)))) - 1
+			if i < 0 {
+				i = 0
+			}
+			if i >= len(sorted) {
+				i = len(sorted) - 1
+			}
+			value = sorted[i]
+		}
+		s.Quantiles = append(s.Quantiles, Quantile{Quantile: q, Value: value})
+	}
+
+	return Metric{
+		ID:      name,
+		MType:   SummaryType,
+		Summary: s,
+	}
+}
+
+// Observe добавляет наблюдение в гистограмму.
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.Buckets, v)
+	h.Counts[i]++
+	h.Count++
+	h.Sum += v
+}
+
+// SameBuckets проверяет, что у гистограмм совпадают границы корзин.
+func (h *Histogram) SameBuckets(other *Histogram) bool {
+	if len(h.Buckets) != len(other.Buckets) {
+		return false
+	}
+	for i := range h.Buckets {
+		if h.Buckets[i] != other.Buckets[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Merge добавляет к гистограмме наблюдения из other.
+// Границы корзин гистограмм должны совпадать.
+func (h *Histogram) Merge(other *Histogram) error {
+	if !h.SameBuckets(other) {
+		return ErrBucketsMismatch
+	}
+
+	for i := range h.Counts {
+		h.Counts[i] += other.Counts[i]
+	}
+	h.Count += other.Count
+	h.Sum += other.Sum
+
+	return nil
+}
+
+// MergeHistograms возвращает результат применения обновления update к сохраненной гистограмме current.
+// Наблюдения складываются, как у counter. Если current отсутствует или границы корзин
+// изменились, накопленные значения сбрасываются и результатом становится копия update.
+func MergeHistograms(current, update *Histogram) *Histogram {
+	result := update.Copy()
+	if current != nil && current.SameBuckets(update) {
+		result.Merge(current)
+	}
+	return result
+}
+
+// Copy возвращает независимую копию гистограммы.
+func (h *Histogram) Copy() *Histogram {
+	return &Histogram{
+		Buckets: append([]float64(nil), h.Buckets...),
+		Counts:  append([]uint64(nil), h.Counts...),
+		Count:   h.Count,
+		Sum:     h.Sum,
+	}
+}
+
+// Cumulative возвращает накопительные количества наблюдений по корзинам,
+// как это принято в Prometheus (последний элемент - корзина +Inf).
+func (h *Histogram) Cumulative() []uint64 {
+	result := make([]uint64, len(h.Counts))
+	var total uint64
+	for i, c := range h.Counts {
+		total += c
+		result[i] = total
+	}
+	return result
+}
+
+func (h *Histogram) validate() error {
+	if len(h.Counts) != len(h.Buckets)+1 {
+		return fmt.Errorf("%w: histogram must have %d counts, got %d", ErrInvalidValue, len(h.Buckets)+1, len(h.Counts))
+	}
+	if !sort.Float64sAreSorted(h.Buckets) {
+		return fmt.Errorf("%w: histogram buckets must be sorted", ErrInvalidValue)
+	}
+
+	var total uint64
+	for _, c := range h.Counts {
+		total += c
+	}
+	if total != h.Count {
+		return fmt.Errorf("%w: histogram count %d does not match bucket counts sum %d", ErrInvalidValue, h.Count, total)
+	}
+	return nil
+}
+
+// String возвращает гистограмму в виде "count=10 sum=4.2 [le=0.5:3 le=1:8 le=+Inf:10]".
+func (h *Histogram) String() string {
+	cumulative := h.Cumulative()
+	buckets := make([]string, 0, len(cumulative))
+	for i, c := range cumulative {
+		bound := "+Inf"
+		if i < len(h.Buckets) {
+			bound = fmt.Sprintf("%g", h.Buckets[i])
+		}
+		buckets = append(buckets, fmt.Sprintf("le=%s:%d", bound, c))
+	}
+
+	return fmt.Sprintf("count=%d sum=%g [%s]", h.Count, h.Sum, strings.Join(buckets, " "))
+}
+
+// Copy возвращает независимую копию summary.
+func (s *Summary) Copy() *Summary {
+	return &Summary{
+		Quantiles: append([]Quantile(nil), s.Quantiles...),
+		Count:     s.Count,
+		Sum:       s.Sum,
+	}
+}
+
+// String возвращает summary в виде "count=10 sum=4.2 [q0.5=0.3 q0.99=0.9]".
+func (s *Summary) String() string {
+	quantiles := make([]string, 0, len(s.Quantiles))
+	for _, q := range s.Quantiles {
+		quantiles = append(quantiles, fmt.Sprintf("q%g=%g", q.Quantile, q.Value))
+	}
+
+	return fmt.Sprintf("count=%d sum=%g [%s]", s.Count, s.Sum, strings.Join(quantiles, " "))
+}
@@ -0,0 +1,139 @@
+package metric
+
+import (
+	"math"
+	"time"
+)
+
+// Sample - значение метрики в определенный момент времени.
+// Для counter и histogram хранится накопленное значение после обновления.
+type Sample struct {
+	Timestamp time.Time  `json:"timestamp"`
+	Delta     *int64     `json:"delta,omitempty"`
+	Value     *float64   `json:"value,omitempty"`
+	Histogram *Histogram `json:"histogram,omitempty"`
+	Summary   *Summary   `json:"summary,omitempty"`
+
+	// Rollup заполняется, если точка получена из агрегатов истории,
+	// тогда Timestamp - начало интервала агрегации, а Value и Delta - последние значения в нем.
+	Rollup *Rollup `json:"rollup,omitempty"`
+}
+
+// Rollup - агрегаты значений метрики за интервал.
+// Для gauge заполняются Min, Max и Avg, для counter - Sum (прирост за интервал) и Rate (прирост в секунду).
+type Rollup struct {
+	Seconds int64    `json:"seconds"` // длительность интервала в секундах
+	Count   int64    `json:"count"`   // количество значений в интервале
+	Min     *float64 `json:"min,omitempty"`
+	Max     *float64 `json:"max,omitempty"`
+	Avg     *float64 `json:"avg,omitempty"`
+	Sum     *int64   `json:"sum,omitempty"`
+	Rate    *float64 `json:"rate,omitempty"`
+}
+
+// Merge возвращает агрегаты за объединение интервалов r и other.
+func (r *Rollup) Merge(other *Rollup) *Rollup {
+	result := &Rollup{
+		Seconds: r.Seconds + other.Seconds,
+		Count:   r.Count + other.Count,
+	}
+
+	if r.Min != nil && other.Min != nil {
+		min, max := math.Min(*r.Min, *other.Min), math.Max(*r.Max, *other.Max)
+		avg := (*r.Avg*float64(r.Count) + *other.Avg*float64(other.Count)) / float64(result.Count)
+		result.Min, result.Max, result.Avg = &min, &max, &avg
+	}
+	if r.Sum != nil && other.Sum != nil {
+		sum := *r.Sum + *other.Sum
+		result.Sum = &sum
+		// у интервала нулевой длительности скорости нет
+		if result.Seconds > 0 {
+			rate := float64(sum) / float64(result.Seconds)
+			result.Rate = &rate
+		}
+	}
+
+	return result
+}
+
+// add возвращает агрегаты интервала точки last, дополненные сырым значением s,
+// записанным после нее в том же интервале.
+func (r *Rollup) add(last, s Sample) *Rollup {
+	result := *r
+	result.Count++
+	if seconds := int64(s.Timestamp.Sub(last.Timestamp) / time.Second); seconds > result.Seconds {
+		result.Seconds = seconds
+	}
+
+	if r.Min != nil && s.Value != nil {
+		min, max := math.Min(*r.Min, *s.Value), math.Max(*r.Max, *s.Value)
+		avg := (*r.Avg*float64(r.Count) + *s.Value) / float64(result.Count)
+		result.Min, result.Max, result.Avg = &min, &max, &avg
+	}
+	if r.Sum != nil && last.Delta != nil && s.Delta != nil {
+		increase := *s.Delta - *last.Delta
+		if increase < 0 {
+			// счетчик был сброшен
+			increase = *s.Delta
+		}
+		sum := *r.Sum + increase
+		result.Sum = &sum
+	}
+	if result.Sum != nil && result.Seconds > 0 {
+		rate := float64(*result.Sum) / float64(result.Seconds)
+		result.Rate = &rate
+	}
+
+	return &result
+}
+
+// NewSample создает точку истории из текущего значения метрики.
+func NewSample(m Metric, ts time.Time) Sample {
+	s := Sample{Timestamp: ts}
+	if m.Delta != nil {
+		delta := *m.Delta
+		s.Delta = &delta
+	}
+	if m.Value != nil {
+		value := *m.Value
+		s.Value = &value
+	}
+	if m.Histogram != nil {
+		s.Histogram = m.Histogram.Copy()
+	}
+	if m.Summary != nil {
+		s.Summary = m.Summary.Copy()
+	}
+	return s
+}
+
+// Downsample прореживает отсортированную по времени историю,
+// оставляя последнее значение в каждом интервале длиной step.
+// Агрегаты точек из одного интервала объединяются,
+// а сырые значения после агрегатов дополняют их, не заменяя.
+// При step <= 0 история возвращается без изменений.
+func Downsample(samples []Sample, step time.Duration) []Sample {
+	if step <= 0 || len(samples) < 2 {
+		return samples
+	}
+
+	result := make([]Sample, 0, len(samples))
+	for _, s := range samples {
+		bucket := s.Timestamp.Truncate(step)
+		if n := len(result); n > 0 && result[n-1].Timestamp.Truncate(step).Equal(bucket) {
+			if prev := result[n-1]; prev.Rollup != nil {
+				if s.Rollup != nil {
+					s.Rollup = prev.Rollup.Merge(s.Rollup)
+				} else {
+					s.Rollup = prev.Rollup.add(prev, s)
+				}
+				s.Timestamp = prev.Timestamp
+			}
+			result[n-1] = s
+			continue
+		}
+		result = append(result, s)
+	}
+
+	return result
+}
@@ -0,0 +1,102 @@
+package metric
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Labels - набор меток метрики (host, service, env и произвольные ключ/значение).
+// Метрики с одинаковым именем и типом, но разными labels, хранятся независимо.
+type Labels map[string]string
+
+var (
+	labelEscaper   = strings.NewReplacer(`\`, `\\`, `,`, `\,`, `=`, `\=`, `{`, `\{`, `}`, `\}`)
+	labelUnescaper = strings.NewReplacer(`\\`, `\`, `\,`, `,`, `\=`, `=`, `\{`, `{`, `\}`, `}`)
+)
+
+// ParseLabels разбирает набор labels в формате "k1=v1,k2=v2".
+// Символы ',', '=', '{', '}' и '\' внутри ключей и значений экранируются через '\'.
+func ParseLabels(s string) (Labels, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	labels := make(Labels)
+	for _, pair := range splitEscaped(s, ',') {
+		kv := splitEscaped(pair, '=')
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("wrong label: %q", pair)
+		}
+
+		k := labelUnescaper.Replace(strings.TrimSpace(kv[0]))
+		if k == "" {
+			return nil, fmt.Errorf("empty label name: %q", pair)
+		}
+		labels[k] = labelUnescaper.Replace(strings.TrimSpace(kv[1]))
+	}
+
+	return labels, nil
+}
+
+// String возвращает labels в каноническом виде "k1=v1,k2=v2" с сортировкой по ключу.
+func (l Labels) String() string {
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, labelEscaper.Replace(k)+"="+labelEscaper.Replace(l[k]))
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+// Match проверяет, что labels содержат все пары ключ/значение из selector.
+// Пустой selector подходит для любых labels.
+func (l Labels) Match(selector Labels) bool {
+	for k, v := range selector {
+		if lv, ok := l[k]; !ok || lv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Equal проверяет, что наборы labels совпадают.
+func (l Labels) Equal(other Labels) bool {
+	return len(l) == len(other) && l.Match(other)
+}
+
+// SeriesKey возвращает строку, однозначно определяющую метрику по имени и labels,
+// например "Alloc{host=node1}". Для метрики без labels возвращается имя.
+func SeriesKey(name string, labels Labels) string {
+	if len(labels) < 1 {
+		return name
+	}
+	return name + "{" + labels.String() + "}"
+}
+
+func splitEscaped(s string, sep byte) []string {
+	var (
+		parts   []string
+		start   int
+		escaped bool
+	)
+	for i := 0; i < len(s); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case s[i] == '\\':
+			escaped = true
+		case s[i] == sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(parts, s[start:])
+}
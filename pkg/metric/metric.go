@@ -6,22 +6,41 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"time"
 )
 
 const (
-	GaugeType   = "gauge"
-	CounterType = "counter"
+	GaugeType     = "gauge"
+	CounterType   = "counter"
+	HistogramType = "histogram"
+	SummaryType   = "summary"
 )
 
 var ErrNoResult = errors.New("metric not found")
 var ErrWrongHash = errors.New("wrong hash for metric")
+var ErrWrongType = errors.New("wrong metric type")
+var ErrNoValue = errors.New("metric value is missing")
+var ErrBucketsMismatch = errors.New("histogram buckets mismatch")
+var ErrInvalidValue = errors.New("invalid metric value")
 
 type Metric struct {
 	ID    string   `json:"id"`              // имя метрики
-	MType string   `json:"type"`            // параметр, принимающий значение gauge или counter
+	MType string   `json:"type"`            // параметр, принимающий значение gauge, counter, histogram или summary
 	Delta *int64   `json:"delta,omitempty"` // значение метрики в случае передачи counter
 	Value *float64 `json:"value,omitempty"` // значение метрики в случае передачи gauge
 	Hash  string   `json:"hash,omitempty"`  // значение хеш-функции
+
+	// Absolute для counter означает, что Delta - полное значение счетчика,
+	// которое заменяет сохраненное, а не прибавляется к нему (пересинхронизация агента).
+	Absolute bool `json:"absolute,omitempty"`
+
+	Histogram *Histogram `json:"histogram,omitempty"` // значение метрики в случае передачи histogram
+	Summary   *Summary   `json:"summary,omitempty"`   // значение метрики в случае передачи summary
+
+	Labels Labels `json:"labels,omitempty"` // метки метрики (host, service, env и т.д.)
+
+	Source   string     `json:"source,omitempty"`    // идентификатор агента, приславшего метрику (заполняется сервером)
+	LastSeen *time.Time `json:"last_seen,omitempty"` // время последнего обновления от агента (заполняется сервером)
 }
 
 // NewGaugeMetric создает метрику c типом "gauge"
@@ -42,6 +61,50 @@ func NewCounterMetric(name string, value int64) Metric {
 	}
 }
 
+// IsValidType проверяет, что mtype - один из поддерживаемых типов метрик.
+func IsValidType(mtype string) bool {
+	switch mtype {
+	case GaugeType, CounterType, HistogramType, SummaryType:
+		return true
+	}
+	return false
+}
+
+// Validate проверяет тип метрики и наличие значения, соответствующего типу,
+// а для histogram - согласованность корзин и общего количества наблюдений.
+func (m Metric) Validate() error {
+	var ok bool
+	switch m.MType {
+	case GaugeType:
+		ok = m.Value != nil
+	case CounterType:
+		ok = m.Delta != nil
+	case HistogramType:
+		if m.Histogram != nil {
+			return m.Histogram.validate()
+		}
+	case SummaryType:
+		ok = m.Summary != nil
+	default:
+		return ErrWrongType
+	}
+
+	if !ok {
+		return ErrNoValue
+	}
+	return nil
+}
+
+// IsInvalid сообщает, что err - ошибка проверки метрики (см. Validate).
+func IsInvalid(err error) bool {
+	return errors.Is(err, ErrWrongType) || errors.Is(err, ErrNoValue) || errors.Is(err, ErrInvalidValue)
+}
+
+// SeriesKey возвращает строку, однозначно определяющую метрику по имени и labels.
+func (m Metric) SeriesKey() string {
+	return SeriesKey(m.ID, m.Labels)
+}
+
 // Marshal используется для сериализации метрики в формат JSON.
 // Функция возвращает срез байтов, содержащий сериализованную метрику.
 func (m Metric) Marshal() []byte {
@@ -58,10 +121,27 @@ func (m *Metric) GetHash(key string) string {
 		data = fmt.Sprintf("%s:%s:%f", m.ID, GaugeType, *m.Value)
 	case CounterType:
 		data = fmt.Sprintf("%s:%s:%d", m.ID, CounterType, *m.Delta)
+		if m.Absolute {
+			data += ":absolute"
+		}
+	case HistogramType:
+		data = fmt.Sprintf("%s:%s:%v:%v:%d:%f", m.ID, HistogramType,
+			m.Histogram.Buckets, m.Histogram.Counts, m.Histogram.Count, m.Histogram.Sum)
+	case SummaryType:
+		data = fmt.Sprintf("%s:%s:%v:%d:%f", m.ID, SummaryType,
+			m.Summary.Quantiles, m.Summary.Count, m.Summary.Sum)
+	}
+	if len(m.Labels) > 0 {
+		data += ":" + m.Labels.String()
 	}
 
+	return Sign(key, []byte(data))
+}
+
+// Sign возвращает SHA-256 HMAC хэш данных в hex, так же как хэш метрики.
+func Sign(key string, data []byte) string {
 	h := hmac.New(sha256.New, []byte(key))
-	h.Write([]byte(data))
+	h.Write(data)
 
 	return fmt.Sprintf("%x", h.Sum(nil))
 }
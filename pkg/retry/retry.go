@@ -0,0 +1,99 @@
+// Package retry реализует повтор операций с экспоненциальной задержкой и джиттером.
+package retry
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// Policy - политика повтора операции.
+type Policy struct {
+	MaxAttempts int           // общее количество попыток, включая первую; 0 или 1 - без повторов
+	BaseDelay   time.Duration // задержка перед первым повтором, далее удваивается
+	MaxDelay    time.Duration // максимальная задержка, 0 - без ограничения
+	Jitter      float64       // доля случайного уменьшения задержки, от 0 до 1
+
+	// Retryable определяет, стоит ли повторять операцию после ошибки.
+	// Если не задана, повторяется любая ошибка.
+	Retryable func(err error) bool
+	// OnRetry вызывается перед каждым повтором.
+	OnRetry func(attempt int, err error, delay time.Duration)
+}
+
+// permanentError - ошибка, после которой операция не повторяется.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent помечает ошибку как не подлежащую повтору.
+// Do возвращает исходную ошибку без обертки.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// Do выполняет fn, повторяя ее согласно политике, пока она возвращает ошибку.
+// Возвращает ошибку последней попытки или ошибку контекста, если он отменен во время ожидания.
+func (p Policy) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	attempt := 1
+	for {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			return permanent.err
+		}
+		if attempt >= p.MaxAttempts || ctx.Err() != nil {
+			return err
+		}
+		if p.Retryable != nil && !p.Retryable(err) {
+			return err
+		}
+
+		delay := p.Delay(attempt)
+		if p.OnRetry != nil {
+			p.OnRetry(attempt, err, delay)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		attempt++
+	}
+}
+
+// Delay возвращает задержку перед повтором после попытки attempt (начиная с 1).
+func (p Policy) Delay(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt; i++ {
+		delay *= 2
+		if p.MaxDelay > 0 && delay >= p.MaxDelay || delay <= 0 {
+			delay = p.MaxDelay
+			break
+		}
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	if p.Jitter > 0 {
+		delay -= time.Duration(rand.Float64() * p.Jitter * float64(delay))
+	}
+
+	return delay
+}
@@ -0,0 +1,111 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	errTemporary = errors.New("temporary")
+	errFatal     = errors.New("fatal")
+)
+
+func TestPolicy_Do(t *testing.T) {
+	tests := []struct {
+		name         string
+		results      []error
+		wantErr      error
+		wantAttempts int
+	}{
+		{
+			name:         "success after retries",
+			results:      []error{errTemporary, errTemporary, nil},
+			wantErr:      nil,
+			wantAttempts: 3,
+		},
+		{
+			name:         "attempts exhausted",
+			results:      []error{errTemporary, errTemporary, errTemporary, nil},
+			wantErr:      errTemporary,
+			wantAttempts: 3,
+		},
+		{
+			name:         "permanent",
+			results:      []error{Permanent(errTemporary), nil},
+			wantErr:      errTemporary,
+			wantAttempts: 1,
+		},
+		{
+			name:         "not retryable",
+			results:      []error{errFatal, nil},
+			wantErr:      errFatal,
+			wantAttempts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert := assert.New(t)
+
+			var retries []int
+			p := Policy{
+				MaxAttempts: 3,
+				BaseDelay:   time.Millisecond,
+				Retryable: func(err error) bool {
+					return err == errTemporary
+				},
+				OnRetry: func(attempt int, err error, delay time.Duration) {
+					retries = append(retries, attempt)
+				},
+			}
+
+			attempts := 0
+			err := p.Do(context.Background(), func(ctx context.Context) error {
+				err := tt.results[attempts]
+				attempts++
+				return err
+			})
+			assert.Equal(tt.wantErr, err)
+			assert.Equal(tt.wantAttempts, attempts)
+			assert.Len(retries, tt.wantAttempts-1)
+		})
+	}
+}
+
+func TestPolicy_DoCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := Policy{MaxAttempts: 5, BaseDelay: time.Hour}
+
+	err := p.Do(ctx, func(ctx context.Context) error {
+		cancel()
+		return errTemporary
+	})
+	assert.ErrorIs(t, err, errTemporary)
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	err = p.Do(ctx, func(ctx context.Context) error {
+		return errTemporary
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestPolicy_Delay(t *testing.T) {
+	assert := assert.New(t)
+
+	p := Policy{BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+	assert.Equal(time.Second, p.Delay(1))
+	assert.Equal(2*time.Second, p.Delay(2))
+	assert.Equal(4*time.Second, p.Delay(3))
+	assert.Equal(5*time.Second, p.Delay(4))
+	assert.Equal(5*time.Second, p.Delay(100))
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		d := p.Delay(2)
+		assert.True(d > time.Second && d <= 2*time.Second, d)
+	}
+}
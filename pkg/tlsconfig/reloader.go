@@ -0,0 +1,83 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/nickzhog/devops-tool/pkg/logging"
+)
+
+// Reloader - настройки TLS сервера, которые можно перечитать из файлов без перезапуска.
+type Reloader struct {
+	certFile, keyFile, clientCAFile string
+
+	mutex   sync.RWMutex
+	current *tls.Config
+}
+
+// NewReloader загружает настройки TLS сервера аналогично Server.
+func NewReloader(certFile, keyFile, clientCAFile string) (*Reloader, error) {
+	r := &Reloader{
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: clientCAFile,
+	}
+
+	err := r.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload перечитывает сертификаты из файлов. При ошибке продолжают действовать прежние.
+// Новые настройки применяются к следующим соединениям.
+func (r *Reloader) Reload() error {
+	cfg, err := Server(r.certFile, r.keyFile, r.clientCAFile)
+	if err != nil {
+		return err
+	}
+	cfg.NextProtos = []string{"h2", "http/1.1"}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.current = cfg
+
+	return nil
+}
+
+// Config возвращает настройки TLS для сервера, всегда использующие последние загруженные сертификаты.
+func (r *Reloader) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mutex.RLock()
+			defer r.mutex.RUnlock()
+			return r.current, nil
+		},
+	}
+}
+
+// ReloadOnSignal перечитывает сертификаты по сигналу SIGHUP, пока не отменен ctx.
+func (r *Reloader) ReloadOnSignal(ctx context.Context, logger *logging.Logger) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	defer signal.Stop(c)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c:
+			if err := r.Reload(); err != nil {
+				logger.Errorf("tls reload: %s", err.Error())
+				continue
+			}
+			logger.Trace("tls certificates reloaded")
+		}
+	}
+}
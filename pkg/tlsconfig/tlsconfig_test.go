@@ -0,0 +1,263 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pb "github.com/nickzhog/devops-tool/internal/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// certFiles - пути к сертификату и ключу в PEM.
+type certFiles struct {
+	cert, key string
+}
+
+// testPKI генерирует в dir сертификаты CA, сервера (127.0.0.1) и клиента.
+func testPKI(t *testing.T, dir string) (ca, server, client certFiles) {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+	caCert, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	write := func(name string, der []byte, key *ecdsa.PrivateKey) certFiles {
+		files := certFiles{
+			cert: filepath.Join(dir, name+".crt"),
+			key:  filepath.Join(dir, name+".key"),
+		}
+		require.NoError(t, os.WriteFile(files.cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+
+		keyDER, err := x509.MarshalECPrivateKey(key)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(files.key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+		return files
+	}
+
+	issue := func(name string, serial int64, usage x509.ExtKeyUsage) certFiles {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+		require.NoError(t, err)
+		return write(name, der, key)
+	}
+
+	ca = write("ca", caDER, caKey)
+	server = issue("server", 2, x509.ExtKeyUsageServerAuth)
+	client = issue("client", 3, x509.ExtKeyUsageClientAuth)
+	return ca, server, client
+}
+
+// serve запускает gRPC-сервер без реализации методов и возвращает его адрес.
+func serve(t *testing.T, opts ...grpc.ServerOption) string {
+	t.Helper()
+
+	listen, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv := grpc.NewServer(opts...)
+	pb.RegisterMetricsServer(srv, pb.UnimplementedMetricsServer{})
+	go srv.Serve(listen)
+	t.Cleanup(srv.Stop)
+
+	return listen.Addr().String()
+}
+
+// call возвращает код ответа сервера, codes.Unimplemented означает успешное соединение.
+func call(t *testing.T, addr string, creds credentials.TransportCredentials) codes.Code {
+	t.Helper()
+
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(creds))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = pb.NewMetricsClient(conn).GetMetrics(ctx, &pb.GetMetricsRequest{})
+	return status.Code(err)
+}
+
+func TestTLS(t *testing.T) {
+	ca, server, _ := testPKI(t, t.TempDir())
+
+	serverTLS, err := Server(server.cert, server.key, "")
+	require.NoError(t, err)
+	addr := serve(t, grpc.Creds(credentials.NewTLS(serverTLS)))
+
+	clientTLS, err := Client(ca.cert, "", "", "")
+	require.NoError(t, err)
+	assert.Equal(t, codes.Unimplemented, call(t, addr, credentials.NewTLS(clientTLS)))
+
+	// без TLS соединение не устанавливается
+	assert.Equal(t, codes.Unavailable, call(t, addr, insecure.NewCredentials()))
+
+	// сертификат сервера не подписан доверенным CA
+	otherCA, _, _ := testPKI(t, t.TempDir())
+	clientTLS, err = Client(otherCA.cert, "", "", "")
+	require.NoError(t, err)
+	assert.Equal(t, codes.Unavailable, call(t, addr, credentials.NewTLS(clientTLS)))
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca, server, client := testPKI(t, t.TempDir())
+
+	serverTLS, err := Server(server.cert, server.key, ca.cert)
+	require.NoError(t, err)
+	addr := serve(t, grpc.Creds(credentials.NewTLS(serverTLS)))
+
+	clientTLS, err := Client(ca.cert, client.cert, client.key, "")
+	require.NoError(t, err)
+	assert.Equal(t, codes.Unimplemented, call(t, addr, credentials.NewTLS(clientTLS)))
+
+	// без сертификата клиента сервер разрывает соединение
+	clientTLS, err = Client(ca.cert, "", "", "")
+	require.NoError(t, err)
+	assert.Equal(t, codes.Unavailable, call(t, addr, credentials.NewTLS(clientTLS)))
+}
+
+func TestConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	_, server, _ := testPKI(t, dir)
+
+	_, err := Server("", "", "")
+	assert.Error(t, err)
+
+	_, err = Server(server.cert, server.key, filepath.Join(dir, "missing.crt"))
+	assert.Error(t, err)
+
+	_, err = Client(server.key, "", "", "")
+	assert.Error(t, err)
+
+	_, err = Client("", server.cert, "", "")
+	assert.Error(t, err)
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	ca, server, client := testPKI(t, dir)
+
+	reloader, err := NewReloader(server.cert, server.key, ca.cert)
+	require.NoError(t, err)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = reloader.Config()
+	srv.StartTLS()
+	defer srv.Close()
+
+	get := func(caFile string, client certFiles) error {
+		clientTLS, err := Client(caFile, client.cert, client.key, "")
+		require.NoError(t, err)
+
+		httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: clientTLS}}
+		res, err := httpClient.Get(srv.URL)
+		if err != nil {
+			return err
+		}
+		return res.Body.Close()
+	}
+
+	assert.NoError(t, get(ca.cert, client))
+	assert.Error(t, get(ca.cert, certFiles{}))
+
+	oldCA := filepath.Join(dir, "old-ca.crt")
+	data, err := os.ReadFile(ca.cert)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(oldCA, data, 0o600))
+
+	// сертификаты заменены новыми, подписанными другим CA
+	newCA, newServer, newClient := testPKI(t, t.TempDir())
+	for from, to := range map[string]string{
+		newCA.cert:     ca.cert,
+		newServer.cert: server.cert,
+		newServer.key:  server.key,
+	} {
+		data, err := os.ReadFile(from)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(to, data, 0o600))
+	}
+
+	// до перезагрузки действуют прежние сертификаты
+	assert.NoError(t, get(oldCA, client))
+
+	require.NoError(t, reloader.Reload())
+	assert.NoError(t, get(newCA.cert, newClient))
+	assert.Error(t, get(newCA.cert, client))
+
+	// ошибка перезагрузки не сбрасывает действующие сертификаты
+	require.NoError(t, os.WriteFile(server.key, []byte("broken"), 0o600))
+	assert.Error(t, reloader.Reload())
+	assert.NoError(t, get(newCA.cert, newClient))
+}
+
+func TestReloader_GRPC(t *testing.T) {
+	dir := t.TempDir()
+	ca, server, _ := testPKI(t, dir)
+
+	reloader, err := NewReloader(server.cert, server.key, "")
+	require.NoError(t, err)
+	addr := serve(t, grpc.Creds(credentials.NewTLS(reloader.Config())))
+
+	dial := func(caFile string) codes.Code {
+		clientTLS, err := Client(caFile, "", "", "")
+		require.NoError(t, err)
+		return call(t, addr, credentials.NewTLS(clientTLS))
+	}
+
+	assert.Equal(t, codes.Unimplemented, dial(ca.cert))
+
+	// сертификат сервера заменен новым, подписанным другим CA
+	newCA, newServer, _ := testPKI(t, t.TempDir())
+	for from, to := range map[string]string{
+		newServer.cert: server.cert,
+		newServer.key:  server.key,
+	} {
+		data, err := os.ReadFile(from)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(to, data, 0o600))
+	}
+
+	require.NoError(t, reloader.Reload())
+	assert.Equal(t, codes.Unimplemented, dial(newCA.cert))
+	assert.Equal(t, codes.Unavailable, dial(ca.cert))
+}